@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// An item is low on stock once its quantity is at or below its reorder point, an item without a
+// reorder point (0) never is. The alerter raises one alert when an item becomes low and stays quiet
+// until it isn't low anymore (restocked, its reorder point lowered or the item deleted), only then
+// can the next drop raise another one. Every change a request makes passes through auditedStore.record,
+// which hands the items before and after to alerts.observe. The alerter only knows what it has seen since
+// the API started, an item that is still low after a restart alerts again the next time it changes
+// (which also puts it back on the in-app queue, that doesn't survive a restart either)
+
+// StockAlert says an item has run low, ReorderQuantity is how many to order (0 when the item doesn't say)
+type StockAlert struct {
+	ID              string    `json:"id"`
+	PID             string    `json:"pid"`
+	Name            string    `json:"name"`
+	Quantity        int       `json:"quantity"`
+	ReorderPoint    int       `json:"reorderPoint"`
+	ReorderQuantity int       `json:"reorderQuantity"`
+	Timestamp       time.Time `json:"timestamp"`
+	RequestID       string    `json:"requestId"`
+}
+
+// lowOnStock reports whether item is at or below its reorder point, a deleted item never is
+func (item Item) lowOnStock() bool {
+	return item.DeletedAt == nil && item.ReorderPoint > 0 && item.Quantity <= item.ReorderPoint
+}
+
+// alertSink is somewhere alerts go. raise is called once when an item becomes low,
+// clear once when it stops being low, both with the alerter locked so they arrive in order
+type alertSink interface {
+	raise(alert StockAlert)
+	clear(pid string)
+}
+
+// alerter keeps track of which items are low and tells its sinks when that changes
+type alerter struct {
+	mu     sync.Mutex
+	sinks  []alertSink
+	lastID int
+	// low has every item we know to be low, by folded PID, an item we haven't seen change isn't in it
+	low map[string]bool
+	// versions is the last version of every item we looked at, changes that come in out of order
+	// (two requests on the same item finishing together) are older than that and skipped
+	versions map[string]int
+}
+
+// alerts is told about every stock change by auditedStore and remembers which items are low. Until main
+// builds a new one from the -alerts and -alert-webhook flags, an alert is logged and queued for GET /alerts
+var alerts = newAlerter(logSink{}, alertQueue)
+
+func newAlerter(sinks ...alertSink) *alerter {
+	return &alerter{sinks: sinks, low: map[string]bool{}, versions: map[string]int{}}
+}
+
+// observe looks at what one request changed, before and after line up by index and either can be nil
+func (a *alerter) observe(before []Item, after []Item, requestID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if after == nil {
+		// a purged item can't be low, and if its PID is used again it starts over
+		for _, item := range before {
+			key := foldKey(item.PID)
+			if a.low[key] {
+				a.notifyClear(item.PID)
+			}
+			delete(a.low, key)
+			delete(a.versions, key)
+		}
+	}
+	for _, item := range after {
+		key := foldKey(item.PID)
+		if item.Version != 0 && item.Version <= a.versions[key] {
+			continue
+		}
+		a.versions[key] = item.Version
+
+		low := item.lowOnStock()
+		switch {
+		case low && !a.low[key]:
+			a.lastID++
+			alert := StockAlert{
+				ID: fmt.Sprintf("AL-%06d", a.lastID), PID: item.PID, Name: item.Name, Quantity: item.Quantity,
+				ReorderPoint: item.ReorderPoint, ReorderQuantity: item.ReorderQuantity, Timestamp: time.Now().UTC(), RequestID: requestID,
+			}
+			for _, sink := range a.sinks {
+				sink.raise(alert)
+			}
+		case !low && a.low[key]:
+			a.notifyClear(item.PID)
+		}
+		a.low[key] = low
+	}
+}
+
+// notifyClear tells every sink pid isn't low anymore, the caller holds the lock
+func (a *alerter) notifyClear(pid string) {
+	for _, sink := range a.sinks {
+		sink.clear(pid)
+	}
+}
+
+// logSink writes alerts to the log
+type logSink struct{}
+
+func (logSink) raise(alert StockAlert) {
+	log.Printf("LOW STOCK - %v (%v) is down to %v, reorder point %v, reorder %v [alert %v, request %v]",
+		alert.Name, alert.PID, alert.Quantity, alert.ReorderPoint, alert.ReorderQuantity, alert.ID, alert.RequestID)
+}
+
+func (logSink) clear(pid string) {
+	log.Printf("low stock cleared - %v isn't at or below its reorder point anymore", pid)
+}
+
+// webhookSink POSTs every alert as JSON to url. It posts on its own goroutine, a slow or broken
+// receiver never holds up the request that set the alert off, and a failed post is only logged
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) webhookSink {
+	return webhookSink{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s webhookSink) raise(alert StockAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("low stock webhook - could not encode alert %v: %v", alert.ID, err)
+		return
+	}
+	go func() {
+		resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("low stock webhook - could not send alert %v: %v", alert.ID, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("low stock webhook - alert %v was answered with %v", alert.ID, resp.Status)
+		}
+	}()
+}
+
+// the receiver only hears about items running low
+func (webhookSink) clear(pid string) {}
+
+// inAppQueue holds the open alerts for GET /alerts until someone dismisses them or the item is restocked
+type inAppQueue struct {
+	mu     sync.Mutex
+	alerts []StockAlert
+}
+
+// alertQueue is the queue behind GET /alerts
+var alertQueue = &inAppQueue{}
+
+func (q *inAppQueue) raise(alert StockAlert) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.alerts = append(q.alerts, alert)
+}
+
+func (q *inAppQueue) clear(pid string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remove(func(alert StockAlert) bool { return samePID(alert.PID, pid) })
+}
+
+// dismiss takes the alert with the given ID out of the queue, it reports whether it was there
+func (q *inAppQueue) dismiss(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.remove(func(alert StockAlert) bool { return strings.EqualFold(alert.ID, id) }) > 0
+}
+
+// remove drops the alerts that match and returns how many that was, the caller holds the lock
+func (q *inAppQueue) remove(match func(alert StockAlert) bool) int {
+	kept := q.alerts[:0]
+	for _, alert := range q.alerts {
+		if !match(alert) {
+			kept = append(kept, alert)
+		}
+	}
+	removed := len(q.alerts) - len(kept)
+	q.alerts = kept
+	return removed
+}
+
+// list returns a copy of the open alerts, oldest first
+func (q *inAppQueue) list() []StockAlert {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]StockAlert{}, q.alerts...)
+}
+
+// parseAlertSinks turns the -alerts and -alert-webhook flags into the sinks alerts go to
+func parseAlertSinks(names string, webhookURL string) ([]alertSink, error) {
+	var sinks []alertSink
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "log":
+			sinks = append(sinks, logSink{})
+		case "queue":
+			sinks = append(sinks, alertQueue)
+		case "webhook":
+			if webhookURL == "" {
+				return nil, fmt.Errorf("-alerts=webhook needs the URL to post to in -alert-webhook")
+			}
+			sinks = append(sinks, newWebhookSink(webhookURL))
+		default:
+			return nil, fmt.Errorf("unknown alert sink %q in -alerts, expected log, queue or webhook", name)
+		}
+	}
+	return sinks, nil
+}
+
+// employees see what has to be reordered: every item at or below its reorder point, the least stocked first
+func getLowStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getLowStock()")
+
+	low := []Item{}
+	for _, item := range _liveInventory() {
+		if item.lowOnStock() {
+			low = append(low, item)
+		}
+	}
+	sort.SliceStable(low, func(i, j int) bool {
+		return low[i].Quantity-low[i].ReorderPoint < low[j].Quantity-low[j].ReorderPoint
+	})
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(low)
+}
+
+// the in-app queue of alerts that are still open, oldest first
+func getAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getAlerts()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(alertQueue.list())
+}
+
+// dismissing an alert only takes it off the queue, the item doesn't alert again until it has been restocked
+func dismissAlert(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: dismissAlert()")
+
+	id := mux.Vars(r)["id"]
+	if !alertQueue.dismiss(id) {
+		_writeError(w, "dismissAlert", http.StatusNotFound, codeNotFound, "Could not find an open alert: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(alertQueue.list())
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps every alert raised and every PID cleared, in order
+type recordingSink struct {
+	mu      sync.Mutex
+	raised  []StockAlert
+	cleared []string
+}
+
+func (s *recordingSink) raise(alert StockAlert) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.raised = append(s.raised, alert)
+}
+
+func (s *recordingSink) clear(pid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleared = append(s.cleared, pid)
+}
+
+func (s *recordingSink) counts() (raised int, cleared int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.raised), len(s.cleared)
+}
+
+// useAlerts starts the test over with an empty in-app queue and no items known to be low, every alert
+// also goes to the returned recordingSink. The old alerter and queue are put back afterwards
+func useAlerts(t *testing.T) *recordingSink {
+	oldAlerts, oldQueue := alerts, alertQueue
+	recorder := &recordingSink{}
+	alertQueue = &inAppQueue{}
+	alerts = newAlerter(alertQueue, recorder)
+	t.Cleanup(func() { alerts, alertQueue = oldAlerts, oldQueue })
+	return recorder
+}
+
+// checkAlertCounts logs an error when the sink didn't see exactly the expected number of raised and cleared alerts
+func checkAlertCounts(sink *recordingSink, expRaised int, expCleared int, t *testing.T, checkpoint string) {
+	if raised, cleared := sink.counts(); raised != expRaised || cleared != expCleared {
+		t.Errorf("%v -- actual raised - %v cleared - %v | expected raised - %v cleared - %v", checkpoint, raised, cleared, expRaised, expCleared)
+	}
+}
+
+// lowStockReq sends GET /inventory/low-stock and returns the PIDs in it
+func lowStockReq(router http.Handler, t *testing.T) []string {
+	respRecorder := serveRoute(router, "GET", "/inventory/low-stock", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "lowStockReq")
+	var items []Item
+	checkResponseError(json.NewDecoder(respRecorder.Body).Decode(&items), respRecorder, "[]Item", t)
+	pids := []string{}
+	for _, item := range items {
+		pids = append(pids, item.PID)
+	}
+	return pids
+}
+
+// alertsReq sends GET /alerts and returns the open alerts
+func alertsReq(router http.Handler, t *testing.T) []StockAlert {
+	respRecorder := serveRoute(router, "GET", "/alerts", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "alertsReq")
+	var open []StockAlert
+	checkResponseError(json.NewDecoder(respRecorder.Body).Decode(&open), respRecorder, "[]StockAlert", t)
+	return open
+}
+
+func TestLowStockAlerts(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	sink := useAlerts(t)
+	router := newRouter()
+	lettuce, peach := "A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88"
+
+	// 1. giving an item a reorder point it is already under alerts right away ==================================================
+	t.Log("1. set the lettuce's reorder point while it is out of stock")
+	item := updateReq("PATCH", lettuce, map[string]interface{}{"reorderPoint": 5, "reorderQuantity": 24}, http.StatusOK, t)
+	if item.ReorderPoint != 5 || item.ReorderQuantity != 24 {
+		t.Errorf("1 -- actual - %+v | expected the reorder point and quantity", item)
+	}
+	checkAlertCounts(sink, 1, 0, t, "1")
+	open := alertsReq(router, t)
+	if len(open) != 1 || open[0].PID != lettuce || open[0].Quantity != 0 || open[0].ReorderQuantity != 24 || open[0].RequestID == "" {
+		t.Errorf("1 -- actual open alerts - %+v | expected one for the lettuce", open)
+	}
+	checkNames(lowStockReq(router, t), []string{lettuce}, t, "1")
+
+	// 2. restocking resets it, the next drop alerts again but only once =========================================================
+	t.Log("2. receive lettuce, then sell it down past the reorder point")
+	stockReq("receive", lettuce, stockRequest{Quantity: 10}, http.StatusOK, t)
+	checkAlertCounts(sink, 1, 1, t, "2 restocked")
+	if open := alertsReq(router, t); len(open) != 0 {
+		t.Errorf("2 -- actual open alerts - %+v | expected the restock to clear them", open)
+	}
+	stockReq("sell", lettuce, stockRequest{Quantity: 4}, http.StatusOK, t)
+	checkAlertCounts(sink, 1, 1, t, "2 down to 6")
+	stockReq("sell", lettuce, stockRequest{Quantity: 1}, http.StatusOK, t)
+	checkAlertCounts(sink, 2, 1, t, "2 down to 5")
+	stockReq("sell", lettuce, stockRequest{Quantity: 2}, http.StatusOK, t)
+	stockReq("adjust", lettuce, stockRequest{Delta: 1, Reason: "recount"}, http.StatusOK, t)
+	checkAlertCounts(sink, 2, 1, t, "2 still low")
+
+	// 3. dismissing takes it off the queue without resetting it ==================================================================
+	t.Log("3. dismiss the alert and sell some more")
+	open = alertsReq(router, t)
+	if len(open) != 1 {
+		t.Fatalf("3 -- actual open alerts - %+v | expected one", open)
+	}
+	checkStatus(serveRoute(router, "DELETE", "/alerts/"+open[0].ID, nil).Code, http.StatusOK, t, "3 dismiss")
+	decodeAPIError(serveRoute(router, "DELETE", "/alerts/"+open[0].ID, nil), codeNotFound, t, "3 dismiss twice")
+	stockReq("sell", lettuce, stockRequest{Quantity: 1}, http.StatusOK, t)
+	checkAlertCounts(sink, 2, 1, t, "3")
+
+	// 4. the peach runs low too, the least stocked comes first ===================================================================
+	t.Log("4. give the peach a reorder point")
+	stockReq("receive", peach, stockRequest{Quantity: 3}, http.StatusOK, t)
+	updateReq("PATCH", peach, map[string]interface{}{"reorderPoint": 10}, http.StatusOK, t)
+	checkNames(lowStockReq(router, t), []string{peach, lettuce}, t, "4")
+	checkAlertCounts(sink, 3, 1, t, "4")
+
+	// 5. lowering the reorder point or deleting the item clears it ================================================================
+	t.Log("5. lower the lettuce's reorder point and delete the peach")
+	updateReq("PATCH", lettuce, map[string]interface{}{"reorderPoint": 2}, http.StatusOK, t)
+	deleteItemReq(peach, t)
+	checkNames(lowStockReq(router, t), []string{}, t, "5")
+	checkAlertCounts(sink, 3, 3, t, "5")
+
+	// 6. bad reorder fields ========================================================================================================
+	t.Log("6. a negative reorder point")
+	updateReq("PATCH", lettuce, map[string]interface{}{"reorderPoint": -1}, http.StatusBadRequest, t)
+}
+
+// many sales at once cross the reorder point once, so there is exactly one alert
+func TestLowStockAlertsConcurrently(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	sink := useAlerts(t)
+	lettuce := "A12T-4GH7-QPL9-3N4M"
+	stockReq("receive", lettuce, stockRequest{Quantity: 40}, http.StatusOK, t)
+	updateReq("PATCH", lettuce, map[string]interface{}{"reorderPoint": 20}, http.StatusOK, t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveRoute(newRouter(), "POST", "/inventory/"+lettuce+"/sell", stockRequest{Quantity: 1})
+		}()
+	}
+	wg.Wait()
+	checkQuantity(lettuce, 10, t, "after the sales")
+	checkAlertCounts(sink, 1, 0, t, "after the sales")
+}
+
+// two requests on the same item can finish in either order, the older change is skipped rather than alerting twice
+func TestAlerterOutOfOrder(t *testing.T) {
+	sink := &recordingSink{}
+	a := newAlerter(sink)
+	versions := make([]Item, 5)
+	for i, quantity := range []int{6, 5, 4, 10, 3} {
+		versions[i] = Item{PID: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", Quantity: quantity, ReorderPoint: 5, Version: i + 1}
+	}
+	a.observe(versions[1:2], versions[2:3], "second")
+	a.observe(versions[0:1], versions[1:2], "first")
+	checkAlertCounts(sink, 1, 0, t, "out of order")
+	a.observe(versions[2:3], versions[3:4], "restock")
+	a.observe(versions[1:2], versions[2:3], "second again")
+	checkAlertCounts(sink, 1, 1, t, "restocked")
+	a.observe(versions[3:4], versions[4:5], "sold out")
+	a.observe(versions[4:5], nil, "purged")
+	checkAlertCounts(sink, 2, 2, t, "purged")
+}
+
+func TestWebhookAlerts(t *testing.T) {
+	received := make(chan StockAlert, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert StockAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("webhook -- could not decode the alert: %v", err)
+		}
+		received <- alert
+	}))
+	defer receiver.Close()
+
+	sinks, err := parseAlertSinks("webhook", receiver.URL)
+	checkError(err, t)
+	a := newAlerter(sinks...)
+	lettuce := Item{PID: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", Quantity: 6, ReorderPoint: 5, Version: 1}
+	low := lettuce
+	low.Quantity, low.Version = 5, 2
+	a.observe([]Item{lettuce}, []Item{low}, "test")
+
+	select {
+	case alert := <-received:
+		if alert.PID != lettuce.PID || alert.Quantity != 5 || alert.RequestID != "test" {
+			t.Errorf("webhook -- unexpected alert: %+v", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook -- the alert never arrived")
+	}
+
+	if _, err := parseAlertSinks("webhook", ""); err == nil {
+		t.Errorf("parseAlertSinks -- expected an error for a webhook without a URL")
+	}
+	if _, err := parseAlertSinks("log,pager", ""); err == nil {
+		t.Errorf("parseAlertSinks -- expected an error for an unknown sink")
+	}
+}
+
+func TestAlertPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useAlerts(t)
+	router, keyOf := authRouter(t)
+	authReq(router, keyOf[RoleShopper], "GET", "/inventory/low-stock", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleEmployee], "GET", "/inventory/low-stock", http.StatusOK, t)
+	authReq(router, keyOf[RoleExec], "GET", "/alerts", http.StatusOK, t)
+	authReq(router, keyOf[RoleExec], "DELETE", "/alerts/AL-000001", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleEmployee], "DELETE", "/alerts/AL-000001", http.StatusNotFound, t)
+}
@@ -5,22 +5,31 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"testing"
 
 	"github.com/gorilla/mux"
 )
 
+// Money can't hold more than two decimals, so items sent with extra decimals
+// (to check the API rounds them) are typed with a float64 price instead
+type ItemWithFloatPrice struct {
+	PID   string  `json:"pid"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
 // we are hardtyping the different types of bad item submissions
 // that have to do with missing properties
 type BadItemNoCode struct {
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	Name  string `json:"name"`
+	Price Money  `json:"price"`
 }
 
 type BadItemNoName struct {
-	PID   string  `json:"pid"`
-	Price float64 `json:"price"`
+	PID   string `json:"pid"`
+	Price Money  `json:"price"`
 }
 
 type BadItemNoPrice struct {
@@ -163,7 +172,8 @@ func deleteItemNotFoundReq(badPID string, t *testing.T) {
 
 // addItemReq is used to create and send a request for POST /inventory/addItem
 // checks for errors and returns the current inventory in the API
-func addItemReq(item Item, t *testing.T) []Item {
+// item is usually an Item but can be anything that marshals into one (e.g. ItemWithFloatPrice)
+func addItemReq(item interface{}, t *testing.T) []Item {
 	// we must marshall the provided golang object into a json object
 	body, err := json.Marshal(item)
 	checkError(err, t)
@@ -188,7 +198,7 @@ func addItemReq(item Item, t *testing.T) []Item {
 
 // addItemReqs is functionally identical to the above function but goes to POST /inventory/addItems
 // so multiple items can be added at once
-func addItemsReq(items []Item, t *testing.T) []Item {
+func addItemsReq(items interface{}, t *testing.T) []Item {
 
 	body, err := json.Marshal(items)
 	checkError(err, t)
@@ -208,10 +218,19 @@ func addItemsReq(items []Item, t *testing.T) []Item {
 
 // addBadItemAllCasesReq will take a given item and walk through all the different desired
 // cases of bad item object formats that will be expected to return a 400 Bad Request
+// every case also has to point at the field that is wrong
 func addBadItemAllCasesReq(item Item, t *testing.T) {
-	badCases := []string{"No Name", "No Code", "No Price", "Bad PID format", "Not Unique PID"}
+	badCases := map[string]string{
+		"No Name":           "name",
+		"No Code":           "pid",
+		"No Price":          "price",
+		"Bad PID format":    "pid",
+		"Not Unique PID":    "pid",
+		"Negative Quantity": "quantity",
+		"Negative Price":    "price",
+	}
 
-	for _, badCase := range badCases {
+	for badCase, field := range badCases {
 		body, err := json.Marshal(_buildBadItem(badCase, item))
 		checkError(err, t)
 
@@ -220,6 +239,10 @@ func addBadItemAllCasesReq(item Item, t *testing.T) {
 
 		respRecorder := recordResponse(addItem, req, t)
 		checkStatus(respRecorder.Code, http.StatusBadRequest, t, "addBadItemAllCasesReq")
+		apiErr := decodeAPIError(respRecorder, codeValidationFailed, t, "addBadItemAllCasesReq "+badCase)
+		if len(apiErr.Details) != 1 || apiErr.Details[0].Field != field {
+			t.Errorf("addBadItemAllCasesReq %v -- expected one problem with %v, got: %+v", badCase, field, apiErr.Details)
+		}
 	}
 }
 
@@ -248,11 +271,27 @@ func _buildBadItem(badCase string, item Item) interface{} {
 	case "Not Unique PID":
 		item.PID = "E5T6-9UI3-TH15-QR88" // this is the Peach PID
 		return item
+	case "Negative Quantity":
+		item.Quantity = -1
+		return item
+	case "Negative Price":
+		item.Price = -item.Price
+		return item
 	}
 	// should never reach this next line of code, but what the compiler wants, the compiler gets
 	return Item{}
 }
 
+// sameItem compares two items field by field, no tags and an empty list of tags are the same.
+// The version is left out, the store picks it (history_test.go checks it)
+func sameItem(a Item, b Item) bool {
+	if len(a.Tags) == 0 && len(b.Tags) == 0 {
+		a.Tags, b.Tags = nil, nil
+	}
+	a.Version, b.Version = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
 // we frequently evaluate expected inventory with actual, when relevant
 // after we check and log any inconcsistencies, we return actual so it can be
 // set to expInventory so we can proceed with the rest of the tests regardless
@@ -262,7 +301,7 @@ func compareActualWithExpected(actual []Item, expected []Item, t *testing.T, che
 			checkpointNumber, len(actual), len(expected))
 	} else {
 		for i := range actual {
-			if actual[i] != expected[i] {
+			if !sameItem(actual[i], expected[i]) {
 				t.Errorf("%v -- actual and expected inventories differ at i == %v: actual[i] - %v | expected[i] - %v",
 					checkpointNumber, i, actual[i], expected[i])
 			}
@@ -278,22 +317,22 @@ func TestAPI(t *testing.T) {
 		{
 			PID:   "A12T-4GH7-QPL9-3N4M",
 			Name:  "Lettuce",
-			Price: 3.46,
+			Price: 346,
 		},
 		{
 			PID:   "E5T6-9UI3-TH15-QR88",
 			Name:  "Peach",
-			Price: 2.99,
+			Price: 299,
 		},
 		{
 			PID:   "YRT6-72AS-K736-L4AR",
 			Name:  "Green Pepper",
-			Price: 0.79,
+			Price: 79,
 		},
 		{
 			PID:   "TQ4C-VV6T-75ZX-1RMR",
 			Name:  "Gala Apple",
-			Price: 3.59,
+			Price: 359,
 		},
 	}
 
@@ -327,7 +366,8 @@ func TestAPI(t *testing.T) {
 	t.Log("3. add Tomato")
 
 	// we can test the 2 decimal requirement by sending in 3 decimals and expecting to only get 2 back
-	tomato_with_3_decimals := Item{
+	// 3.355 is exactly half a cent, the default half-even rounding goes to the even cent 3.36
+	tomato_with_3_decimals := ItemWithFloatPrice{
 		PID:   "M4N5-F0C3-F4gk-si00",
 		Name:  "Tomato",
 		Price: 3.355,
@@ -335,7 +375,7 @@ func TestAPI(t *testing.T) {
 	tomato_expected := Item{
 		PID:   "M4N5-F0C3-F4gk-si00",
 		Name:  "Tomato",
-		Price: 3.35,
+		Price: 336,
 	}
 
 	expInventory = append(expInventory, tomato_expected)
@@ -348,7 +388,7 @@ func TestAPI(t *testing.T) {
 	t.Log("4. add Pickle, Broccoli, Chicken Breast")
 
 	// we can just initialize the expected array (2_decimal) and the submitted (3_decimal) array side by side
-	items_with_3_decimals := []Item{
+	items_with_3_decimals := []ItemWithFloatPrice{
 		{
 			PID:   "F4J6-D4M2-J0G5-G3E5",
 			Name:  "Pickle",
@@ -369,17 +409,17 @@ func TestAPI(t *testing.T) {
 		{
 			PID:   "F4J6-D4M2-J0G5-G3E5",
 			Name:  "Pickle",
-			Price: 1.3,
+			Price: 130,
 		},
 		{
 			PID:   "0g44-gm33-4jf9-FGM4",
 			Name:  "Broccoli",
-			Price: 2.21,
+			Price: 221,
 		},
 		{
 			PID:   "1A2S-3F5G-6HJ7-4R6V",
 			Name:  "Chicken Breast",
-			Price: 6.49,
+			Price: 649,
 		},
 	}
 	expInventory = append(expInventory, items_expected...)
@@ -412,7 +452,7 @@ func TestAPI(t *testing.T) {
 	good_potato := Item{
 		PID:   "b6N3-C5X3-Z0F6-2K0J",
 		Name:  "potato",
-		Price: 0.49,
+		Price: 49,
 	}
 	// we don't need to compare actual and expected cus we just expect to get 400's back
 	// on all 5 of the different requests that addBadItemAllCasesReq will send out
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// every API key looks like gk_<id>_<secret>, the id finds the key and the secret proves you have it
+const keyPrefix = "gk_"
+
+// APIKey is what we know about a key, everything but the secret. A revoked key is kept
+// so the key list still shows who had it, it just doesn't authenticate anymore
+type APIKey struct {
+	ID        string       `json:"id"`
+	Principal string       `json:"principal"`
+	Role      Role         `json:"role"`
+	Scopes    []Permission `json:"scopes,omitempty"`
+	// SupplierID is the supplier a supplier key works for, it can only receive that supplier's purchase orders
+	SupplierID string     `json:"supplierId,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (k APIKey) principal() Principal {
+	return Principal{ID: k.Principal, Role: k.Role, Scopes: k.Scopes, KeyID: k.ID, SupplierID: k.SupplierID}
+}
+
+// storedKey is a key the way it is saved, the secret itself is never written anywhere,
+// only a SHA-256 of it. The secrets are 32 random bytes, too many to guess even knowing the hash
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// keyStore holds every API key, in memory and in the -keys file when there is one
+type keyStore struct {
+	mu   sync.RWMutex
+	path string
+	keys map[string]*storedKey
+}
+
+// keys is what bearerAuth checks every request's key against and what the /admin/keys handlers
+// create and revoke keys in. It starts out empty, with -auth on main replaces it with the -keys file
+var keys = newKeyStore()
+
+var errKeyNotFound = errors.New("no such API key")
+
+func newKeyStore() *keyStore {
+	return &keyStore{keys: map[string]*storedKey{}}
+}
+
+// openKeyStore loads the keys saved at path, a file that doesn't exist yet is an empty store
+// and is written the first time a key is created
+func openKeyStore(path string) (*keyStore, error) {
+	s := newKeyStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	for i := range stored {
+		s.keys[stored[i].ID] = &stored[i]
+	}
+	return s, nil
+}
+
+// create makes a new key and returns it with the whole key string, the only time that string is ever seen
+func (s *keyStore) create(principal string, role Role, scopes []Permission, supplierID string) (APIKey, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	key := storedKey{
+		APIKey: APIKey{ID: hex.EncodeToString(id), Principal: principal, Role: role, Scopes: scopes, SupplierID: supplierID, CreatedAt: time.Now().UTC()},
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashSecret(encodedSecret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = &key
+	if err := s.save(); err != nil {
+		delete(s.keys, key.ID)
+		return APIKey{}, "", err
+	}
+	return key.APIKey, keyPrefix + key.ID + "_" + encodedSecret, nil
+}
+
+// revoke stops a key from authenticating, and every token signed for it with it. Revoking
+// a key twice is fine, it keeps the time it was first revoked
+func (s *keyStore) revoke(id string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, found := s.keys[id]
+	if !found {
+		return APIKey{}, errKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		if err := s.save(); err != nil {
+			key.RevokedAt = nil
+			return APIKey{}, err
+		}
+	}
+	return key.APIKey, nil
+}
+
+// list returns every key, revoked ones too, oldest first
+func (s *keyStore) list() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		list = append(list, key.APIKey)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// active returns the key with the given id as long as it hasn't been revoked
+func (s *keyStore) active(id string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, found := s.keys[id]
+	if !found || key.RevokedAt != nil {
+		return APIKey{}, false
+	}
+	return key.APIKey, true
+}
+
+// hasActive reports whether any key with the role still works, main makes an admin key when there isn't one
+func (s *keyStore) hasActive(role Role) bool {
+	for _, key := range s.list() {
+		if key.Role == role && key.RevokedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *keyStore) authenticate(credential string) (Principal, error) {
+	parts := strings.SplitN(strings.TrimPrefix(credential, keyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return Principal{}, ErrUnauthenticated
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, found := s.keys[parts[0]]
+	if !found || key.RevokedAt != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(key.Hash)) != 1 {
+		return Principal{}, ErrUnauthenticated
+	}
+	return key.principal(), nil
+}
+
+// save writes every key to the file, the caller holds the lock. A store without a path only lives in memory
+func (s *keyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	stored := make([]storedKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		stored = append(stored, *key)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// _checkScopes makes sure every scope is something the role can do anyway, a scope
+// can only take permissions away. field is where the scopes were in the request body
+func _checkScopes(role Role, scopes []Permission, field string) []fieldError {
+	var problems []fieldError
+	for _, scope := range scopes {
+		if !roleCan(role, scope) {
+			problems = append(problems, fieldError{Field: field, Message: fmt.Sprintf("%v isn't something a %v can do", scope, role)})
+		}
+	}
+	return problems
+}
+
+// keyRequest is the body of POST /admin/keys, SupplierID is only for supplier keys
+type keyRequest struct {
+	Principal  string       `json:"principal"`
+	Role       Role         `json:"role"`
+	Scopes     []Permission `json:"scopes"`
+	SupplierID string       `json:"supplierId"`
+}
+
+// keyResponse is an APIKey along with the whole key string, only POST /admin/keys sends it
+type keyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// admins give every POS terminal and supplier integration its own key, the key string is
+// in the response and nowhere else, it can't be looked up again later
+func createKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: createKey()")
+
+	var request keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		_writeError(w, "createKey", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the key request. Please provide a JSON object with a 'principal' and a 'role'.", _decodeProblem(err))
+		return
+	}
+	var problems []fieldError
+	request.Principal = strings.TrimSpace(request.Principal)
+	if request.Principal == "" {
+		problems = append(problems, fieldError{Field: "principal", Message: "is required"})
+	}
+	if _, known := rolePermissions[request.Role]; !known {
+		problems = append(problems, fieldError{Field: "role", Message: "has to be shopper, employee, supplier, exec or admin"})
+	} else {
+		problems = append(problems, _checkScopes(request.Role, request.Scopes, "scopes")...)
+	}
+	// a supplier key without a supplier can still read the orders, it just can't receive any of them
+	if request.SupplierID != "" {
+		if supplier, found := suppliers.get(request.SupplierID); request.Role != RoleSupplier {
+			problems = append(problems, fieldError{Field: "supplierId", Message: "is only for supplier keys"})
+		} else if !found {
+			problems = append(problems, fieldError{Field: "supplierId", Message: "isn't a registered supplier"})
+		} else {
+			request.SupplierID = supplier.ID
+		}
+	}
+	if len(problems) > 0 {
+		_writeError(w, "createKey", http.StatusBadRequest, codeValidationFailed, "The key request has invalid fields.", problems...)
+		return
+	}
+
+	key, secret, err := keys.create(request.Principal, request.Role, request.Scopes, request.SupplierID)
+	if err != nil {
+		log.Printf("500 error - createKey(): %v", err)
+		_writeError(w, "createKey", http.StatusInternalServerError, codeInternal, "Could not save the API keys, please try again.")
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(keyResponse{Key: secret, APIKey: key})
+}
+
+func getKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getKeys()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(keys.list())
+}
+
+// a lost terminal or a supplier we stopped working with loses access right away
+func revokeKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: revokeKey()")
+
+	key, err := keys.revoke(mux.Vars(r)["id"])
+	if errors.Is(err, errKeyNotFound) {
+		_writeError(w, "revokeKey", http.StatusNotFound, codeNotFound, "Could not find an API key with that id.")
+		return
+	}
+	if err != nil {
+		log.Printf("500 error - revokeKey(): %v", err)
+		_writeError(w, "revokeKey", http.StatusInternalServerError, codeInternal, "Could not save the API keys, please try again.")
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(key)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createKeyReq has the admin create a key through the router, it returns the whole key string and the key
+func createKeyReq(router http.Handler, adminKey string, request keyRequest, expStatus int, t *testing.T) (string, APIKey) {
+	respRecorder := serveAs(router, adminKey, "POST", "/admin/keys", request)
+	checkStatus(respRecorder.Code, expStatus, t, "createKeyReq "+request.Principal)
+
+	var response keyResponse
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&response)
+		checkResponseError(err, respRecorder, "keyResponse", t)
+	}
+	return response.Key, response.APIKey
+}
+
+func TestAPIKeys(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router, keyOf := authRouter(t)
+	admin := keyOf[RoleAdmin]
+
+	// 1. the admin gives a POS terminal its own key, only allowed to sell ===================================================
+	t.Log("1. create a scoped key for a terminal")
+	posKey, pos := createKeyReq(router, admin, keyRequest{Principal: "pos-7", Role: RoleEmployee, Scopes: []Permission{PermStockSell}}, http.StatusOK, t)
+	if !strings.HasPrefix(posKey, keyPrefix+pos.ID+"_") || pos.Principal != "pos-7" || pos.RevokedAt != nil {
+		t.Errorf("1 -- actual key - %v %+v | expected a new active key for pos-7", posKey, pos)
+	}
+	authReq(router, posKey, "POST", "/inventory/E5T6-9UI3-TH15-QR88/sell", http.StatusBadRequest, t)
+	authReq(router, posKey, "GET", "/inventory", http.StatusForbidden, t)
+
+	// 2. requests the admin can't make ========================================================================================
+	t.Log("2. bad key requests")
+	createKeyReq(router, admin, keyRequest{Role: RoleEmployee}, http.StatusBadRequest, t)
+	createKeyReq(router, admin, keyRequest{Principal: "pos-8", Role: "manager"}, http.StatusBadRequest, t)
+	createKeyReq(router, admin, keyRequest{Principal: "farm-co", Role: RoleSupplier, Scopes: []Permission{PermStockSell}}, http.StatusBadRequest, t)
+	createKeyReq(router, keyOf[RoleEmployee], keyRequest{Principal: "pos-8", Role: RoleAdmin}, http.StatusForbidden, t)
+
+	// 3. the list never shows a secret or a hash ==============================================================================
+	t.Log("3. list the keys")
+	respRecorder := authReq(router, admin, "GET", "/admin/keys", http.StatusOK, t)
+	body := respRecorder.Body.String()
+	var list []APIKey
+	checkError(json.Unmarshal([]byte(body), &list), t)
+	if len(list) != 6 || strings.Contains(body, "hash") || strings.Contains(body, strings.TrimPrefix(posKey, keyPrefix+pos.ID+"_")) {
+		t.Errorf("3 -- actual list - %v | expected 6 keys without secrets or hashes", body)
+	}
+
+	// 4. a revoked key stops working at once ==================================================================================
+	t.Log("4. revoke the terminal's key")
+	respRecorder = authReq(router, admin, "DELETE", "/admin/keys/"+pos.ID, http.StatusOK, t)
+	var revoked APIKey
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&revoked), t)
+	if revoked.RevokedAt == nil {
+		t.Errorf("4 -- actual - %+v | expected a revokedAt", revoked)
+	}
+	authReq(router, posKey, "POST", "/inventory/E5T6-9UI3-TH15-QR88/sell", http.StatusUnauthorized, t)
+	authReq(router, admin, "DELETE", "/admin/keys/"+pos.ID, http.StatusOK, t)
+	decodeAPIError(authReq(router, admin, "DELETE", "/admin/keys/0000000000000000", http.StatusNotFound, t), codeNotFound, t, "4 unknown key")
+}
+
+func TestKeyStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	s, err := openKeyStore(path)
+	checkError(err, t)
+	if s.hasActive(RoleAdmin) {
+		t.Errorf("a new key store already has an admin")
+	}
+	_, secret, err := s.create("admin", RoleAdmin, nil, "")
+	checkError(err, t)
+
+	// the file only has the hash, and the key still works after a restart
+	data, err := os.ReadFile(path)
+	checkError(err, t)
+	if strings.Contains(string(data), secret[len(secret)-20:]) {
+		t.Errorf("the key file has the secret in it: %s", data)
+	}
+	reopened, err := openKeyStore(path)
+	checkError(err, t)
+	principal, err := reopened.authenticate(secret)
+	checkError(err, t)
+	if principal.ID != "admin" || principal.Role != RoleAdmin || !reopened.hasActive(RoleAdmin) {
+		t.Errorf("actual principal after reopening - %+v | expected the admin", principal)
+	}
+}
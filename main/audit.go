@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AuditEvent is one change to one item: who made it, through which route, and what the item
+// looked like before and after. Before is null for an added item and After for a purged one
+type AuditEvent struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Role      Role      `json:"role,omitempty"`
+	KeyID     string    `json:"keyId,omitempty"`
+	Route     string    `json:"route"`
+	PID       string    `json:"pid"`
+	Before    *Item     `json:"before"`
+	After     *Item     `json:"after"`
+	RequestID string    `json:"requestId"`
+}
+
+// auditLog is the audit trail. It is kept apart from the inventory, so deleting an item
+// doesn't delete its history, and events are only ever appended
+type auditLog struct {
+	mu     sync.RWMutex
+	events []AuditEvent
+	lastID int
+	// file has every event as a line of JSON, nil keeps the trail in memory only
+	file *os.File
+}
+
+// audit is the trail every handler records into, main opens the -audit file for it
+var audit = newAuditLog()
+
+func newAuditLog() *auditLog {
+	return &auditLog{}
+}
+
+// openAuditLog loads the events saved at path and appends new ones to it. A last line that was only
+// partly written when the API was killed is cut off, the change it was about was already made
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &auditLog{file: file}
+
+	var good int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("audit log %v ends in a torn event, dropping it", path)
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		var event AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%v: event %d: %w", path, len(l.events)+1, err)
+		}
+		l.events = append(l.events, event)
+		good += int64(len(line))
+	}
+	l.lastID = len(l.events)
+	if err := file.Truncate(good); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// record gives the events their ids and appends them, to the file first when there is one.
+// Without a file the events are never encoded, a batch of 100k items is 100k events
+func (l *auditLog) record(events []AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range events {
+		events[i].ID = fmt.Sprintf("AU-%06d", l.lastID+i+1)
+	}
+	if l.file != nil {
+		var lines bytes.Buffer
+		encoder := json.NewEncoder(&lines)
+		for _, event := range events {
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+		}
+		if _, err := l.file.Write(lines.Bytes()); err != nil {
+			return err
+		}
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+	}
+	l.lastID += len(events)
+	l.events = append(l.events, events...)
+	return nil
+}
+
+func (l *auditLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// auditQuery picks events out of the trail, an empty field doesn't filter. From is inclusive, to isn't
+type auditQuery struct {
+	pid   string
+	actor string
+	from  time.Time
+	to    time.Time
+}
+
+// list returns the events that match q, oldest first
+func (l *auditLog) list(q auditQuery) []AuditEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	events := []AuditEvent{}
+	for _, event := range l.events {
+		if q.pid != "" && !samePID(event.PID, q.pid) {
+			continue
+		}
+		if q.actor != "" && event.Actor != q.actor {
+			continue
+		}
+		if event.Timestamp.Before(q.from) || (!q.to.IsZero() && !event.Timestamp.Before(q.to)) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// auditedStore is the store as one request sees it, every change made through it is recorded in
+// the audit trail with the request's principal, route and id. Handlers that change the inventory
+// go through _auditedStore(w, r) instead of store, reads don't need to. Since every change passes
+// through here it is also where the low-stock alerts are raised (alerts.go) and the webhook events sent (webhooks.go)
+type auditedStore struct {
+	Store
+	w http.ResponseWriter
+	r *http.Request
+}
+
+func _auditedStore(w http.ResponseWriter, r *http.Request) Store {
+	return auditedStore{Store: store, w: w, r: r}
+}
+
+func (s auditedStore) Add(item Item) error {
+	return s.AddBatch([]Item{item})
+}
+
+func (s auditedStore) AddBatch(items []Item) error {
+	if err := s.Store.AddBatch(items); err != nil {
+		return err
+	}
+	s.record(nil, items)
+	return nil
+}
+
+// Update goes through Modify so the item it replaces can be recorded too
+func (s auditedStore) Update(item Item) error {
+	_, err := s.Modify(item.PID, func(current *Item) error {
+		*current = item
+		return nil
+	})
+	return err
+}
+
+func (s auditedStore) Delete(pid string) (Item, error) {
+	return s.DeleteIf(pid, nil)
+}
+
+func (s auditedStore) DeleteIf(pid string, check func(item Item) error) (Item, error) {
+	deleted, err := s.Store.DeleteIf(pid, check)
+	if err != nil {
+		return Item{}, err
+	}
+	s.record([]Item{deleted}, nil)
+	return deleted, nil
+}
+
+func (s auditedStore) Modify(pid string, change func(item *Item) error) (Item, error) {
+	return modifyOne(s, pid, change)
+}
+
+func (s auditedStore) ModifyBatch(pids []string, change func(items []*Item) error) ([]Item, error) {
+	return s.recordModify(change, func(change func(items []*Item) error) ([]Item, error) {
+		return s.Store.ModifyBatch(pids, change)
+	})
+}
+
+func (s auditedStore) RecordTransaction(pids []string, tx *Transaction, change func(items []*Item) error) ([]Item, error) {
+	return s.recordModify(change, func(change func(items []*Item) error) ([]Item, error) {
+		return s.Store.RecordTransaction(pids, tx, change)
+	})
+}
+
+// recordModify runs modify with change wrapped so it sees the items before they are changed, and records both
+func (s auditedStore) recordModify(change func(items []*Item) error, modify func(change func(items []*Item) error) ([]Item, error)) ([]Item, error) {
+	var before []Item
+	modified, err := modify(func(items []*Item) error {
+		// change gets copies, so these are the items exactly as they were
+		before = make([]Item, len(items))
+		for i, item := range items {
+			before[i] = _snapshot(*item)
+		}
+		return change(items)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.record(before, modified)
+	return modified, nil
+}
+
+// record adds an event for every item, before and after line up by index and either can be nil.
+// The change has already been made by now, so a trail that can't be written is logged rather than failed
+func (s auditedStore) record(before []Item, after []Item) {
+	actor := Principal{ID: "anonymous"}
+	if principal, found := principalOf(s.r); found {
+		actor = principal
+	}
+	route := s.r.URL.Path
+	if current := mux.CurrentRoute(s.r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			route = template
+		}
+	}
+	template := AuditEvent{
+		Timestamp: time.Now().UTC(), Actor: actor.ID, Role: actor.Role, KeyID: actor.KeyID,
+		Route: s.r.Method + " " + route, RequestID: _requestID(s.w),
+	}
+
+	count := len(before)
+	if len(after) > count {
+		count = len(after)
+	}
+	events := make([]AuditEvent, count)
+	for i := range events {
+		events[i] = template
+		if before != nil {
+			item := _snapshot(before[i])
+			events[i].Before = &item
+			events[i].PID = item.PID
+		}
+		if after != nil {
+			item := _snapshot(after[i])
+			events[i].After = &item
+			events[i].PID = item.PID
+		}
+	}
+	if err := audit.record(events); err != nil {
+		log.Printf("AUDIT FAILURE - %v by %v was made but could not be recorded: %v [request %v]",
+			template.Route, template.Actor, err, template.RequestID)
+	}
+	alerts.observe(before, after, template.RequestID)
+	webhooks.observe(before, after, template.RequestID)
+}
+
+// _snapshot copies item with its own tags, so nothing that changes the item later can reach into the trail
+func _snapshot(item Item) Item {
+	item.Tags = append([]string(nil), item.Tags...)
+	return item
+}
+
+// exec staff find out who changed an item and what it looked like, even long after it was deleted
+// every filter is optional: ?pid= (case-insensitive), ?actor=, and ?from= and ?to= (RFC 3339 or YYYY-MM-DD, UTC)
+func getAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getAudit()")
+
+	query := r.URL.Query()
+	q := auditQuery{pid: strings.TrimSpace(query.Get("pid")), actor: strings.TrimSpace(query.Get("actor"))}
+	var problems []fieldError
+	var err error
+	if q.from, err = _parseReportTime(query.Get("from"), false, time.UTC); err != nil {
+		problems = append(problems, fieldError{Field: "from", Message: "has to be an RFC 3339 time or a YYYY-MM-DD date"})
+	}
+	if q.to, err = _parseReportTime(query.Get("to"), true, time.UTC); err != nil {
+		problems = append(problems, fieldError{Field: "to", Message: "has to be an RFC 3339 time or a YYYY-MM-DD date"})
+	}
+	if len(problems) == 0 && !q.to.IsZero() && !q.to.After(q.from) {
+		problems = append(problems, fieldError{Field: "to", Message: "has to be after from"})
+	}
+	if len(problems) > 0 {
+		_writeError(w, "getAudit", http.StatusBadRequest, codeInvalidParameter, "Could not understand the audit filters.", problems...)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(audit.list(q))
+}
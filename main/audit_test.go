@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useAudit points the handlers at l for the rest of the test and puts the old trail back afterwards
+func useAudit(l *auditLog, t testing.TB) {
+	old := audit
+	audit = l
+	t.Cleanup(func() { audit = old })
+}
+
+// auditReq has credential query GET /audit and returns the events
+func auditReq(router http.Handler, credential string, query string, t *testing.T) []AuditEvent {
+	respRecorder := authReq(router, credential, "GET", "/audit"+query, http.StatusOK, t)
+	var events []AuditEvent
+	err := json.NewDecoder(respRecorder.Body).Decode(&events)
+	checkResponseError(err, respRecorder, "[]AuditEvent", t)
+	return events
+}
+
+func TestAuditTrail(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	useAudit(newAuditLog(), t)
+	router, keyOf := authRouter(t)
+	employee, exec := keyOf[RoleEmployee], keyOf[RoleExec]
+	fig := Item{PID: "F1G0-0000-0000-0001", Name: "Fig", Price: 250}
+	start := time.Now().UTC().Add(-time.Second)
+
+	// 1. an employee adds a fig, reprices it and sells two, then an exec deletes it =========================================
+	t.Log("1. add, patch, sell and delete the fig")
+	checkStatus(serveAs(router, employee, "POST", "/inventory/addItem", fig).Code, http.StatusOK, t, "1 addItem")
+	checkStatus(serveAs(router, employee, "PATCH", "/inventory/"+fig.PID, map[string]interface{}{"price": 3}).Code, http.StatusOK, t, "1 patch")
+	checkStatus(serveAs(router, employee, "POST", "/inventory/"+fig.PID+"/receive", stockRequest{Quantity: 5}).Code, http.StatusOK, t, "1 receive")
+	checkStatus(serveAs(router, employee, "POST", "/transactions",
+		Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: fig.PID, Quantity: 2}}}).Code, http.StatusOK, t, "1 sale")
+	// a change that fails isn't an event
+	checkStatus(serveAs(router, employee, "POST", "/inventory/"+fig.PID+"/sell", stockRequest{Quantity: 10}).Code, http.StatusConflict, t, "1 oversell")
+	respRecorder := serveAs(router, exec, "DELETE", "/inventory/"+fig.PID, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "1 delete")
+
+	// 2. the fig's whole history is still there after it was deleted ==========================================================
+	t.Log("2. the fig's history")
+	events := auditReq(router, exec, "?pid=f1g0-0000-0000-0001", t)
+	routes := make([]string, len(events))
+	for i, event := range events {
+		routes[i] = event.Route
+	}
+	checkNames(routes, []string{"POST /inventory/addItem", "PATCH /inventory/{pid}", "POST /inventory/{pid}/receive",
+		"POST /transactions", "DELETE /inventory/{pid}"}, t, "2 routes")
+	if len(events) != 5 {
+		t.FailNow()
+	}
+	if events[0].Before != nil || events[0].After == nil || events[0].After.Name != "Fig" || events[0].Actor != "test-employee" {
+		t.Errorf("2 -- the first event should be the employee adding the fig, got: %+v", events[0])
+	}
+	if events[1].Before.Price != 250 || events[1].After.Price != 300 {
+		t.Errorf("2 -- the patch should take the price from 2.50 to 3.00, got: %+v -> %+v", events[1].Before, events[1].After)
+	}
+	if events[3].Before.Quantity != 5 || events[3].After.Quantity != 3 {
+		t.Errorf("2 -- the sale should take the quantity from 5 to 3, got: %+v -> %+v", events[3].Before, events[3].After)
+	}
+	last := events[4]
+	if last.Before == nil || last.Before.DeletedAt != nil || last.After == nil || last.After.DeletedAt == nil || last.Actor != "test-exec" || last.Role != RoleExec ||
+		last.RequestID != respRecorder.Header().Get(requestIDHeader) || last.Timestamp.Before(start) {
+		t.Errorf("2 -- the last event should be the exec marking the fig deleted, got: %+v", last)
+	}
+
+	// 3. filter by actor and time ===============================================================================================
+	t.Log("3. filters")
+	if events := auditReq(router, exec, "?actor=test-exec", t); len(events) != 1 || events[0].PID != fig.PID {
+		t.Errorf("3 actor -- actual - %+v | expected just the delete", events)
+	}
+	if events := auditReq(router, exec, "?from="+time.Now().UTC().Add(time.Hour).Format(time.RFC3339), t); len(events) != 0 {
+		t.Errorf("3 from -- actual - %+v | expected no events from the future", events)
+	}
+	if events := auditReq(router, exec, "?to="+start.Format(time.RFC3339), t); len(events) != 0 {
+		t.Errorf("3 to -- actual - %+v | expected no events from before the test", events)
+	}
+	decodeAPIError(authReq(router, exec, "GET", "/audit?from=yesterday", http.StatusBadRequest, t), codeInvalidParameter, t, "3 bad from")
+	authReq(router, employee, "GET", "/audit", http.StatusForbidden, t)
+}
+
+func TestAuditLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := openAuditLog(path)
+	checkError(err, t)
+	defer l.Close()
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+	checkError(l.record([]AuditEvent{{Actor: "pos-1", Route: "POST /inventory/addItem", PID: pear.PID, After: &pear}}), t)
+	checkError(l.record([]AuditEvent{{Actor: "cfo", Route: "DELETE /inventory/{pid}", PID: pear.PID, Before: &pear}}), t)
+
+	// the API dies halfway through writing a third event
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	checkError(err, t)
+	_, err = file.WriteString(`{"id":"AU-000003","actor":"po`)
+	checkError(err, t)
+	checkError(file.Close(), t)
+
+	reopened, err := openAuditLog(path)
+	checkError(err, t)
+	defer reopened.Close()
+	events := reopened.list(auditQuery{pid: pear.PID})
+	if len(events) != 2 || events[0].ID != "AU-000001" || events[1].Before == nil || events[1].Before.Name != "Pear" {
+		t.Errorf("actual events after reopening - %+v | expected the add and the delete", events)
+	}
+	// the torn event is gone, so the next one starts on a line of its own
+	checkError(reopened.record([]AuditEvent{{Actor: "pos-1", PID: pear.PID}}), t)
+	checkError(reopened.Close(), t)
+	reopened, err = openAuditLog(path)
+	checkError(err, t)
+	if events := reopened.list(auditQuery{}); len(events) != 3 || events[2].ID != "AU-000003" {
+		t.Errorf("actual events - %+v | expected 3", events)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Role is the kind of user a principal is, it decides which routes they may call
+type Role string
+
+const (
+	RoleShopper  Role = "shopper"  // customers at the kiosk, they can only look
+	RoleEmployee Role = "employee" // store staff, they keep the inventory and post transactions
+	RoleSupplier Role = "supplier" // they drop off shipments
+	RoleExec     Role = "exec"     // exec staff, they read the reports and the books
+	RoleAdmin    Role = "admin"    // they hand out and revoke API keys and manage the webhooks, nothing else
+)
+
+// Permission is one thing a route lets you do, every route needs exactly one of them
+type Permission string
+
+const (
+	PermInventoryRead     Permission = "inventory:read"
+	PermInventoryWrite    Permission = "inventory:write"
+	PermInventoryDelete   Permission = "inventory:delete"
+	PermInventoryPurge    Permission = "inventory:purge"
+	PermStockReceive      Permission = "stock:receive"
+	PermStockSell         Permission = "stock:sell"
+	PermStockAdjust       Permission = "stock:adjust"
+	PermTransactionsRead  Permission = "transactions:read"
+	PermTransactionsPost  Permission = "transactions:post"
+	PermReportsRead       Permission = "reports:read"
+	PermKeysManage        Permission = "keys:manage"
+	PermTokensIssue       Permission = "tokens:issue"
+	PermAuditRead         Permission = "audit:read"
+	PermHistoryRead       Permission = "history:read"
+	PermPurchasingRead    Permission = "purchasing:read"
+	PermPurchasingWrite   Permission = "purchasing:write"
+	PermPurchasingReceive Permission = "purchasing:receive"
+	PermAlertsRead        Permission = "alerts:read"
+	PermAlertsDismiss     Permission = "alerts:dismiss"
+	PermWebhooksManage    Permission = "webhooks:manage"
+)
+
+// rolePermissions is the permission matrix, a role can do what is listed and nothing else
+var rolePermissions = map[Role][]Permission{
+	RoleShopper: {PermInventoryRead, PermTokensIssue},
+	RoleEmployee: {PermInventoryRead, PermInventoryWrite, PermInventoryDelete, PermStockReceive, PermStockSell,
+		PermStockAdjust, PermTransactionsRead, PermTransactionsPost, PermHistoryRead, PermPurchasingRead, PermPurchasingWrite,
+		PermPurchasingReceive, PermAlertsRead, PermAlertsDismiss, PermTokensIssue},
+	RoleSupplier: {PermInventoryRead, PermPurchasingRead, PermPurchasingReceive, PermTokensIssue},
+	RoleExec: {PermInventoryRead, PermInventoryDelete, PermInventoryPurge, PermTransactionsRead, PermReportsRead, PermAuditRead,
+		PermHistoryRead, PermPurchasingRead, PermAlertsRead, PermTokensIssue},
+	RoleAdmin: {PermKeysManage, PermWebhooksManage, PermTokensIssue},
+}
+
+// routePermissions maps the name of every route in newRouter to the permission it needs.
+// A route that isn't listed here is refused to everyone, so a new route has to be added before anyone can call it
+var routePermissions = map[string]Permission{
+	"getInventory":         PermInventoryRead,
+	"getItem":              PermInventoryRead,
+	"searchText":           PermInventoryRead,
+	"addItem":              PermInventoryWrite,
+	"addItems":             PermInventoryWrite,
+	"replaceItem":          PermInventoryWrite,
+	"patchItem":            PermInventoryWrite,
+	"addTags":              PermInventoryWrite,
+	"removeTag":            PermInventoryWrite,
+	"deleteItem":           PermInventoryDelete,
+	"restoreItem":          PermInventoryDelete,
+	"purgeItems":           PermInventoryPurge,
+	"receiveStock":         PermStockReceive,
+	"sellStock":            PermStockSell,
+	"adjustStock":          PermStockAdjust,
+	"createTransaction":    PermTransactionsPost,
+	"getTransactions":      PermTransactionsRead,
+	"getTransaction":       PermTransactionsRead,
+	"getProfitReport":      PermReportsRead,
+	"createKey":            PermKeysManage,
+	"getKeys":              PermKeysManage,
+	"revokeKey":            PermKeysManage,
+	"issueToken":           PermTokensIssue,
+	"getAudit":             PermAuditRead,
+	"getItemHistory":       PermHistoryRead,
+	"createSupplier":       PermPurchasingWrite,
+	"getSuppliers":         PermPurchasingRead,
+	"getSupplier":          PermPurchasingRead,
+	"createPurchaseOrder":  PermPurchasingWrite,
+	"getPurchaseOrders":    PermPurchasingRead,
+	"getPurchaseOrder":     PermPurchasingRead,
+	"replacePurchaseOrder": PermPurchasingWrite,
+	"submitPurchaseOrder":  PermPurchasingWrite,
+	"cancelPurchaseOrder":  PermPurchasingWrite,
+	// a shipment moves stock like any other delivery, so whoever can receive stock can receive an order
+	"receivePurchaseOrder": PermPurchasingReceive,
+	"getLowStock":          PermAlertsRead,
+	"getAlerts":            PermAlertsRead,
+	"dismissAlert":         PermAlertsDismiss,
+	"createWebhook":        PermWebhooksManage,
+	"getWebhooks":          PermWebhooksManage,
+	"deleteWebhook":        PermWebhooksManage,
+	"getWebhookDeliveries": PermWebhooksManage,
+	"getDeadLetters":       PermWebhooksManage,
+	"redeliverWebhook":     PermWebhooksManage,
+}
+
+// Principal is whoever sent the request, ID is what they are known by in the logs.
+// Scopes narrows what the role allows down to those permissions, an empty list leaves the role as it is
+type Principal struct {
+	ID     string       `json:"id"`
+	Role   Role         `json:"role"`
+	Scopes []Permission `json:"scopes,omitempty"`
+	// KeyID is the API key the request was made with, directly or through a token signed for it
+	KeyID string `json:"keyId,omitempty"`
+	// SupplierID is the supplier a supplier's key works for, see APIKey
+	SupplierID string `json:"supplierId,omitempty"`
+	// viaToken is true when the request came with a signed token rather than the key itself
+	viaToken bool
+}
+
+func (p Principal) can(permission Permission) bool {
+	if !roleCan(p.Role, permission) {
+		return false
+	}
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func roleCan(role Role, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnauthenticated means the request has no credentials, or ones we don't know
+var ErrUnauthenticated = errors.New("missing or unknown credentials")
+
+// authenticator works out who sent a request
+type authenticator interface {
+	authenticate(r *http.Request) (Principal, error)
+}
+
+// bearerAuth accepts the API keys in keys (apikeys.go) and the tokens signed by tokens (tokens.go)
+// as the bearer token, keys are easy to tell apart by their prefix
+type bearerAuth struct{}
+
+func (bearerAuth) authenticate(r *http.Request) (Principal, error) {
+	credential, found := bearerToken(r)
+	switch {
+	case !found:
+		return Principal{}, ErrUnauthenticated
+	case strings.HasPrefix(credential, keyPrefix):
+		return keys.authenticate(credential)
+	case tokens != nil:
+		return tokens.verify(credential)
+	}
+	return Principal{}, ErrUnauthenticated
+}
+
+// bearerToken pulls the token out of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+		return "", false
+	}
+	return fields[1], true
+}
+
+type principalKey struct{}
+
+// principalOf returns who sent r, it is only there on requests that went through requirePermission
+func principalOf(r *http.Request) (Principal, bool) {
+	principal, found := r.Context().Value(principalKey{}).(Principal)
+	return principal, found
+}
+
+// requirePermission returns the middleware that checks every request against the permission matrix,
+// 401 when we don't know who sent it and 403 when their role doesn't allow the route
+func requirePermission(auth authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := auth.authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="inventory"`)
+				_writeError(w, "requirePermission", http.StatusUnauthorized, codeUnauthenticated,
+					"Send a valid API key or token in the Authorization header.")
+				return
+			}
+			name := ""
+			if route := mux.CurrentRoute(r); route != nil {
+				name = route.GetName()
+			}
+			permission, listed := routePermissions[name]
+			if !listed || !principal.can(permission) {
+				_writeError(w, "requirePermission", http.StatusForbidden, codeForbidden,
+					fmt.Sprintf("A %v isn't allowed to %v %v.", principal.Role, r.Method, r.URL.Path))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// useKeys points the middleware and the /admin/keys handlers at k for the rest of the test
+func useKeys(k *keyStore, t *testing.T) {
+	old := keys
+	keys = k
+	t.Cleanup(func() { keys = old })
+}
+
+// authRouter is newRouter with the permission check handleRequests puts in front of it. It starts
+// over with a key for a principal of every role and returns the key strings by role
+func authRouter(t *testing.T) (*mux.Router, map[Role]string) {
+	useKeys(newKeyStore(), t)
+	keyOf := map[Role]string{}
+	for _, role := range []Role{RoleShopper, RoleEmployee, RoleSupplier, RoleExec, RoleAdmin} {
+		_, secret, err := keys.create("test-"+string(role), role, nil, "")
+		checkError(err, t)
+		keyOf[role] = secret
+	}
+	router := newRouter()
+	router.Use(requirePermission(bearerAuth{}))
+	return router, keyOf
+}
+
+// serveAs is serveRoute with credential sent as the bearer token
+func serveAs(router http.Handler, credential string, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	return respRecorder
+}
+
+// authReq sends a request without a body with the given credential and checks the status
+func authReq(router http.Handler, credential string, method string, path string, expStatus int, t *testing.T) *httptest.ResponseRecorder {
+	respRecorder := serveAs(router, credential, method, path, nil)
+	checkStatus(respRecorder.Code, expStatus, t, "authReq "+method+" "+path)
+	return respRecorder
+}
+
+func TestPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	router, keyOf := authRouter(t)
+	peach := "/inventory/E5T6-9UI3-TH15-QR88"
+
+	// 1. no token or a wrong one is a 401 =====================================================================================
+	t.Log("1. call without a token and with an unknown one")
+	respRecorder := authReq(router, "", "GET", "/inventory", http.StatusUnauthorized, t)
+	decodeAPIError(respRecorder, codeUnauthenticated, t, "1 no token")
+	if respRecorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("1 -- expected a WWW-Authenticate header on a 401")
+	}
+	authReq(router, "guess", "GET", "/inventory", http.StatusUnauthorized, t)
+	// the right id with the wrong secret
+	authReq(router, keyOf[RoleExec][:len(keyOf[RoleExec])-2]+"xx", "GET", "/inventory", http.StatusUnauthorized, t)
+
+	// 2. every role against the routes it may and may not call ===============================================================
+	t.Log("2. the permission matrix")
+	cases := []struct {
+		role    Role
+		method  string
+		path    string
+		allowed bool
+	}{
+		{RoleShopper, "GET", "/inventory", true},
+		{RoleShopper, "GET", "/search?q=peach", true},
+		{RoleShopper, "POST", "/inventory/addItem", false},
+		{RoleShopper, "DELETE", peach, false},
+		{RoleShopper, "GET", "/reports/profit", false},
+		{RoleSupplier, "POST", peach + "/receive", false},
+		{RoleSupplier, "POST", peach + "/sell", false},
+		{RoleSupplier, "GET", "/transactions", false},
+		{RoleEmployee, "PATCH", peach, true},
+		{RoleEmployee, "POST", peach + "/sell", true},
+		{RoleEmployee, "GET", "/reports/profit", false},
+		{RoleExec, "GET", "/reports/profit", true},
+		{RoleExec, "GET", "/transactions", true},
+		{RoleExec, "POST", "/inventory/addItem", false},
+		{RoleAdmin, "GET", "/inventory", false},
+		{RoleAdmin, "GET", "/admin/keys", true},
+		{RoleEmployee, "GET", "/admin/keys", false},
+	}
+	for _, c := range cases {
+		respRecorder := serveAs(router, keyOf[c.role], c.method, c.path, nil)
+		// an allowed call reaches the handler, which may still turn down the empty body
+		forbidden := respRecorder.Code == http.StatusForbidden
+		if forbidden == c.allowed {
+			t.Errorf("2 %v %v %v -- actual status - %v | expected allowed - %v", c.role, c.method, c.path, respRecorder.Code, c.allowed)
+		}
+	}
+	decodeAPIError(authReq(router, keyOf[RoleShopper], "DELETE", peach, http.StatusForbidden, t), codeForbidden, t, "2 shopper delete")
+
+	// 3. nothing is deleted by a role that can't, and unknown routes are still a 404 ==========================================
+	t.Log("3. the peach survives the shopper and 404s are left alone")
+	authReq(router, keyOf[RoleEmployee], "GET", peach, http.StatusOK, t)
+	authReq(router, keyOf[RoleShopper], "GET", "/nowhere", http.StatusNotFound, t)
+	authReq(router, keyOf[RoleExec], "DELETE", peach, http.StatusOK, t)
+	authReq(router, keyOf[RoleExec], "GET", peach, http.StatusNotFound, t)
+}
+
+func TestEveryRouteHasAPermission(t *testing.T) {
+	err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if _, listed := routePermissions[route.GetName()]; !listed {
+			path, _ := route.GetPathTemplate()
+			t.Errorf("route %v (%q) isn't in routePermissions, nobody can call it", path, route.GetName())
+		}
+		return nil
+	})
+	checkError(err, t)
+}
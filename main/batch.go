@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// addItemResult is what happened to one item of a ?mode=partial addItems batch
+// Status is 201 for an item that was added, otherwise the status it would have gotten on its own
+type addItemResult struct {
+	Index   int          `json:"index"`
+	PID     string       `json:"pid,omitempty"`
+	Status  int          `json:"status"`
+	Code    string       `json:"code,omitempty"`
+	Details []fieldError `json:"details,omitempty"`
+}
+
+// addItemsReport is the 207 Multi-Status body of a ?mode=partial addItems, one result per item in the order sent
+type addItemsReport struct {
+	Added    int             `json:"added"`
+	Rejected int             `json:"rejected"`
+	Results  []addItemResult `json:"results"`
+}
+
+// _checkAddItems decodes and checks every item of a batch on its own, problems[i] is everything wrong with item i.
+// An item repeating the PID of an earlier good item in the same batch is rejected too. Only good items
+// count, so in partial mode a repeat of a bad item is still added
+func _checkAddItems(rawItems []json.RawMessage) ([]Item, [][]fieldError) {
+	items := make([]Item, len(rawItems))
+	problems := make([][]fieldError, len(rawItems))
+	batch := make(map[string]int, len(rawItems))
+	for i, raw := range rawItems {
+		if err := json.Unmarshal(raw, &items[i]); err != nil {
+			problems[i] = []fieldError{_decodeProblem(err)}
+			continue
+		}
+		if problems[i] = _checkAddItem(&items[i]); len(problems[i]) > 0 {
+			continue
+		}
+		key := foldKey(items[i].PID)
+		if first, repeated := batch[key]; repeated {
+			problems[i] = []fieldError{{Field: "pid", Message: fmt.Sprintf("is repeated, item %d of the batch has it too", first)}}
+		} else {
+			batch[key] = i
+		}
+	}
+	return items, problems
+}
+
+// _addItemsPartially adds the good items of a batch to s, leaves out the bad ones and reports on every item
+func _addItemsPartially(w http.ResponseWriter, s Store, items []Item, problems [][]fieldError) {
+	report := addItemsReport{Results: make([]addItemResult, len(items))}
+	var good []Item
+	for i, item := range items {
+		report.Results[i] = addItemResult{Index: i, PID: item.PID, Status: http.StatusCreated}
+		if len(problems[i]) > 0 {
+			report.Results[i].Status = http.StatusBadRequest
+			report.Results[i].Code = codeValidationFailed
+			report.Results[i].Details = problems[i]
+		} else {
+			good = append(good, item)
+		}
+	}
+
+	if len(good) > 0 {
+		err := s.AddBatch(good)
+		if errors.Is(err, ErrDuplicatePID) {
+			// someone else added one of the PIDs since we checked, so the batch as a whole was refused
+			// add the good items one at a time instead, only the taken ones fail
+			for i := range report.Results {
+				if report.Results[i].Status != http.StatusCreated {
+					continue
+				}
+				if err := s.Add(items[i]); errors.Is(err, ErrDuplicatePID) {
+					report.Results[i].Status = http.StatusBadRequest
+					report.Results[i].Code = codeDuplicatePID
+				} else if err != nil {
+					log.Printf("500 error - addItems(): %v", err)
+					report.Results[i].Status = http.StatusInternalServerError
+					report.Results[i].Code = codeInternal
+				}
+			}
+		} else if err != nil {
+			// nothing was added, there's no partial result to report
+			_writeStoreError(w, "addItems", err)
+			return
+		}
+	}
+
+	for _, result := range report.Results {
+		if result.Status == http.StatusCreated {
+			report.Added++
+		} else {
+			report.Rejected++
+		}
+	}
+	w.WriteHeader(http.StatusMultiStatus) // return 207 Multi-Status
+	json.NewEncoder(w).Encode(report)
+}
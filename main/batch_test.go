@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// partialAddItemsReq sends items to POST /inventory/addItems?mode=partial and returns the report
+func partialAddItemsReq(items interface{}, t *testing.T) addItemsReport {
+	respRecorder := serveRoute(newRouter(), "POST", "/inventory/addItems?mode=partial", items)
+	checkStatus(respRecorder.Code, http.StatusMultiStatus, t, "partialAddItemsReq")
+
+	var report addItemsReport
+	err := json.NewDecoder(respRecorder.Body).Decode(&report)
+	checkResponseError(err, respRecorder, "addItemsReport", t)
+	return report
+}
+
+// checkResults logs an error for every result whose status isn't the expected one
+func checkResults(report addItemsReport, statuses []int, t *testing.T, checkpoint string) {
+	if len(report.Results) != len(statuses) {
+		t.Fatalf("%v -- actual results - %+v | expected statuses - %v", checkpoint, report.Results, statuses)
+	}
+	added := 0
+	for i, status := range statuses {
+		if result := report.Results[i]; result.Index != i || result.Status != status {
+			t.Errorf("%v -- result %v: actual - %+v | expected status - %v", checkpoint, i, result, status)
+		}
+		if status == http.StatusCreated {
+			added++
+		}
+	}
+	if report.Added != added || report.Rejected != len(statuses)-added {
+		t.Errorf("%v -- actual counts - %v added, %v rejected | expected - %v, %v", checkpoint, report.Added, report.Rejected, added, len(statuses)-added)
+	}
+}
+
+func TestAddItemsModes(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	plum := Item{PID: "PLUM-0000-0000-0001", Name: "Plum", Price: 99}
+	fig := Item{PID: "F1G0-0000-0000-0002", Name: "Fig", Price: 149}
+	peach := Item{PID: "e5t6-9ui3-th15-qr88", Name: "Another Peach", Price: 299}
+
+	// 1. atomic (the default) catches a PID repeated within the batch =====================================================
+	t.Log("1. atomic batch with a repeated PID")
+	repeat := plum
+	repeat.PID = "plum-0000-0000-0001"
+	respRecorder := serveRoute(newRouter(), "POST", "/inventory/addItems?mode=atomic", []Item{plum, fig, repeat})
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "1 addItems")
+	apiErr := decodeAPIError(respRecorder, codeValidationFailed, t, "1 addItems")
+	if len(apiErr.Details) != 1 || *apiErr.Details[0].Index != 2 || apiErr.Details[0].Field != "pid" {
+		t.Errorf("1 -- expected the pid of item 2 to be the only problem, got: %+v", apiErr.Details)
+	}
+	checkStatus(serveRoute(newRouter(), "POST", "/inventory/addItems?mode=some", []Item{plum}).Code, http.StatusBadRequest, t, "1 bad mode")
+
+	// 2. partial adds the good items and reports on every one ==============================================================
+	t.Log("2. partial batch with a repeat, an existing PID and a missing name")
+	report := partialAddItemsReq([]interface{}{plum, repeat, peach, BadItemNoName{PID: "N0NM-0000-0000-0003", Price: 1}, fig}, t)
+	checkResults(report, []int{http.StatusCreated, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusCreated}, t, "2")
+	if report.Results[3].Code != codeValidationFailed || report.Results[3].Details[0].Field != "name" {
+		t.Errorf("2 -- expected item 3 to be missing its name, got: %+v", report.Results[3])
+	}
+	compareActualWithExpected(store.List(), append(defaultInventory(), plum, fig), t, "2")
+
+	// 3. a PID taken between the check and the write only fails that item ===================================================
+	t.Log("3. a PID added by someone else mid-request")
+	kiwi := Item{PID: "K1W1-0000-0000-0004", Name: "Kiwi", Price: 50}
+	respRecorder = httptest.NewRecorder()
+	_addItemsPartially(respRecorder, store, []Item{kiwi, fig}, make([][]fieldError, 2))
+	checkStatus(respRecorder.Code, http.StatusMultiStatus, t, "3 _addItemsPartially")
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&report), t)
+	checkResults(report, []int{http.StatusCreated, http.StatusBadRequest}, t, "3")
+	if report.Results[1].Code != codeDuplicatePID {
+		t.Errorf("3 -- actual code - %v | expected - %v", report.Results[1].Code, codeDuplicatePID)
+	}
+	if _, err := store.Get(kiwi.PID); err != nil {
+		t.Errorf("3 -- kiwi should have been added: %v", err)
+	}
+}
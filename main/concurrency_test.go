@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// useStore points the handlers at s for the rest of the test and puts the old store back afterwards
+func useStore(s Store, t testing.TB) {
+	old := store
+	store = s
+	t.Cleanup(func() { store = old })
+}
+
+// serveRoute sends a request through the full router, body is marshalled to JSON when it isn't nil
+func serveRoute(router http.Handler, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	return respRecorder
+}
+
+// hammerRoutes has several workers call all five routes at the same time, run it with
+// go test -race so the race detector can catch any unguarded access to the inventory.
+// Every round all the workers also race to add the same PID, exactly one of them should win
+func hammerRoutes(t *testing.T) {
+	const workers = 8
+	const rounds = 40
+	router := newRouter()
+	var contestedWins int32
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				single := Item{PID: fmt.Sprintf("S%03d-%04d-0000-0000", worker, i), Name: "Single", Price: 125}
+				batch := []Item{
+					{PID: fmt.Sprintf("B%03d-%04d-0000-0001", worker, i), Name: "Batch One", Price: 250},
+					{PID: fmt.Sprintf("B%03d-%04d-0000-0002", worker, i), Name: "Batch Two", Price: 375},
+				}
+				contested := Item{PID: fmt.Sprintf("C000-%04d-0000-0000", i), Name: "Contested", Price: 50}
+
+				checkStatus(serveRoute(router, "POST", "/inventory/addItem", single).Code, http.StatusOK, t, "hammer addItem")
+				checkStatus(serveRoute(router, "POST", "/inventory/addItems", batch).Code, http.StatusOK, t, "hammer addItems")
+				if serveRoute(router, "POST", "/inventory/addItem", contested).Code == http.StatusOK {
+					atomic.AddInt32(&contestedWins, 1)
+				}
+
+				checkStatus(serveRoute(router, "GET", "/inventory", nil).Code, http.StatusOK, t, "hammer getInventory")
+				checkStatus(serveRoute(router, "GET", "/inventory/"+single.PID, nil).Code, http.StatusOK, t, "hammer getItem by PID")
+				checkStatus(serveRoute(router, "GET", "/inventory/Peach", nil).Code, http.StatusOK, t, "hammer getItem by name")
+
+				for _, pid := range []string{single.PID, batch[0].PID, batch[1].PID} {
+					checkStatus(serveRoute(router, "DELETE", "/inventory/"+pid, nil).Code, http.StatusOK, t, "hammer deleteItem")
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if contestedWins != rounds {
+		t.Errorf("each contested PID should be added exactly once: actual - %v | expected - %v", contestedWins, rounds)
+	}
+
+	// all that should be left is the starting inventory plus one of each contested item, the rest was deleted
+	final := _liveInventory()
+	if len(final) != len(defaultInventory())+rounds {
+		t.Errorf("final inventory size: actual - %v | expected - %v", len(final), len(defaultInventory())+rounds)
+	}
+	seen := map[string]bool{}
+	for _, item := range final {
+		if seen[strings.ToUpper(item.PID)] {
+			t.Errorf("PID %v is in the inventory more than once", item.PID)
+		}
+		seen[strings.ToUpper(item.PID)] = true
+	}
+}
+
+func TestConcurrentRoutesMemoryStore(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	hammerRoutes(t)
+}
+
+func TestConcurrentRoutesWALStore(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 50)
+	checkError(err, t)
+	useStore(s, t)
+	hammerRoutes(t)
+
+	// whatever survived the stampede should also survive a restart
+	expected := s.List()
+	reopened := reopenWALStore(s, 50, t)
+	defer reopened.Close()
+	compareActualWithExpected(reopened.List(), expected, t, "wal store after concurrent routes")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// retention is how long a deleted item is kept, and can still be restored, before it may be purged
+var retention = 30 * 24 * time.Hour
+
+var errNotDeleted = errors.New("item isn't deleted")
+
+// _liveOnly wraps a Modify change so a deleted item is refused as if it wasn't there at all
+func _liveOnly(change func(item *Item) error) func(item *Item) error {
+	return func(item *Item) error {
+		if item.DeletedAt != nil {
+			return ErrItemNotFound
+		}
+		return change(item)
+	}
+}
+
+// _liveInventory is the inventory without the deleted items, what everybody sees by default
+func _liveInventory() []Item {
+	items := store.List()
+	live := items[:0]
+	for _, item := range items {
+		if item.DeletedAt == nil {
+			live = append(live, item)
+		}
+	}
+	return live
+}
+
+// _hasTags reports whether item has every one of the tags (matchAll) or at least one of them
+func _hasTags(item Item, tags []string, matchAll bool) bool {
+	for _, tag := range tags {
+		found := false
+		for _, has := range item.Tags {
+			if has == tag {
+				found = true
+				break
+			}
+		}
+		if found != matchAll {
+			return found
+		}
+	}
+	return matchAll
+}
+
+// _parseIncludeDeleted reads ?includeDeleted=, which is false when it is left out
+func _parseIncludeDeleted(value string) (includeDeleted bool, valid bool) {
+	if value == "" {
+		return false, true
+	}
+	includeDeleted, err := strconv.ParseBool(value)
+	return includeDeleted, err == nil
+}
+
+// _canSeeDeleted lets those who can delete items see the deleted ones, anyone else gets a 403.
+// With -auth=false there is no principal and everyone can
+func _canSeeDeleted(w http.ResponseWriter, r *http.Request, caller string) bool {
+	if principal, found := principalOf(r); found && !principal.can(PermInventoryDelete) {
+		_writeError(w, caller, http.StatusForbidden, codeForbidden, "Only those who can delete items can see the deleted ones.",
+			fieldError{Field: "includeDeleted", Message: "isn't allowed for a " + string(principal.Role)})
+		return false
+	}
+	return true
+}
+
+// an item deleted by mistake comes back exactly as it was, quantity and all
+func restoreItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: restoreItem()")
+
+	pid := mux.Vars(r)["pid"]
+	item, err := _auditedStore(w, r).Modify(pid, func(item *Item) error {
+		if item.DeletedAt == nil {
+			return errNotDeleted
+		}
+		item.DeletedAt = nil
+		return nil
+	})
+	if errors.Is(err, errNotDeleted) {
+		_writeError(w, "restoreItem", http.StatusConflict, codeNotDeleted, "The item isn't deleted: "+pid)
+		return
+	}
+	if err != nil {
+		_writeStoreError(w, "restoreItem", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(item)
+}
+
+// purgeReport is the response of POST /inventory/purge
+type purgeReport struct {
+	// DeletedBefore is the cutoff, items deleted before it were purged
+	DeletedBefore time.Time `json:"deletedBefore"`
+	Purged        []Item    `json:"purged"`
+}
+
+// exec staff remove for good every item that was deleted more than the retention period ago,
+// it can't be undone. Run it from a daily job, items deleted more recently are left for later
+func purgeItems(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: purgeItems()")
+
+	cutoff := time.Now().UTC().Add(-retention)
+	report := purgeReport{DeletedBefore: cutoff, Purged: []Item{}}
+	s := _auditedStore(w, r)
+	for _, item := range store.List() {
+		if item.DeletedAt == nil || !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		// the item may have been restored since we listed it, the check happens under the store's lock
+		purged, err := s.DeleteIf(item.PID, func(item Item) error {
+			if item.DeletedAt == nil || !item.DeletedAt.Before(cutoff) {
+				return errNotDeleted
+			}
+			return nil
+		})
+		if errors.Is(err, errNotDeleted) || errors.Is(err, ErrItemNotFound) {
+			continue
+		}
+		if err != nil {
+			// whatever was purged so far stays purged, the rest is left for the next run
+			_writeStoreError(w, "purgeItems", err)
+			return
+		}
+		report.Purged = append(report.Purged, purged)
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useRetention changes the retention period for the rest of the test
+func useRetention(d time.Duration, t *testing.T) {
+	old := retention
+	retention = d
+	t.Cleanup(func() { retention = old })
+}
+
+// purgeReq purges through the router and returns the PIDs of the purged items
+func purgeReq(router http.Handler, t *testing.T) []string {
+	respRecorder := serveRoute(router, "POST", "/inventory/purge", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "purgeReq")
+	var report purgeReport
+	err := json.NewDecoder(respRecorder.Body).Decode(&report)
+	checkResponseError(err, respRecorder, "purgeReport", t)
+	pids := []string{}
+	for _, item := range report.Purged {
+		pids = append(pids, item.PID)
+	}
+	return pids
+}
+
+func TestSoftDelete(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	router := newRouter()
+	peach := "E5T6-9UI3-TH15-QR88"
+	checkStatus(serveRoute(router, "POST", "/inventory/"+peach+"/tags", tagsRequest{Tags: []string{"organic"}}).Code, http.StatusOK, t, "tag the peach")
+
+	// 1. a deleted item is hidden everywhere ==================================================================================
+	t.Log("1. delete the peach")
+	deleteItemReq(peach, t)
+	checkNames(filterInventoryReq("", http.StatusOK, t), []string{"Lettuce", "Green Pepper", "Gala Apple"}, t, "1 getInventory")
+	for _, path := range []string{"/inventory/" + peach, "/inventory/peach", "/inventory/peach?mode=search"} {
+		checkStatus(serveRoute(router, "GET", path, nil).Code, http.StatusNotFound, t, "1 "+path)
+	}
+	checkNames(textSearchReq("?q=peach", t), []string{}, t, "1 search")
+	checkNames(filterInventoryReq("?tag=organic", http.StatusOK, t), []string{}, t, "1 tag")
+
+	// 2. but it's still there with includeDeleted ============================================================================
+	t.Log("2. includeDeleted")
+	items, _ := pageReq("?includeDeleted=true&tag=organic", t)
+	if len(items) != 1 || items[0].PID != peach || items[0].DeletedAt == nil {
+		t.Errorf("2 -- actual - %+v | expected the deleted peach", items)
+	}
+	checkStatus(serveRoute(router, "GET", "/inventory/peach?includeDeleted=true", nil).Code, http.StatusOK, t, "2 by name")
+	filterInventoryReq("?includeDeleted=maybe", http.StatusBadRequest, t)
+
+	// 3. nothing can be done to a deleted item but restore it ==================================================================
+	t.Log("3. sell, patch, delete and re-add the deleted peach")
+	checkStatus(serveRoute(router, "POST", "/inventory/"+peach+"/receive", stockRequest{Quantity: 1}).Code, http.StatusNotFound, t, "3 receive")
+	checkStatus(serveRoute(router, "PATCH", "/inventory/"+peach, map[string]interface{}{"price": 1}).Code, http.StatusNotFound, t, "3 patch")
+	checkStatus(serveRoute(router, "DELETE", "/inventory/"+peach, nil).Code, http.StatusNotFound, t, "3 delete again")
+	transactionReq(Transaction{Type: TransactionPurchase, Lines: []TransactionLine{{PID: peach, Quantity: 1, UnitPrice: 100}}}, http.StatusNotFound, t)
+	respRecorder := serveRoute(router, "POST", "/inventory/addItem", Item{PID: peach, Name: "Peach", Price: 299})
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "3 addItem")
+	apiErr := decodeAPIError(respRecorder, codeValidationFailed, t, "3 addItem")
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Message != "belongs to a deleted item, restore it or wait until it is purged" {
+		t.Errorf("3 -- actual details - %+v | expected the PID of a deleted item", apiErr.Details)
+	}
+
+	// 4. restoring brings it back as it was =====================================================================================
+	t.Log("4. restore the peach")
+	respRecorder = serveRoute(router, "POST", "/inventory/"+peach+"/restore", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "4 restore")
+	var restored Item
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&restored), t)
+	if restored.DeletedAt != nil || len(restored.Tags) != 1 {
+		t.Errorf("4 -- actual - %+v | expected the peach with its tag and no deletedAt", restored)
+	}
+	checkNames(textSearchReq("?q=peach", t), []string{"Peach"}, t, "4 search")
+	decodeAPIError(serveRoute(router, "POST", "/inventory/"+peach+"/restore", nil), codeNotDeleted, t, "4 restore twice")
+}
+
+func TestPurge(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useRetention(time.Hour, t)
+	router := newRouter()
+	lettuce, peach := "A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88"
+
+	// 1. nothing is purged before the retention period is over =================================================================
+	t.Log("1. purge right after deleting")
+	deleteItemReq(lettuce, t)
+	deleteItemReq(peach, t)
+	checkNames(purgeReq(router, t), []string{}, t, "1")
+
+	// 2. the lettuce was deleted two hours ago, the peach just now ==============================================================
+	t.Log("2. purge after the lettuce's retention is over")
+	_, err := store.Modify(lettuce, func(item *Item) error {
+		longAgo := time.Now().UTC().Add(-2 * time.Hour)
+		item.DeletedAt = &longAgo
+		return nil
+	})
+	checkError(err, t)
+	checkNames(purgeReq(router, t), []string{lettuce}, t, "2")
+	if _, err := store.Get(lettuce); err == nil {
+		t.Errorf("2 -- the lettuce should be gone for good")
+	}
+	checkStatus(serveRoute(router, "POST", "/inventory/"+lettuce+"/restore", nil).Code, http.StatusNotFound, t, "2 restore purged")
+	checkStatus(serveRoute(router, "POST", "/inventory/"+peach+"/restore", nil).Code, http.StatusOK, t, "2 restore peach")
+}
+
+func TestDeletedPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router, keyOf := authRouter(t)
+	peach := "/inventory/E5T6-9UI3-TH15-QR88"
+	authReq(router, keyOf[RoleEmployee], "DELETE", peach, http.StatusOK, t)
+	authReq(router, keyOf[RoleShopper], "GET", "/inventory?includeDeleted=true", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleShopper], "GET", peach+"?includeDeleted=true", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleExec], "GET", peach+"?includeDeleted=true", http.StatusOK, t)
+	authReq(router, keyOf[RoleEmployee], "POST", "/inventory/purge", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleExec], "POST", "/inventory/purge", http.StatusOK, t)
+	authReq(router, keyOf[RoleShopper], "POST", peach+"/restore", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleEmployee], "POST", peach+"/restore", http.StatusOK, t)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// every error response has one of these codes, clients should switch on the code
+// and only show the message to people, its wording may change
+const (
+	codeInvalidJSON          = "invalid_json"           // the body isn't the JSON we asked for
+	codeValidationFailed     = "validation_failed"      // the body parsed but some fields are wrong, see details
+	codeInvalidParameter     = "invalid_parameter"      // a query parameter is wrong, or an amount adds up to more money than we can count
+	codeNotFound             = "not_found"              // no such item, transaction, tag or route
+	codeMethodNotAllowed     = "method_not_allowed"     // the route exists but not with this method
+	codeDuplicatePID         = "duplicate_pid"          // the PID is already in the inventory
+	codeInsufficientStock    = "insufficient_stock"     // not enough on hand for a sale or adjustment
+	codeNotDeleted           = "not_deleted"            // only a deleted item can be restored
+	codePreconditionFailed   = "precondition_failed"    // the item changed since the If-Match version was read
+	codeIdempotencyKeyReused = "idempotency_key_reused" // the Idempotency-Key was already used for another request
+	codeInvalidStatus        = "invalid_status"         // the purchase order's or webhook delivery's status doesn't allow that, e.g. receiving a draft
+	codeUnauthenticated      = "unauthenticated"        // no token, or one we don't know
+	codeForbidden            = "forbidden"              // we know who you are but your role can't do that
+	codeInternal             = "internal_error"         // our fault, e.g. the inventory couldn't be saved
+)
+
+// requestIDHeader carries the id of every request, it is also in the body of every error
+// so a client reporting a failure can tell us which log lines to look at
+const requestIDHeader = "X-Request-ID"
+
+// errorResponse is the body of every 4xx and 5xx response
+//
+//	{"error": {"code": "validation_failed", "message": "...", "details": [...], "requestId": "..."}}
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []fieldError `json:"details,omitempty"`
+	RequestID string       `json:"requestId"`
+}
+
+// fieldError points at one thing wrong with the request body. Index is the position of the item
+// in an addItems batch and is left out when the body is a single object
+type fieldError struct {
+	Index   *int   `json:"index,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// fieldErrors lets a list of problems travel as an error, e.g. out of a store.Modify change
+type fieldErrors []fieldError
+
+func (problems fieldErrors) Error() string {
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = strings.TrimSpace(problem.Field + " " + problem.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// atIndex marks every problem as belonging to item i of a batch
+func atIndex(i int, problems []fieldError) []fieldError {
+	for p := range problems {
+		index := i
+		problems[p].Index = &index
+	}
+	return problems
+}
+
+// _decodeProblem describes why a JSON object couldn't be decoded into one of our types,
+// naming the field when encoding/json tells us which one it was
+func _decodeProblem(err error) fieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fieldError{Field: typeErr.Field, Message: "has to be a JSON " + _jsonKind(typeErr.Type.Kind().String())}
+	}
+	return fieldError{Message: err.Error()}
+}
+
+// _jsonKind names a Go kind the way a JSON client would think of it
+func _jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice" || kind == "array":
+		return "array"
+	case kind == "struct" || kind == "map":
+		return "object"
+	case kind == "bool":
+		return "boolean"
+	}
+	return kind
+}
+
+// newRequestID returns 16 random hex characters
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		// there's no good reason for this to fail, an id is nice to have but not worth failing a request over
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// withRequestID gives every request an id and echoes it in the X-Request-ID response header
+// a client that sends its own X-Request-ID keeps it, so one id can follow a request across services
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// _requestID returns the id withRequestID gave the response. Handlers called directly
+// (like in api_test.go) skip the middleware, they still get an id
+func _requestID(w http.ResponseWriter) string {
+	id := w.Header().Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		w.Header().Set(requestIDHeader, id)
+	}
+	return id
+}
+
+// _writeError logs the failure and responds with status and an errorResponse body
+// caller is the name of the handler, so the log tells us where it came from
+func _writeError(w http.ResponseWriter, caller string, status int, code string, message string, details ...fieldError) {
+	id := _requestID(w)
+	if len(details) > 0 {
+		log.Printf("%d error - %v(): %v: %v [request %v]", status, caller, message, fieldErrors(details), id)
+	} else {
+		log.Printf("%d error - %v(): %v [request %v]", status, caller, message, id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: apiError{Code: code, Message: message, Details: details, RequestID: id}})
+}
+
+// _writeStoreError turns an error coming back from the store into the matching response
+func _writeStoreError(w http.ResponseWriter, caller string, err error) {
+	var problems fieldErrors
+	switch {
+	case errors.As(err, &problems):
+		_writeError(w, caller, http.StatusBadRequest, codeValidationFailed, "The item has invalid fields.", problems...)
+	case errors.Is(err, ErrItemNotFound):
+		_writeError(w, caller, http.StatusNotFound, codeNotFound, "Could not find item in inventory.")
+	case errors.Is(err, ErrDuplicatePID):
+		// someone else added the same PID between our check and the write
+		_writeError(w, caller, http.StatusBadRequest, codeDuplicatePID, "Could not add items, a PID already exists in inventory.")
+	case errors.Is(err, errMoneyOutOfRange):
+		// a quantity times a price, or the sum of the lines, is more money than we can count
+		_writeError(w, caller, http.StatusBadRequest, codeInvalidParameter, "The amounts are too large to total, nothing was changed: "+err.Error())
+	case errors.Is(err, ErrInsufficientStock):
+		_writeError(w, caller, http.StatusConflict, codeInsufficientStock, "There isn't enough stock on hand to take that many.")
+	case errors.Is(err, errPreconditionFailed):
+		// someone else changed the item, nothing was saved
+		_writeError(w, caller, http.StatusPreconditionFailed, codePreconditionFailed,
+			"The item has changed since you read it, get it again and retry with its new ETag.")
+	default:
+		// the durable store couldn't write, nothing was changed
+		log.Printf("500 error - %v(): %v", caller, err)
+		_writeError(w, caller, http.StatusInternalServerError, codeInternal, "Could not save the inventory, please try again.")
+	}
+}
+
+// routeNotFound and methodNotAllowed replace the plain text responses gorilla/mux gives by default
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	_writeError(w, "routeNotFound", http.StatusNotFound, codeNotFound, fmt.Sprintf("There is no endpoint at %v.", r.URL.Path))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	_writeError(w, "methodNotAllowed", http.StatusMethodNotAllowed, codeMethodNotAllowed,
+		fmt.Sprintf("%v isn't supported at %v.", r.Method, r.URL.Path))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// decodeAPIError decodes an error response, checking its code and that it carries the response's request id
+func decodeAPIError(respRecorder *httptest.ResponseRecorder, expCode string, t *testing.T, checkpoint string) apiError {
+	var body errorResponse
+	err := json.NewDecoder(respRecorder.Body).Decode(&body)
+	checkResponseError(err, respRecorder, "errorResponse", t)
+
+	if body.Error.Code != expCode {
+		t.Errorf("%v -- actual code - %v | expected code - %v", checkpoint, body.Error.Code, expCode)
+	}
+	if body.Error.Message == "" {
+		t.Errorf("%v -- the error has no message", checkpoint)
+	}
+	if id := respRecorder.Header().Get(requestIDHeader); id == "" || body.Error.RequestID != id {
+		t.Errorf("%v -- actual request id - %q | expected the X-Request-ID header - %q", checkpoint, body.Error.RequestID, id)
+	}
+	return body.Error
+}
+
+func TestErrorResponses(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+
+	// 1. a bad addItems batch points at every bad item and field =============================================================
+	t.Log("1. addItems with bad items at index 1 and 3")
+	batch := []interface{}{
+		Item{PID: "G00D-0000-0000-0001", Name: "Plum", Price: 99},
+		BadItemNoName{PID: "N0NM-0000-0000-0002", Price: 99},
+		Item{PID: "G00D-0000-0000-0003", Name: "Fig", Price: 99},
+		map[string]interface{}{"pid": "BAD", "name": "Kiwi", "price": 99, "quantity": "lots"},
+	}
+	respRecorder := serveRoute(router, "POST", "/inventory/addItems", batch)
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "1 addItems")
+	apiErr := decodeAPIError(respRecorder, codeValidationFailed, t, "1 addItems")
+	if len(apiErr.Details) != 2 {
+		t.Fatalf("1 -- expected 2 problems, got: %+v", apiErr.Details)
+	}
+	// a field that doesn't decode is reported on its own, the rest of that item isn't checked
+	for i, expected := range []fieldError{{Field: "name"}, {Field: "quantity"}} {
+		actual := apiErr.Details[i]
+		if actual.Index == nil || actual.Field != expected.Field {
+			t.Errorf("1 -- problem %v: actual - %+v | expected field - %v", i, actual, expected.Field)
+		}
+	}
+	if *apiErr.Details[0].Index != 1 || *apiErr.Details[1].Index != 3 {
+		t.Errorf("1 -- actual indexes - %v, %v | expected - 1, 3", *apiErr.Details[0].Index, *apiErr.Details[1].Index)
+	}
+	if len(store.List()) != len(defaultInventory()) {
+		t.Errorf("1 -- a rejected batch shouldn't add anything")
+	}
+
+	// 2. not found, conflict and unparseable bodies all use the envelope ====================================================
+	t.Log("2. 404s, 409 and invalid JSON")
+	respRecorder = serveRoute(router, "GET", "/inventory/Durian", nil)
+	checkStatus(respRecorder.Code, http.StatusNotFound, t, "2 getItem")
+	decodeAPIError(respRecorder, codeNotFound, t, "2 getItem")
+
+	respRecorder = serveRoute(router, "DELETE", "/inventory/N0NE-0000-0000-0000", nil)
+	checkStatus(respRecorder.Code, http.StatusNotFound, t, "2 deleteItem")
+	decodeAPIError(respRecorder, codeNotFound, t, "2 deleteItem")
+
+	respRecorder = serveRoute(router, "POST", "/inventory/E5T6-9UI3-TH15-QR88/sell", stockRequest{Quantity: 1000})
+	checkStatus(respRecorder.Code, http.StatusConflict, t, "2 sellStock")
+	decodeAPIError(respRecorder, codeInsufficientStock, t, "2 sellStock")
+
+	respRecorder = serveRoute(router, "POST", "/inventory/addItem", "not an item")
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "2 addItem")
+	decodeAPIError(respRecorder, codeInvalidJSON, t, "2 addItem")
+
+	respRecorder = serveRoute(router, "GET", "/nowhere", nil)
+	checkStatus(respRecorder.Code, http.StatusNotFound, t, "2 unknown route")
+	decodeAPIError(respRecorder, codeNotFound, t, "2 unknown route")
+
+	// 3. a client's own request id is echoed back ============================================================================
+	t.Log("3. X-Request-ID is kept")
+	req := httptest.NewRequest("GET", "/inventory/Durian", nil)
+	req.Header.Set(requestIDHeader, "checkout-lane-7")
+	respRecorder = httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	if apiErr := decodeAPIError(respRecorder, codeNotFound, t, "3 getItem"); apiErr.RequestID != "checkout-lane-7" {
+		t.Errorf("3 -- actual request id - %v | expected - checkout-lane-7", apiErr.RequestID)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETags (RFC 7232) let a client skip downloading what it already has, and keep two employees editing
+// the same item from silently overwriting each other. An item's ETag is its PID and version, so it
+// changes with every revision. The inventory's is a hash of the response, it covers every query at once
+var errPreconditionFailed = errors.New("the item has changed since the client read it")
+
+// _itemETag is the strong ETag of item
+func _itemETag(item Item) string {
+	return fmt.Sprintf(`"%v.%d"`, foldKey(item.PID), item.Version)
+}
+
+// _bodyETag is the strong ETag of a response, the body along with whatever headers go with it
+func _bodyETag(body []byte, headers ...string) string {
+	hash := sha256.New()
+	hash.Write(body)
+	for _, header := range headers {
+		hash.Write([]byte("\n" + header))
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// _etagsMatch reports whether the list of ETags in an If-Match or If-None-Match header has etag in it,
+// "*" matches anything. If-None-Match compares them weakly (a W/ tag matches too), If-Match doesn't
+func _etagsMatch(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// _notModified sets the ETag header and, when the client's If-None-Match already has it,
+// answers 304 Not Modified without a body. The handler is done when it returns true
+func _notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && _etagsMatch(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified) // return 304 Not Modified
+		return true
+	}
+	return false
+}
+
+// _ifMatch wraps a Modify change so it only runs on the version of the item the client's If-Match names,
+// anything else is errPreconditionFailed. The check runs under the store's lock, so nobody can change
+// the item between the check and the write. Without an If-Match header the change always runs
+func _ifMatch(r *http.Request, change func(item *Item) error) func(item *Item) error {
+	header := r.Header.Get("If-Match")
+	return func(item *Item) error {
+		if header != "" && !_etagsMatch(header, _itemETag(*item), false) {
+			return errPreconditionFailed
+		}
+		return change(item)
+	}
+}
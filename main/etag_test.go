@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveWithHeader is serveRoute with one more request header, e.g. If-Match
+func serveWithHeader(router http.Handler, method string, path string, body interface{}, header string, value string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set(header, value)
+	respRecorder := httptest.NewRecorder()
+	router.ServeHTTP(respRecorder, req)
+	return respRecorder
+}
+
+// etagReq sends a GET and returns the ETag of the response, which has to be there
+func etagReq(router http.Handler, path string, t *testing.T) string {
+	respRecorder := serveRoute(router, "GET", path, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "etagReq "+path)
+	etag := respRecorder.Header().Get("ETag")
+	if etag == "" {
+		t.Errorf("etagReq %v -- expected an ETag", path)
+	}
+	return etag
+}
+
+func TestConditionalGet(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+	lettuce := "/inventory/A12T-4GH7-QPL9-3N4M"
+
+	// 1. an item's ETag is its version ==========================================================================================
+	t.Log("1. get the lettuce with and without its ETag")
+	etag := etagReq(router, lettuce, t)
+	if etag != `"A12T-4GH7-QPL9-3N4M.1"` {
+		t.Errorf("1 -- actual ETag - %v | expected the PID and version 1", etag)
+	}
+	// by name it is the same item, so the same ETag
+	if byName := etagReq(router, "/inventory/lettuce", t); byName != etag {
+		t.Errorf("1 -- actual ETag by name - %v | expected - %v", byName, etag)
+	}
+	respRecorder := serveWithHeader(router, "GET", lettuce, nil, "If-None-Match", etag)
+	checkStatus(respRecorder.Code, http.StatusNotModified, t, "1 If-None-Match")
+	if respRecorder.Body.Len() != 0 || respRecorder.Header().Get("ETag") != etag {
+		t.Errorf("1 -- a 304 has no body and the same ETag, actual body - %q ETag - %v", respRecorder.Body.String(), respRecorder.Header().Get("ETag"))
+	}
+	checkStatus(serveWithHeader(router, "GET", lettuce, nil, "If-None-Match", `"other", W/`+etag).Code, http.StatusNotModified, t, "1 weak in a list")
+	checkStatus(serveWithHeader(router, "GET", lettuce, nil, "If-None-Match", `"A12T-4GH7-QPL9-3N4M.0"`).Code, http.StatusOK, t, "1 old version")
+
+	// 2. the inventory's ETag changes with what it holds ======================================================================
+	t.Log("2. get the inventory before and after a change")
+	inventoryETag := etagReq(router, "/inventory", t)
+	checkStatus(serveWithHeader(router, "GET", "/inventory", nil, "If-None-Match", inventoryETag).Code, http.StatusNotModified, t, "2 unchanged")
+	if filtered := etagReq(router, "/inventory?sort=name", t); filtered == inventoryETag {
+		t.Errorf("2 -- a different response should have a different ETag")
+	}
+	stockReq("receive", "A12T-4GH7-QPL9-3N4M", stockRequest{Quantity: 5}, http.StatusOK, t)
+	checkStatus(serveWithHeader(router, "GET", "/inventory", nil, "If-None-Match", inventoryETag).Code, http.StatusOK, t, "2 changed")
+	checkStatus(serveWithHeader(router, "GET", lettuce, nil, "If-None-Match", etag).Code, http.StatusOK, t, "2 changed lettuce")
+}
+
+func TestIfMatch(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+	lettuce := "/inventory/A12T-4GH7-QPL9-3N4M"
+
+	// 1. two employees read the lettuce, the first one to save wins ============================================================
+	t.Log("1. two PATCHes with the same If-Match")
+	etag := etagReq(router, lettuce, t)
+	respRecorder := serveWithHeader(router, "PATCH", lettuce, map[string]interface{}{"price": 2.50}, "If-Match", etag)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "1 first")
+	newETag := respRecorder.Header().Get("ETag")
+	if newETag != `"A12T-4GH7-QPL9-3N4M.2"` {
+		t.Errorf("1 -- actual ETag after the update - %v | expected version 2", newETag)
+	}
+	respRecorder = serveWithHeader(router, "PATCH", lettuce, map[string]interface{}{"price": 9.99}, "If-Match", etag)
+	checkStatus(respRecorder.Code, http.StatusPreconditionFailed, t, "1 second")
+	decodeAPIError(respRecorder, codePreconditionFailed, t, "1 second")
+	if item := getItemReq("A12T-4GH7-QPL9-3N4M", t); item.Price != 250 {
+		t.Errorf("1 -- actual price - %v | expected the first employee's 2.50", item.Price)
+	}
+
+	// 2. PUT and DELETE check it too, a weak ETag never matches ===================================================================
+	t.Log("2. PUT and DELETE with stale, weak and current ETags")
+	replacement := Item{Name: "Romaine Lettuce", Price: 399}
+	checkStatus(serveWithHeader(router, "PUT", lettuce, replacement, "If-Match", etag).Code, http.StatusPreconditionFailed, t, "2 stale PUT")
+	checkStatus(serveWithHeader(router, "PUT", lettuce, replacement, "If-Match", "W/"+newETag).Code, http.StatusPreconditionFailed, t, "2 weak PUT")
+	respRecorder = serveWithHeader(router, "PUT", lettuce, replacement, "If-Match", `"nope", `+newETag)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "2 PUT")
+	checkStatus(serveWithHeader(router, "DELETE", lettuce, nil, "If-Match", newETag).Code, http.StatusPreconditionFailed, t, "2 stale DELETE")
+	checkStatus(serveWithHeader(router, "DELETE", lettuce, nil, "If-Match", respRecorder.Header().Get("ETag")).Code, http.StatusOK, t, "2 DELETE")
+
+	// 3. * matches any item there is, but not one that isn't =====================================================================
+	t.Log("3. If-Match: *")
+	checkStatus(serveWithHeader(router, "PATCH", "/inventory/E5T6-9UI3-TH15-QR88", map[string]interface{}{"price": 1}, "If-Match", "*").Code, http.StatusOK, t, "3 peach")
+	checkStatus(serveWithHeader(router, "PATCH", lettuce, map[string]interface{}{"price": 1}, "If-Match", "*").Code, http.StatusNotFound, t, "3 deleted lettuce")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the usual BM25 constants. k1 is how quickly a term showing up again stops adding to the score,
+// b is how much a long item is held back for having more words to match by chance
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// a word in the name counts this many times over, "pepper" in the name says more than in a description
+	nameWeight = 2
+)
+
+// stopWords are too common to tell one item from another, they are left out of the index and of searches
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "if": true, "in": true, "into": true, "is": true, "it": true,
+	"no": true, "not": true, "of": true, "on": true, "or": true, "so": true, "such": true, "that": true,
+	"the": true, "their": true, "then": true, "there": true, "these": true, "they": true, "this": true,
+	"to": true, "was": true, "will": true, "with": true,
+}
+
+// textResult is one item found by a full-text search, the higher the Score the better the match
+type textResult struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"`
+}
+
+// tokenize splits text into search terms: lower case runs of letters and digits, stop words
+// dropped and everything else stemmed. "Organic Green Peppers" is [organ green pepper]
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if !stopWords[word] {
+			terms = append(terms, stem(word))
+		}
+	}
+	return terms
+}
+
+// itemTerms counts the terms of every textual field of item, the name counting nameWeight times
+func itemTerms(item Item) map[string]int {
+	counts := map[string]int{}
+	for _, term := range tokenize(item.Name) {
+		counts[term] += nameWeight
+	}
+	for _, term := range tokenize(strings.Join(item.Tags, " ") + " " + item.Description) {
+		counts[term]++
+	}
+	return counts
+}
+
+// textIndex is an inverted index from every term to the items that have it. It doesn't lock,
+// the memory store keeps it next to its other indexes and under the same lock
+type textIndex struct {
+	// postings maps every term to the folded PIDs of the items that have it, and how often
+	postings map[string]map[string]int
+	// lengths is how many terms each item has, for BM25's length normalization
+	lengths     map[string]int
+	totalLength int
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{postings: map[string]map[string]int{}, lengths: map[string]int{}}
+}
+
+func (x *textIndex) add(key string, item Item) {
+	length := 0
+	for term, count := range itemTerms(item) {
+		if x.postings[term] == nil {
+			x.postings[term] = map[string]int{}
+		}
+		x.postings[term][key] = count
+		length += count
+	}
+	x.lengths[key] = length
+	x.totalLength += length
+}
+
+// remove takes item back out, it has to be the item exactly as it was added
+func (x *textIndex) remove(key string, item Item) {
+	for term := range itemTerms(item) {
+		delete(x.postings[term], key)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+	x.totalLength -= x.lengths[key]
+	delete(x.lengths, key)
+}
+
+// search scores every item that has at least one of the query's terms with BM25, keyed by folded PID
+func (x *textIndex) search(query string) map[string]float64 {
+	scores := map[string]float64{}
+	if len(x.lengths) == 0 {
+		return scores
+	}
+	items := float64(len(x.lengths))
+	averageLength := float64(x.totalLength) / items
+
+	seen := map[string]bool{}
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := x.postings[term]
+		// terms only a few items have are worth more than terms most of them have
+		idf := math.Log(1 + (items-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for key, count := range postings {
+			tf := float64(count)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(x.lengths[key])/averageLength)
+			scores[key] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	return scores
+}
+
+// rankTextResults sorts results best first, ties by PID, and keeps the first limit of them
+func rankTextResults(results []textResult, limit int) []textResult {
+	for i := range results {
+		results[i].Score = roundScore(results[i].Score)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return foldKey(results[i].Item.PID) < foldKey(results[j].Item.PID)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// the customer kiosk searches every word of the names, tags and descriptions at once,
+// GET /search?q=green pepper organic returns the items with any of those words, best match first
+func searchText(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: searchText()")
+
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	var problems []fieldError
+	if q == "" {
+		problems = append(problems, fieldError{Field: "q", Message: "is required"})
+	}
+	limit := defaultSearchResults
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxPageSize {
+			problems = append(problems, fieldError{Field: "limit", Message: fmt.Sprintf("has to be a number from 1 to %d", maxPageSize)})
+		}
+	}
+	if len(problems) > 0 {
+		_writeError(w, "searchText", http.StatusBadRequest, codeInvalidParameter, "Could not understand the search.", problems...)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(store.SearchText(q, limit))
+}
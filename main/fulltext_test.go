@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// textSearchReq sends GET /search with the given query and returns the names of the items found, best first
+func textSearchReq(query string, t *testing.T) []string {
+	respRecorder := serveRoute(newRouter(), "GET", "/search"+query, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "textSearchReq "+query)
+
+	var results []textResult
+	err := json.NewDecoder(respRecorder.Body).Decode(&results)
+	checkResponseError(err, respRecorder, "[]textResult", t)
+
+	names := []string{}
+	for i, result := range results {
+		if i > 0 && result.Score > results[i-1].Score {
+			t.Errorf("textSearchReq %v -- results aren't ranked by score: %+v", query, results)
+		}
+		names = append(names, result.Item.Name)
+	}
+	return names
+}
+
+func TestStem(t *testing.T) {
+	// a few of the examples from the Porter paper and some of our own
+	for word, expected := range map[string]string{
+		"caresses": "caress", "ponies": "poni", "agreed": "agre", "hopping": "hop", "filing": "file",
+		"happy": "happi", "relational": "relat", "generalizations": "gener", "adjustment": "adjust",
+		"controll": "control", "peppers": "pepper", "organically": "organ", "organic": "organ", "be": "be",
+	} {
+		if actual := stem(word); actual != expected {
+			t.Errorf("stem(%v) -- actual - %v | expected - %v", word, actual, expected)
+		}
+	}
+	checkNames(tokenize("The Organic, Green-Peppers of 2026!"), []string{"organ", "green", "pepper", "2026"}, t, "tokenize")
+}
+
+func TestSearchText(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+	pepper := "YRT6-72AS-K736-L4AR"
+
+	t.Log("1. add items with descriptions and tag the green pepper")
+	addItemsReq([]Item{
+		{PID: "RED0-0000-0000-0001", Name: "Red Pepper", Price: 129, Tags: []string{"organic"}},
+		{PID: "SALS-0000-0000-0002", Name: "Salsa Verde", Price: 499,
+			Description: "Roasted green tomatillos and peppers, a little heat."},
+	}, t)
+	checkStatus(serveRoute(router, "POST", "/inventory/"+pepper+"/tags", tagsRequest{Tags: []string{"organic"}}).Code, http.StatusOK, t, "1 addTags")
+
+	// 2. every word counts, the item with all of them ranks first =========================================================
+	t.Log("2. search for green pepper organic")
+	checkNames(textSearchReq("?q=green+pepper+organic", t), []string{"Green Pepper", "Red Pepper", "Salsa Verde"}, t, "2")
+	// the two peppers tie on "pepper" and go by PID, the salsa's long description counts against it
+	checkNames(textSearchReq("?q=the+peppers", t), []string{"Red Pepper", "Green Pepper", "Salsa Verde"}, t, "2 stems and stop words")
+	checkNames(textSearchReq("?q=the", t), []string{}, t, "2 only stop words")
+	checkNames(textSearchReq("?q=red+pepper&limit=1", t), []string{"Red Pepper"}, t, "2 limit")
+
+	// 3. the index follows updates and deletes ============================================================================
+	t.Log("3. describe the peach, delete the salsa")
+	checkStatus(serveRoute(router, "PATCH", "/inventory/E5T6-9UI3-TH15-QR88", map[string]interface{}{"description": "Sweet and juicy"}).Code,
+		http.StatusOK, t, "3 patch")
+	checkNames(textSearchReq("?q=juicy", t), []string{"Peach"}, t, "3 juicy")
+	deleteItemReq("SALS-0000-0000-0002", t)
+	checkNames(textSearchReq("?q=tomatillos", t), []string{}, t, "3 deleted")
+
+	for _, bad := range []string{"", "?q=+", "?q=pepper&limit=0"} {
+		checkStatus(serveRoute(router, "GET", "/search"+bad, nil).Code, http.StatusBadRequest, t, "3 "+bad)
+	}
+}
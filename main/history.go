@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Revision is one version of an item, the item was exactly this from Timestamp until its next revision.
+// Every add, change, delete and restore of an item is a revision with a version one higher than the last.
+// Purging it is the last one, with a null Item
+type Revision struct {
+	PID       string    `json:"pid"`
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Item      *Item     `json:"item"`
+}
+
+// revise appends a revision of the item with the given PID made at the given time, a nil item is the
+// revision that purged it. The caller holds the lock and item already has its version
+func (s *memoryStore) revise(pid string, item *Item, at time.Time) {
+	revision := Revision{PID: pid, Version: s.nextVersion(pid), Timestamp: at}
+	if item != nil {
+		snapshot := _snapshot(*item)
+		revision.Version, revision.Item = item.Version, &snapshot
+	}
+	key := foldKey(pid)
+	s.versions[key] = append(s.versions[key], len(s.history))
+	s.history = append(s.history, revision)
+}
+
+// nextVersion is the version the next revision of the item with the given PID gets. It keeps counting
+// after a purge, so a PID that is used again never repeats a version. The caller holds the lock
+func (s *memoryStore) nextVersion(pid string) int {
+	positions := s.versions[foldKey(pid)]
+	if len(positions) == 0 {
+		return 1
+	}
+	return s.history[positions[len(positions)-1]].Version + 1
+}
+
+// loadHistory throws away the history and starts over with the given revisions, the caller holds the lock
+func (s *memoryStore) loadHistory(history []Revision) {
+	s.history = history
+	s.versions = map[string][]int{}
+	for i, revision := range history {
+		key := foldKey(revision.PID)
+		s.versions[key] = append(s.versions[key], i)
+	}
+}
+
+// revisions returns a copy of the whole history, oldest first, for walStore's snapshots and fileStore's file
+func (s *memoryStore) revisions() []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Revision(nil), s.history...)
+}
+
+// revisionCount is how many revisions there are, replace can cut the history back to it
+func (s *memoryStore) revisionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.history)
+}
+
+func (s *memoryStore) History(pid string) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	positions := s.versions[foldKey(pid)]
+	if len(positions) == 0 {
+		return nil, ErrItemNotFound
+	}
+	revisions := make([]Revision, len(positions))
+	for i, position := range positions {
+		revisions[i] = s.history[position]
+		if revisions[i].Item != nil {
+			item := _snapshot(*revisions[i].Item)
+			revisions[i].Item = &item
+		}
+	}
+	return revisions, nil
+}
+
+// ListAsOf replays the history up to the first revision made at or after at. Items come back in
+// the order they were added, and an item that was purged and added again counts as added again
+func (s *memoryStore) ListAsOf(at time.Time) []Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	current := map[string]*Item{}
+	position := map[string]int{}
+	var order []string
+	for i := range s.history {
+		revision := s.history[i]
+		if !revision.Timestamp.Before(at) {
+			break
+		}
+		key := foldKey(revision.PID)
+		if revision.Item == nil {
+			delete(current, key)
+			continue
+		}
+		if _, there := current[key]; !there {
+			position[key] = len(order)
+			order = append(order, key)
+		}
+		current[key] = revision.Item
+	}
+
+	items := make([]Item, 0, len(current))
+	for i, key := range order {
+		if item, there := current[key]; there && position[key] == i {
+			items = append(items, _snapshot(*item))
+		}
+	}
+	return items
+}
+
+// _canSeeHistory lets those who can read the history ask for ?asOf=, anyone else gets a 403.
+// With -auth=false there is no principal and everyone can
+func _canSeeHistory(w http.ResponseWriter, r *http.Request, caller string) bool {
+	if principal, found := principalOf(r); found && !principal.can(PermHistoryRead) {
+		_writeError(w, caller, http.StatusForbidden, codeForbidden, "Only those who can read the history can look into the past.",
+			fieldError{Field: "asOf", Message: "isn't allowed for a " + string(principal.Role)})
+		return false
+	}
+	return true
+}
+
+// finance wants to know what we charged for lettuce last Tuesday, this is every version of an item,
+// oldest first. Deleted and purged items still have their history
+func getItemHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getItemHistory()")
+
+	pid := mux.Vars(r)["pid"]
+	revisions, err := store.History(pid)
+	if err != nil {
+		_writeStoreError(w, "getItemHistory", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(revisions)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// historyReq sends GET /inventory/{pid}/history and returns the revisions
+func historyReq(router http.Handler, pid string, t *testing.T) []Revision {
+	respRecorder := serveRoute(router, "GET", "/inventory/"+pid+"/history", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "historyReq "+pid)
+
+	var revisions []Revision
+	err := json.NewDecoder(respRecorder.Body).Decode(&revisions)
+	checkResponseError(err, respRecorder, "[]Revision", t)
+	return revisions
+}
+
+// asOfQuery is the ?asOf= for the given time, to the nanosecond
+func asOfQuery(at time.Time) string {
+	return "?asOf=" + url.QueryEscape(at.Format(time.RFC3339Nano))
+}
+
+// checkVersions logs an error when the revisions don't have exactly the expected versions, in order
+func checkVersions(revisions []Revision, expected []int, t *testing.T, checkpoint string) {
+	actual := []int{}
+	for i, revision := range revisions {
+		actual = append(actual, revision.Version)
+		if i > 0 && revision.Timestamp.Before(revisions[i-1].Timestamp) {
+			t.Errorf("%v -- revisions aren't oldest first: %+v", checkpoint, revisions)
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("%v -- actual versions - %v | expected - %v", checkpoint, actual, expected)
+		return
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Errorf("%v -- actual versions - %v | expected - %v", checkpoint, actual, expected)
+			return
+		}
+	}
+}
+
+// exerciseHistory walks a Store through the life of an item, checking its versions and the inventory
+// as it was along the way. Any Store implementation should pass it, starting out empty
+func exerciseHistory(s Store, t *testing.T) {
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+	checkError(s.Add(pear), t)
+	added := time.Now()
+
+	pear.Price = 150
+	checkError(s.Update(pear), t)
+	updated := time.Now()
+
+	modified, err := s.Modify(pear.PID, func(item *Item) error {
+		item.Quantity = 12
+		item.Version = 99
+		return nil
+	})
+	checkError(err, t)
+	if modified.Version != 3 {
+		t.Errorf("Modify -- actual version - %v | expected - 3, the store picks it", modified.Version)
+	}
+	_, err = s.Delete(pear.PID)
+	checkError(err, t)
+	purged := time.Now()
+
+	// a PID that is used again keeps counting where it left off
+	batch := []Item{{PID: "p3ar-0000-0000-0001", Name: "Asian Pear", Price: 210}}
+	checkError(s.AddBatch(batch), t)
+	if batch[0].Version != 5 {
+		t.Errorf("AddBatch -- actual version - %v | expected - 5", batch[0].Version)
+	}
+
+	revisions, err := s.History("P3AR-0000-0000-0001")
+	checkError(err, t)
+	checkVersions(revisions, []int{1, 2, 3, 4, 5}, t, "History")
+	if len(revisions) == 5 && (revisions[3].Item != nil || revisions[1].Item.Price != 150 || revisions[4].Item.Name != "Asian Pear") {
+		t.Errorf("History -- actual - %+v | expected the purge as a revision without an item", revisions)
+	}
+	if _, err := s.History("N0NE-0000-0000-0000"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("History -- expected ErrItemNotFound, got: %v", err)
+	}
+
+	cases := []struct {
+		at       time.Time
+		expected []Item
+	}{
+		{added.Add(-time.Hour), []Item{}},
+		{added, []Item{{PID: pear.PID, Name: "Pear", Price: 133}}},
+		{updated, []Item{{PID: pear.PID, Name: "Pear", Price: 150}}},
+		{purged, []Item{}},
+		{time.Now(), []Item{batch[0]}},
+	}
+	for i, c := range cases {
+		compareActualWithExpected(s.ListAsOf(c.at), c.expected, t, fmt.Sprintf("ListAsOf %d", i+1))
+	}
+}
+
+func TestMemoryStoreHistory(t *testing.T) {
+	exerciseHistory(newMemoryStore(nil), t)
+}
+
+// checkReopenedHistory logs an error unless reopened brings back the history of the pear exerciseHistory
+// left in s, each revision made at the time it was, and the same inventory as of every one of them
+func checkReopenedHistory(reopened Store, s Store, t *testing.T) {
+	expected, err := s.History("P3AR-0000-0000-0001")
+	checkError(err, t)
+	revisions, err := reopened.History("P3AR-0000-0000-0001")
+	checkError(err, t)
+	checkVersions(revisions, []int{1, 2, 3, 4, 5}, t, "reopened")
+	for i := range revisions {
+		if i < len(expected) && !revisions[i].Timestamp.Equal(expected[i].Timestamp) {
+			t.Errorf("reopened -- revision %v was made at %v, not %v", revisions[i].Version, revisions[i].Timestamp, expected[i].Timestamp)
+		}
+	}
+	for _, revision := range expected {
+		at := revision.Timestamp.Add(time.Nanosecond)
+		compareActualWithExpected(reopened.ListAsOf(at), s.ListAsOf(at), t, fmt.Sprintf("reopened ListAsOf version %v", revision.Version))
+	}
+}
+
+func TestFileStoreHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	s, err := openFileStore(path, nil)
+	checkError(err, t)
+	exerciseHistory(s, t)
+
+	// the history is in the file with the items, a restart brings it back as it was
+	reopened, err := openFileStore(path, nil)
+	checkError(err, t)
+	checkReopenedHistory(reopened, s, t)
+}
+
+func TestWALStoreHistory(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), nil, 3)
+	checkError(err, t)
+	exerciseHistory(s, t)
+
+	// part of the history is in the snapshot and part of it in the log, a restart brings all of it back as it was
+	reopened := reopenWALStore(s, 3, t)
+	defer reopened.Close()
+	checkReopenedHistory(reopened, s, t)
+}
+
+func TestItemHistory(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+	lettuce, peach := "A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88"
+
+	// 1. every item starts out at version 1 =====================================================================================
+	t.Log("1. the history of an item that never changed")
+	checkVersions(historyReq(router, lettuce, t), []int{1}, t, "1")
+	if item := getItemReq(lettuce, t); item.Version != 1 {
+		t.Errorf("1 -- actual version - %v | expected - 1", item.Version)
+	}
+	opened := time.Now()
+
+	// 2. every change is a new version ============================================================================================
+	t.Log("2. reprice the lettuce twice and delete the peach")
+	updateReq("PATCH", lettuce, map[string]interface{}{"price": 2.50}, http.StatusOK, t)
+	repriced := time.Now()
+	updateReq("PATCH", lettuce, map[string]interface{}{"price": 2.75}, http.StatusOK, t)
+	deleteItemReq(peach, t)
+	revisions := historyReq(router, lettuce, t)
+	checkVersions(revisions, []int{1, 2, 3}, t, "2 lettuce")
+	if len(revisions) == 3 && (revisions[0].Item.Price != 346 || revisions[1].Item.Price != 250 || revisions[2].Item.Price != 275) {
+		t.Errorf("2 -- actual - %+v | expected the lettuce at 3.46, 2.50 and 2.75", revisions)
+	}
+	revisions = historyReq(router, "e5t6-9ui3-th15-qr88", t)
+	checkVersions(revisions, []int{1, 2}, t, "2 peach")
+	if len(revisions) == 2 && revisions[1].Item.DeletedAt == nil {
+		t.Errorf("2 -- actual - %+v | expected the second revision to be the delete", revisions[1])
+	}
+
+	// 3. what did we charge for lettuce before all that ===========================================================================
+	t.Log("3. the inventory as of earlier")
+	items, _ := pageReq(asOfQuery(opened), t)
+	compareActualWithExpected(items, defaultInventory(), t, "3 opened")
+	items, _ = pageReq(asOfQuery(repriced)+"&maxPrice=2.60", t)
+	if len(items) != 2 || items[0].Price != 250 || items[0].Version != 2 {
+		t.Errorf("3 -- actual - %+v | expected the lettuce at 2.50 and the green pepper", items)
+	}
+	// the peach was deleted by now
+	checkNames(filterInventoryReq(asOfQuery(time.Now()), http.StatusOK, t), []string{"Lettuce", "Green Pepper", "Gala Apple"}, t, "3 now")
+	checkNames(filterInventoryReq(asOfQuery(time.Now())+"&includeDeleted=true", http.StatusOK, t),
+		[]string{"Lettuce", "Peach", "Green Pepper", "Gala Apple"}, t, "3 now with deleted")
+	checkNames(filterInventoryReq("?asOf=2000-01-01", http.StatusOK, t), []string{}, t, "3 long ago")
+
+	// 4. bad requests ================================================================================================================
+	t.Log("4. a bad asOf and an item that never was")
+	decodeAPIError(serveRoute(router, "GET", "/inventory?asOf=last-tuesday", nil), codeInvalidParameter, t, "4 bad asOf")
+	decodeAPIError(serveRoute(router, "GET", "/inventory/N0NE-0000-0000-0000/history", nil), codeNotFound, t, "4 history")
+}
+
+func TestHistoryPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router, keyOf := authRouter(t)
+	history := "/inventory/A12T-4GH7-QPL9-3N4M/history"
+	asOf := "/inventory" + asOfQuery(time.Now())
+	authReq(router, keyOf[RoleShopper], "GET", history, http.StatusForbidden, t)
+	authReq(router, keyOf[RoleSupplier], "GET", asOf, http.StatusForbidden, t)
+	authReq(router, keyOf[RoleEmployee], "GET", history, http.StatusOK, t)
+	authReq(router, keyOf[RoleExec], "GET", asOf, http.StatusOK, t)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// POS terminals and supplier scripts retry addItem and addItems when the network times out, and without
+// help a retried add comes back as a duplicate PID while a retried partial batch may add half of it twice.
+// A request with an Idempotency-Key header runs once, its response is kept and replayed byte for byte to
+// every request that repeats the key within the window. Keys belong to whoever sent them, two clients
+// that happen to pick the same key never see each other's responses
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader is on every replayed response, so a client can tell it didn't change anything this time
+	replayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength keeps a client from making us hold on to arbitrarily large keys
+	maxIdempotencyKeyLength = 255
+)
+
+var errIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+// savedResponse is the first response to a key, fingerprint is the request it answered
+type savedResponse struct {
+	fingerprint string
+	createdAt   time.Time
+	// done is closed once the response is filled in, a repeat that arrives while the
+	// first request is still running waits for it rather than running alongside it
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+}
+
+type savedKey struct {
+	key       string
+	createdAt time.Time
+}
+
+// idempotencyStore holds the responses to every key for window. Nothing saves them, a retry
+// that only arrives after a restart is taken as a new request
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*savedResponse
+	// order has every key oldest first, so the expired ones are dropped from the front
+	order  []savedKey
+	window time.Duration
+	now    func() time.Time
+}
+
+// idempotency has the response withIdempotency sent for each Idempotency-Key, on addItems and on
+// receiving a purchase order. They are replayed for a day unless the -idempotency-window flag says otherwise
+var idempotency = newIdempotencyStore(24 * time.Hour)
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{responses: map[string]*savedResponse{}, window: window, now: time.Now}
+}
+
+// begin returns the response saved for key, or saves an empty one and returns it with first true,
+// then the caller runs the request and fills it in with finish
+func (s *idempotencyStore) begin(key string, fingerprint string) (saved *savedResponse, first bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	if saved, found := s.responses[key]; found {
+		if saved.fingerprint != fingerprint {
+			return nil, false, errIdempotencyKeyReused
+		}
+		return saved, false, nil
+	}
+	saved = &savedResponse{fingerprint: fingerprint, createdAt: s.now(), done: make(chan struct{})}
+	s.responses[key] = saved
+	s.order = append(s.order, savedKey{key: key, createdAt: saved.createdAt})
+	return saved, true, nil
+}
+
+// finish fills in the response to key and wakes up whoever is waiting for it. A 5xx isn't kept,
+// it was our fault and nothing was changed, so the key is forgotten and the next request with it runs again
+func (s *idempotencyStore) finish(key string, saved *savedResponse, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved.status, saved.header, saved.body = status, header, body
+	if status >= http.StatusInternalServerError && s.responses[key] == saved {
+		delete(s.responses, key)
+	}
+	close(saved.done)
+}
+
+// expire forgets every response older than the window, the caller holds the lock
+func (s *idempotencyStore) expire() {
+	cutoff := s.now().Add(-s.window)
+	for len(s.order) > 0 && !s.order[0].createdAt.After(cutoff) {
+		oldest := s.order[0]
+		// the key may have been forgotten after a 5xx and saved again since
+		if saved, found := s.responses[oldest.key]; found && saved.createdAt.Equal(oldest.createdAt) {
+			delete(s.responses, oldest.key)
+		}
+		s.order = s.order[1:]
+	}
+}
+
+// recordingWriter passes a response through to the client and keeps a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// withIdempotency runs next once per Idempotency-Key and replays its response to every repeat of the key.
+// A repeat has to be the same request, method, URL and body, or it gets a 422. Requests without the header
+// go straight through
+func withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			_writeError(w, "withIdempotency", http.StatusBadRequest, codeInvalidParameter, "The Idempotency-Key is too long.",
+				fieldError{Field: idempotencyHeader, Message: "has to be at most 255 characters"})
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			_writeError(w, "withIdempotency", http.StatusBadRequest, codeInvalidJSON, "Could not read the request body.")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal, _ := principalOf(r)
+		scoped := principal.ID + "\n" + key
+		fingerprint := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		for {
+			saved, first, err := idempotency.begin(scoped, hex.EncodeToString(fingerprint[:]))
+			if errors.Is(err, errIdempotencyKeyReused) {
+				_writeError(w, "withIdempotency", http.StatusUnprocessableEntity, codeIdempotencyKeyReused,
+					"This Idempotency-Key was already used for a different request, use a new key for every new request.")
+				return
+			}
+			if first {
+				recorder := &recordingWriter{ResponseWriter: w}
+				// a handler that panics still has to let the repeats waiting on it go, as a 5xx they can retry
+				defer func() {
+					if recorder.status == 0 {
+						recorder.status = http.StatusInternalServerError
+					}
+					idempotency.finish(scoped, saved, recorder.status, w.Header().Clone(), recorder.body.Bytes())
+				}()
+				next(recorder, r)
+				return
+			}
+
+			<-saved.done
+			if saved.status < http.StatusInternalServerError {
+				log.Printf("replaying the response to Idempotency-Key %q [request %v]", key, _requestID(w))
+				for name, values := range saved.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(replayedHeader, "true")
+				w.WriteHeader(saved.status)
+				w.Write(saved.body)
+				return
+			}
+			// the first request failed on our end and changed nothing, so this one gets to try
+		}
+	}
+}
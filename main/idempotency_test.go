@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useIdempotency starts the test over with no saved responses, it returns the store to move its clock
+func useIdempotency(t *testing.T) *idempotencyStore {
+	old := idempotency
+	idempotency = newIdempotencyStore(time.Hour)
+	t.Cleanup(func() { idempotency = old })
+	return idempotency
+}
+
+// checkReplayed logs an error when the response isn't marked as replayed when it should be, or the other way around
+func checkReplayed(respRecorder *httptest.ResponseRecorder, replayed bool, t *testing.T, checkpoint string) {
+	if actual := respRecorder.Header().Get(replayedHeader) == "true"; actual != replayed {
+		t.Errorf("%v -- actual replayed - %v | expected - %v", checkpoint, actual, replayed)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	clock := useIdempotency(t)
+	now := time.Now()
+	clock.now = func() time.Time { return now }
+	router := newRouter()
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+
+	// 1. a retried addItem gets the first response back, not a duplicate PID ====================================================
+	t.Log("1. add the pear twice with the same key")
+	first := serveWithHeader(router, "POST", "/inventory/addItem", pear, idempotencyHeader, "pear-1")
+	checkStatus(first.Code, http.StatusOK, t, "1 first")
+	checkReplayed(first, false, t, "1 first")
+	retry := serveWithHeader(router, "POST", "/inventory/addItem", pear, idempotencyHeader, "pear-1")
+	checkStatus(retry.Code, http.StatusOK, t, "1 retry")
+	checkReplayed(retry, true, t, "1 retry")
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("1 -- the retry should get the first response verbatim, actual - %v | expected - %v", retry.Body.String(), first.Body.String())
+	}
+	if len(store.List()) != 5 {
+		t.Errorf("1 -- actual inventory size - %v | expected the pear added once", len(store.List()))
+	}
+	// without a key it is a new request, and the PID is taken
+	checkStatus(serveRoute(router, "POST", "/inventory/addItem", pear).Code, http.StatusBadRequest, t, "1 no key")
+
+	// 2. the same key for another request is a mistake ===========================================================================
+	t.Log("2. reuse the key with a different body and on another route")
+	pear.Price = 150
+	respRecorder := serveWithHeader(router, "POST", "/inventory/addItem", pear, idempotencyHeader, "pear-1")
+	checkStatus(respRecorder.Code, http.StatusUnprocessableEntity, t, "2 different body")
+	decodeAPIError(respRecorder, codeIdempotencyKeyReused, t, "2 different body")
+	checkStatus(serveWithHeader(router, "POST", "/inventory/addItems", []Item{pear}, idempotencyHeader, "pear-1").Code,
+		http.StatusUnprocessableEntity, t, "2 other route")
+
+	// 3. errors are replayed too, a partial batch isn't added twice ===============================================================
+	t.Log("3. a rejected add and a partial batch")
+	bad := serveWithHeader(router, "POST", "/inventory/addItem", Item{PID: "bad"}, idempotencyHeader, "bad-1")
+	checkStatus(bad.Code, http.StatusBadRequest, t, "3 bad")
+	checkReplayed(serveWithHeader(router, "POST", "/inventory/addItem", Item{PID: "bad"}, idempotencyHeader, "bad-1"), true, t, "3 bad retry")
+	batch := []Item{{PID: "0RNG-0000-0000-0002", Name: "Orange", Price: 89}, {PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}}
+	first = serveWithHeader(router, "POST", "/inventory/addItems?mode=partial", batch, idempotencyHeader, "batch-1")
+	checkStatus(first.Code, http.StatusMultiStatus, t, "3 batch")
+	retry = serveWithHeader(router, "POST", "/inventory/addItems?mode=partial", batch, idempotencyHeader, "batch-1")
+	checkStatus(retry.Code, http.StatusMultiStatus, t, "3 batch retry")
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("3 -- actual retried report - %v | expected - %v", retry.Body.String(), first.Body.String())
+	}
+	// the mode is part of the request, the same batch atomically is another request
+	checkStatus(serveWithHeader(router, "POST", "/inventory/addItems", batch, idempotencyHeader, "batch-1").Code,
+		http.StatusUnprocessableEntity, t, "3 other mode")
+
+	// 4. a key is only kept for the window =========================================================================================
+	t.Log("4. retry after the window is over")
+	now = now.Add(time.Hour + time.Second)
+	respRecorder = serveWithHeader(router, "POST", "/inventory/addItem", Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}, idempotencyHeader, "pear-1")
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "4 expired")
+	decodeAPIError(respRecorder, codeValidationFailed, t, "4 expired")
+	if len(clock.responses) != 1 || len(clock.order) != 1 {
+		t.Errorf("4 -- actual saved responses - %v | expected only the new one", len(clock.responses))
+	}
+
+	// 5. a key that is too long ====================================================================================================
+	t.Log("5. a key longer than 255 characters")
+	long := make([]byte, maxIdempotencyKeyLength+1)
+	for i := range long {
+		long[i] = 'k'
+	}
+	decodeAPIError(serveWithHeader(router, "POST", "/inventory/addItem", pear, idempotencyHeader, string(long)), codeInvalidParameter, t, "5")
+}
+
+// retries that arrive while the first request is still running wait for it, the item is added exactly once
+func TestIdempotencyKeysConcurrently(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useIdempotency(t)
+	router := newRouter()
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+
+	const workers = 8
+	bodies := make([]string, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			respRecorder := serveWithHeader(router, "POST", "/inventory/addItem", pear, idempotencyHeader, "pear-1")
+			checkStatus(respRecorder.Code, http.StatusOK, t, "concurrent addItem")
+			bodies[i] = respRecorder.Body.String()
+		}(i)
+	}
+	wg.Wait()
+	for i := range bodies {
+		if bodies[i] != bodies[0] {
+			t.Errorf("worker %v -- actual - %v | expected the same response as everyone else - %v", i, bodies[i], bodies[0])
+		}
+	}
+}
+
+// two clients that pick the same key don't get each other's responses
+func TestIdempotencyKeysPerPrincipal(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useIdempotency(t)
+	router, keyOf := authRouter(t)
+	_, otherEmployee, err := keys.create("test-employee-2", RoleEmployee, nil, "")
+	checkError(err, t)
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+
+	addAs := func(credential string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(pear)
+		req := httptest.NewRequest("POST", "/inventory/addItem", &buf)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		req.Header.Set(idempotencyHeader, "shared")
+		respRecorder := httptest.NewRecorder()
+		router.ServeHTTP(respRecorder, req)
+		return respRecorder
+	}
+	checkStatus(addAs(keyOf[RoleEmployee]).Code, http.StatusOK, t, "first employee")
+	// the second employee's add runs, and finds the pear already there
+	respRecorder := addAs(otherEmployee)
+	checkStatus(respRecorder.Code, http.StatusBadRequest, t, "second employee")
+	checkReplayed(respRecorder, false, t, "second employee")
+	respRecorder = addAs(keyOf[RoleEmployee])
+	checkStatus(respRecorder.Code, http.StatusOK, t, "first employee again")
+	checkReplayed(respRecorder, true, t, "first employee again")
+}
@@ -1,65 +1,126 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 // A PID is a 16 digit alphanumeric product ID required in each createItems request
+// Price is kept in cents (see money.go) but reads and writes as dollars in JSON
+// Quantity is how many we have on the shelves, it is optional when adding an item (defaults to 0)
+// and afterwards only changes through the stock endpoints in stock.go
+// Tags are the item's qualities (gluten-free, grass-fed, organic...), see tags.go
+// Description is optional free text for the customer kiosk, it is searched along with the name and tags
+// DeletedAt is set when the item is discontinued with DELETE, it stays hidden until it is restored or purged (see deleted.go)
+// Version is set by the store, 1 when the item is added and one more every time it changes (see history.go)
+// ReorderPoint is the quantity at or below which we order more, ReorderQuantity how many to order. Both are
+// optional, an item without a reorder point never runs low (see alerts.go)
 type Item struct {
-	PID   string  `json:"pid"`
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	PID             string     `json:"pid"`
+	Name            string     `json:"name"`
+	Price           Money      `json:"price"`
+	Quantity        int        `json:"quantity"`
+	Tags            []string   `json:"tags,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	DeletedAt       *time.Time `json:"deletedAt,omitempty"`
+	Version         int        `json:"version"`
+	ReorderPoint    int        `json:"reorderPoint,omitempty"`
+	ReorderQuantity int        `json:"reorderQuantity,omitempty"`
 }
 
-var inventory []Item
+// pidRegex is our product ID format, compiled once since every add and lookup checks it
+var pidRegex = regexp.MustCompile("^[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}$")
+
+// store holds the inventory for every handler, main() may swap it for a durable Store
+var store Store = newMemoryStore(defaultInventory())
+
+// defaultInventory is what a brand new grocery starts out with
+func defaultInventory() []Item {
+	return []Item{
+		{
+			PID:   "A12T-4GH7-QPL9-3N4M",
+			Name:  "Lettuce",
+			Price: 346, // $3.46
+		},
+		{
+			PID:   "E5T6-9UI3-TH15-QR88",
+			Name:  "Peach",
+			Price: 299, // $2.99
+		},
+		{
+			PID:   "YRT6-72AS-K736-L4AR",
+			Name:  "Green Pepper",
+			Price: 79, // $0.79
+		},
+		{
+			PID:   "TQ4C-VV6T-75ZX-1RMR",
+			Name:  "Gala Apple",
+			Price: 359, // $3.59
+		},
+	}
+}
 
 // some users just want to see the inventory directly
+// others only want the items with certain qualities, ?tag=organic&tag=gluten-free returns the
+// items that have both tags, add &match=any for the items that have either one
+// ?minPrice= and ?maxPrice= narrow it down by price, ?sort= orders it and ?limit= pages through it,
+// the X-Next-Cursor header is the ?cursor= of the next page (see pagination.go)
+// deleted items are left out, ?includeDeleted=true brings them back for those who can delete items
+// ?asOf= is the inventory as it was at some time in the past, for those who can read the history
+// the ETag changes whenever the response would, send it back as If-None-Match to get a 304 instead
 func getInventory(w http.ResponseWriter, r *http.Request) {
-	// this if statement is here to be exercised in the event we are running this function
-	// from the api_test.go file (happens at the very beginning) because we haven't
-	// initialized these values, as main() isn't exercised in that case
-	if len(inventory) == 0 {
-		inventory = []Item{
-			{
-				PID:   "A12T-4GH7-QPL9-3N4M",
-				Name:  "Lettuce",
-				Price: 3.46,
-			},
-			{
-				PID:   "E5T6-9UI3-TH15-QR88",
-				Name:  "Peach",
-				Price: 2.99,
-			},
-			{
-				PID:   "YRT6-72AS-K736-L4AR",
-				Name:  "Green Pepper",
-				Price: 0.79,
-			},
-			{
-				PID:   "TQ4C-VV6T-75ZX-1RMR",
-				Name:  "Gala Apple",
-				Price: 3.59,
-			},
-		}
-	}
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Println("Function Called: getInventory()")
 
+	query, problems := _parseInventoryQuery(r.URL.Query())
+	if len(problems) > 0 {
+		_writeError(w, "getInventory", http.StatusBadRequest, codeInvalidParameter, "Could not understand the inventory query.", problems...)
+		return
+	}
+	if query.includeDeleted && !_canSeeDeleted(w, r, "getInventory") {
+		return
+	}
+	if !query.asOf.IsZero() && !_canSeeHistory(w, r, "getInventory") {
+		return
+	}
+
+	items, total, next := query.run(store)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != nil {
+		cursor := next.encode()
+		nextURL := *r.URL
+		values := nextURL.Query()
+		values.Set("cursor", cursor)
+		nextURL.RawQuery = values.Encode()
+		w.Header().Set("X-Next-Cursor", cursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%v>; rel="next"`, nextURL.RequestURI()))
+	}
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(items)
+	if _notModified(w, r, _bodyETag(body.Bytes(), strconv.Itoa(total))) {
+		return
+	}
 	w.WriteHeader(http.StatusOK) //return 200 OK
-	json.NewEncoder(w).Encode(inventory)
+	w.Write(body.Bytes())
 }
 
 // some users just want to look something up by name
 // other more sophisticated users such as suppliers, exec-staff or employees
 // can look up by product ID
+// with ?mode=search a name doesn't have to be exact, every item whose name starts with, contains
+// or is a typo or two away from the searchValue comes back, best match first (see search.go)
+// deleted items aren't found, unless ?includeDeleted=true is given by someone who can delete items
+// the ETag is the item's version, send it back as If-None-Match to get a 304 while it hasn't changed
 func getItem(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Println("Function Called: getItem()")
@@ -67,111 +128,222 @@ func getItem(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	searchValue := params["searchValue"]
 
-	// if our product ID format is matched, we have a PID, otherwise a name
-	regex := regexp.MustCompile("^[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}$")
-	isPID := regex.MatchString(searchValue)
-	for _, item := range inventory {
-		var itemValue = item.Name
-		if isPID {
-			itemValue = item.PID
-		}
-		//strings.ToUpper to ensure our PIDs and Names are case-insensitive
-		if strings.ToUpper(itemValue) == strings.ToUpper(searchValue) {
-			w.WriteHeader(http.StatusOK) //return 200 OK
-			json.NewEncoder(w).Encode(item)
+	query := r.URL.Query()
+	switch query.Get("mode") {
+	case "", "exact":
+	case "search":
+		_searchItems(w, searchValue, query.Get("limit"))
+		return
+	default:
+		_writeError(w, "getItem", http.StatusBadRequest, codeInvalidParameter, "mode has to be exact or search.",
+			fieldError{Field: "mode", Message: "has to be exact or search"})
+		return
+	}
+
+	includeDeleted, valid := _parseIncludeDeleted(query.Get("includeDeleted"))
+	if !valid {
+		_writeError(w, "getItem", http.StatusBadRequest, codeInvalidParameter, "includeDeleted has to be true or false.",
+			fieldError{Field: "includeDeleted", Message: "has to be true or false"})
+		return
+	}
+	if includeDeleted && !_canSeeDeleted(w, r, "getItem") {
+		return
+	}
+
+	if item, found := _findItem(searchValue, includeDeleted); found {
+		if _notModified(w, r, _itemETag(item)) {
 			return
 		}
+		w.WriteHeader(http.StatusOK) //return 200 OK
+		json.NewEncoder(w).Encode(item)
+		return
 	}
 	// item not found, return a response accordingly
-	log.Println("404 error - getItem(): Cannot find item: ", searchValue)
-	w.WriteHeader(http.StatusNotFound) // return 404 Not Found
-	w.Write([]byte("Could not find item in inventory: " + searchValue))
+	_writeError(w, "getItem", http.StatusNotFound, codeNotFound, "Could not find item in inventory: "+searchValue)
 }
 
-// If an array is not submitted a 400 is returned
+// _searchItems responds with the ranked search results for searchValue, a PID is still looked up
+// exactly and comes back as the only result. No results at all is a 404, like an exact lookup
+func _searchItems(w http.ResponseWriter, searchValue string, limitValue string) {
+	limit := defaultSearchResults
+	if limitValue != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitValue); err != nil || limit < 1 || limit > maxPageSize {
+			_writeError(w, "getItem", http.StatusBadRequest, codeInvalidParameter, "Could not understand the search.",
+				fieldError{Field: "limit", Message: fmt.Sprintf("has to be a number from 1 to %d", maxPageSize)})
+			return
+		}
+	}
+
+	results := []searchResult{}
+	if pidRegex.MatchString(searchValue) {
+		if item, err := store.Get(searchValue); err == nil && item.DeletedAt == nil {
+			results = append(results, searchResult{Item: item, Score: 1, Match: "pid"})
+		}
+	} else {
+		results = searchNames(_liveInventory(), searchValue, limit)
+	}
+	if len(results) == 0 {
+		_writeError(w, "getItem", http.StatusNotFound, codeNotFound, "Could not find anything in inventory like: "+searchValue)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(results)
+}
+
+// _findItem returns the item whose PID or name matches searchValue
+func _findItem(searchValue string, includeDeleted bool) (Item, bool) {
+	// if our product ID format is matched, we have a PID, otherwise a name
+	if pidRegex.MatchString(searchValue) {
+		item, err := store.Get(searchValue)
+		return item, err == nil && (item.DeletedAt == nil || includeDeleted)
+	}
+	// names are case-insensitive, the store's name index takes care of that
+	if item, err := store.FindByName(searchValue); err == nil {
+		return item, true
+	}
+	if includeDeleted {
+		// the name index only has the items that aren't deleted
+		for _, item := range store.List() {
+			if item.DeletedAt != nil && foldKey(item.Name) == foldKey(searchValue) {
+				return item, true
+			}
+		}
+	}
+	return Item{}, false
+}
+
+// If a single item object is not submitted a 400 is returned, listing every field that is wrong
 // the 16 digit product id is received in the request to create a new item
 func addItem(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Println("Function Called: addItem()")
 
 	var addItemReq Item
-	err := json.NewDecoder(r.Body).Decode(&addItemReq)
-	if err != nil || _checkAddItem(addItemReq) {
+	if err := json.NewDecoder(r.Body).Decode(&addItemReq); err != nil {
 		// the client didn't format the JSON properly - should be a single object of Item type
-		w.WriteHeader(http.StatusBadRequest) // return 400 Bad Request
-		w.Write([]byte(`Could not parse the format of items received. 
-			Please provide a JSON object with 'price', 'name' and 'pid'.`))
+		_writeError(w, "addItem", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the item received. Please provide a JSON object with 'price', 'name' and 'pid'.",
+			_decodeProblem(err))
+		return
+	}
+	if problems := _checkAddItem(&addItemReq); len(problems) > 0 {
+		_writeError(w, "addItem", http.StatusBadRequest, codeValidationFailed, "The item has invalid fields.", problems...)
 		return
 	}
 
-	// truncate the float64 provided to two decimals to ensure prices don't have more than necessary
-	addItemReq.Price, err = strconv.ParseFloat(fmt.Sprintf("%.2f", addItemReq.Price), 64)
 	// now we know its safe to add the items to inventory because they have been validated for format
-	inventory = append(inventory, addItemReq)
+	if err := _auditedStore(w, r).Add(addItemReq); err != nil {
+		_writeStoreError(w, "addItem", err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK) //return 200 OK
-	json.NewEncoder(w).Encode(inventory)
+	json.NewEncoder(w).Encode(_liveInventory())
 }
 
-func _checkAddItem(item Item) bool {
-	if item.Price == 0.00 || item.Name == "" || item.PID == "" {
-		return true
-	} else {
-		regex := regexp.MustCompile("^[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}$")
-		isValidPID := regex.MatchString(item.PID)
-		if !isValidPID {
-			return true
-		} else {
-			for _, oldItem := range inventory {
-				//strings.ToUpper to ensure our PIDs and Names are case-insensitive
-				if strings.ToUpper(oldItem.PID) == strings.ToUpper(item.PID) {
-					log.Printf("adding PID that already exists -- %v", item.PID)
-					return true
-				}
-			}
+// _checkAddItem normalizes the item's tags and lists everything that keeps it from being added
+func _checkAddItem(item *Item) []fieldError {
+	problems := _checkItemFormat(item)
+	if item.PID != "" {
+		if existing, err := store.Get(item.PID); err == nil && existing.DeletedAt != nil {
+			problems = append(problems, fieldError{Field: "pid", Message: "belongs to a deleted item, restore it or wait until it is purged"})
+		} else if err == nil {
+			problems = append(problems, fieldError{Field: "pid", Message: "already exists in the inventory"})
 		}
 	}
-	return false
+	if item.DeletedAt != nil {
+		problems = append(problems, fieldError{Field: "deletedAt", Message: "can't be set on a new item"})
+	}
+	return problems
+}
+
+// _checkItemFormat normalizes the item's tags and lists every property that is missing or malformed
+// updates call it on its own, the item being updated obviously already has its own PID
+func _checkItemFormat(item *Item) []fieldError {
+	var problems []fieldError
+	if item.PID == "" {
+		problems = append(problems, fieldError{Field: "pid", Message: "is required"})
+	} else if !pidRegex.MatchString(item.PID) {
+		problems = append(problems, fieldError{Field: "pid", Message: "has to be four groups of four letters or numbers, e.g. A12T-4GH7-QPL9-3N4M"})
+	}
+	if item.Name == "" {
+		problems = append(problems, fieldError{Field: "name", Message: "is required"})
+	}
+	if item.Price == 0 {
+		problems = append(problems, fieldError{Field: "price", Message: "is required"})
+	} else if item.Price < 0 {
+		problems = append(problems, fieldError{Field: "price", Message: "has to be positive"})
+	}
+	if item.Quantity < 0 {
+		problems = append(problems, fieldError{Field: "quantity", Message: "can't be negative"})
+	}
+	if item.ReorderPoint < 0 {
+		problems = append(problems, fieldError{Field: "reorderPoint", Message: "can't be negative"})
+	}
+	if item.ReorderQuantity < 0 {
+		problems = append(problems, fieldError{Field: "reorderQuantity", Message: "can't be negative"})
+	}
+	tags, err := normalizeTags(item.Tags)
+	if err != nil {
+		problems = append(problems, fieldError{Field: "tags", Message: err.Error()})
+	}
+	item.Tags = tags
+	return problems
 }
 
 // If an array is not submitted a 400 is returned
+// by default (?mode=atomic) when any of the items is bad nothing is added, and the details say which
+// items (by index) and which fields. With ?mode=partial the good items are added anyway, see batch.go
 // the 16 digit product id is received in the request to create a new item
 func addItems(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Println("Function Called: addItems()")
 
-	var createItemsReq []Item
-	err := json.NewDecoder(r.Body).Decode(&createItemsReq)
-	if err != nil || _checkAddItems(createItemsReq) {
-		// the client didn't format the JSON properly - should be a single object of Item type
-		w.WriteHeader(http.StatusBadRequest) // return 400 Bad Request
-		w.Write([]byte(`Could not parse the format of items received. 
-			Please provide a JSON object with 'price', 'name' and 'pid'.`))
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "atomic" && mode != "partial" {
+		_writeError(w, "addItems", http.StatusBadRequest, codeInvalidParameter, "mode has to be atomic or partial.",
+			fieldError{Field: "mode", Message: "has to be atomic or partial"})
 		return
 	}
 
-	for i, item := range createItemsReq {
-		// truncate the float64 provided to two decimals to ensure prices don't have more than necessary
-		createItemsReq[i].Price, _ = strconv.ParseFloat(fmt.Sprintf("%.2f", item.Price), 64)
+	// each item is decoded on its own so a malformed one can be pointed out by its index
+	var rawItems []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&rawItems); err != nil {
+		_writeError(w, "addItems", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the items received. Please provide a JSON array of objects with 'price', 'name' and 'pid'.",
+			_decodeProblem(err))
+		return
+	}
+	createItemsReq, itemProblems := _checkAddItems(rawItems)
+	if mode == "partial" {
+		_addItemsPartially(w, _auditedStore(w, r), createItemsReq, itemProblems)
+		return
+	}
+
+	var problems []fieldError
+	for i := range itemProblems {
+		problems = append(problems, atIndex(i, itemProblems[i])...)
+	}
+	if len(problems) > 0 {
+		_writeError(w, "addItems", http.StatusBadRequest, codeValidationFailed, "Some of the items are invalid, none were added.", problems...)
+		return
 	}
 
 	// now we know its safe to add the items to inventory because they have been validated for format
-	inventory = append(inventory, createItemsReq...)
+	if err := _auditedStore(w, r).AddBatch(createItemsReq); err != nil {
+		_writeStoreError(w, "addItems", err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK) //return 200 OK
-	json.NewEncoder(w).Encode(inventory)
-}
-
-func _checkAddItems(items []Item) bool {
-	for _, item := range items {
-		if _checkAddItem(item) {
-			return true
-		}
-	}
-	return false
+	json.NewEncoder(w).Encode(_liveInventory())
 }
 
 // deleting items occurs only one at a time
+// the item is only marked as discontinued and hidden, POST /inventory/{pid}/restore brings it back
+// and POST /inventory/purge removes it for good once the retention period is over (see deleted.go)
+// with If-Match it is only deleted if it is still the version the client read (see etag.go)
 func deleteItem(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Println("Function Called: deleteItem()")
@@ -179,70 +351,179 @@ func deleteItem(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	pid := params["pid"]
 
-	// if _deleteItemAt returns true, we found the item and deleted it, else 404
-	if success := _deleteItemAt(pid); success {
-		w.WriteHeader(http.StatusOK) //return 200 OK
-		json.NewEncoder(w).Encode(inventory)
-	} else {
+	// an item that is already deleted can't be found to be deleted again
+	_, err := _auditedStore(w, r).Modify(pid, _liveOnly(_ifMatch(r, func(item *Item) error {
+		now := time.Now().UTC()
+		item.DeletedAt = &now
+		return nil
+	})))
+	if errors.Is(err, ErrItemNotFound) {
 		// item not found - return a response accordingly
-		log.Println("404 error - deleteItem(): Cannot find PID: ", pid)
-		w.WriteHeader(http.StatusNotFound) // return 404 Not Found
-		w.Write([]byte("Could not find item in inventory: " + pid))
+		_writeError(w, "deleteItem", http.StatusNotFound, codeNotFound, "Could not find item in inventory: "+pid)
+		return
+	} else if err != nil {
+		_writeStoreError(w, "deleteItem", err)
 		return
 	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(_liveInventory())
 }
 
-// return true/false so we can report a 404 Not Found from within the calling function
-func _deleteItemAt(pid string) bool {
-	for index, item := range inventory {
-		//strings.ToUpper to ensure our PIDs are case-insensitive
-		if strings.ToUpper(item.PID) == strings.ToUpper(pid) {
-			// Delete item from slice
-			inventory = append(inventory[:index], inventory[index+1:]...)
-			return true
-		}
-	}
-	return false
-}
-
-func handleRequests() {
-
+// newRouter wires every endpoint to its handler
+func newRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
+	router.Use(withRequestID)
+	// middleware only runs on matched routes, so these two get wrapped themselves
+	router.NotFoundHandler = withRequestID(http.HandlerFunc(routeNotFound))
+	router.MethodNotAllowedHandler = withRequestID(http.HandlerFunc(methodNotAllowed))
 
-	router.HandleFunc("/inventory", getInventory).Methods("GET")
-	router.HandleFunc("/inventory/addItems", addItems).Methods("POST")
-	router.HandleFunc("/inventory/addItem", addItem).Methods("POST")
+	router.HandleFunc("/inventory", getInventory).Methods("GET").Name("getInventory")
+	// a retried add with the same Idempotency-Key gets the first response again (see idempotency.go)
+	router.HandleFunc("/inventory/addItems", withIdempotency(addItems)).Methods("POST").Name("addItems")
+	router.HandleFunc("/inventory/addItem", withIdempotency(addItem)).Methods("POST").Name("addItem")
+	router.HandleFunc("/inventory/purge", purgeItems).Methods("POST").Name("purgeItems")
+	router.HandleFunc("/inventory/low-stock", getLowStock).Methods("GET").Name("getLowStock")
+	router.HandleFunc("/inventory/{pid}/receive", receiveStock).Methods("POST").Name("receiveStock")
+	router.HandleFunc("/inventory/{pid}/sell", sellStock).Methods("POST").Name("sellStock")
+	router.HandleFunc("/inventory/{pid}/adjust", adjustStock).Methods("POST").Name("adjustStock")
+	router.HandleFunc("/inventory/{pid}/restore", restoreItem).Methods("POST").Name("restoreItem")
+	router.HandleFunc("/inventory/{pid}/history", getItemHistory).Methods("GET").Name("getItemHistory")
+	router.HandleFunc("/inventory/{pid}/tags", addTags).Methods("POST").Name("addTags")
+	router.HandleFunc("/inventory/{pid}/tags/{tag}", removeTag).Methods("DELETE").Name("removeTag")
 
 	//searchValue could be a name, or it could be a product ID
-	router.HandleFunc("/inventory/{searchValue}", getItem).Methods("GET")
-	router.HandleFunc("/inventory/{pid}", deleteItem).Methods("DELETE")
+	router.HandleFunc("/inventory/{searchValue}", getItem).Methods("GET").Name("getItem")
+	router.HandleFunc("/inventory/{pid}", replaceItem).Methods("PUT").Name("replaceItem")
+	router.HandleFunc("/inventory/{pid}", patchItem).Methods("PATCH").Name("patchItem")
+	router.HandleFunc("/inventory/{pid}", deleteItem).Methods("DELETE").Name("deleteItem")
+
+	router.HandleFunc("/transactions", createTransaction).Methods("POST").Name("createTransaction")
+	router.HandleFunc("/transactions", getTransactions).Methods("GET").Name("getTransactions")
+	router.HandleFunc("/transactions/{id}", getTransaction).Methods("GET").Name("getTransaction")
+	router.HandleFunc("/reports/profit", getProfitReport).Methods("GET").Name("getProfitReport")
+	router.HandleFunc("/search", searchText).Methods("GET").Name("searchText")
+
+	router.HandleFunc("/suppliers", createSupplier).Methods("POST").Name("createSupplier")
+	router.HandleFunc("/suppliers", getSuppliers).Methods("GET").Name("getSuppliers")
+	router.HandleFunc("/suppliers/{id}", getSupplier).Methods("GET").Name("getSupplier")
+	router.HandleFunc("/purchase-orders", createPurchaseOrder).Methods("POST").Name("createPurchaseOrder")
+	router.HandleFunc("/purchase-orders", getPurchaseOrders).Methods("GET").Name("getPurchaseOrders")
+	router.HandleFunc("/purchase-orders/{id}", getPurchaseOrder).Methods("GET").Name("getPurchaseOrder")
+	router.HandleFunc("/purchase-orders/{id}", replacePurchaseOrder).Methods("PUT").Name("replacePurchaseOrder")
+	router.HandleFunc("/purchase-orders/{id}/submit", submitPurchaseOrder).Methods("POST").Name("submitPurchaseOrder")
+	router.HandleFunc("/purchase-orders/{id}/cancel", cancelPurchaseOrder).Methods("POST").Name("cancelPurchaseOrder")
+	// a retried receive would take the shipment in twice, see idempotency.go
+	router.HandleFunc("/purchase-orders/{id}/receive", withIdempotency(receivePurchaseOrder)).Methods("POST").Name("receivePurchaseOrder")
+
+	router.HandleFunc("/admin/keys", createKey).Methods("POST").Name("createKey")
+	router.HandleFunc("/admin/keys", getKeys).Methods("GET").Name("getKeys")
+	router.HandleFunc("/admin/keys/{id}", revokeKey).Methods("DELETE").Name("revokeKey")
+	router.HandleFunc("/auth/tokens", issueToken).Methods("POST").Name("issueToken")
+	router.HandleFunc("/audit", getAudit).Methods("GET").Name("getAudit")
+	router.HandleFunc("/alerts", getAlerts).Methods("GET").Name("getAlerts")
+	router.HandleFunc("/alerts/{id}", dismissAlert).Methods("DELETE").Name("dismissAlert")
+
+	router.HandleFunc("/webhooks", createWebhook).Methods("POST").Name("createWebhook")
+	router.HandleFunc("/webhooks", getWebhooks).Methods("GET").Name("getWebhooks")
+	router.HandleFunc("/webhooks/dead-letters", getDeadLetters).Methods("GET").Name("getDeadLetters")
+	router.HandleFunc("/webhooks/dead-letters/{id}/redeliver", redeliverWebhook).Methods("POST").Name("redeliverWebhook")
+	router.HandleFunc("/webhooks/{id}", deleteWebhook).Methods("DELETE").Name("deleteWebhook")
+	router.HandleFunc("/webhooks/{id}/deliveries", getWebhookDeliveries).Methods("GET").Name("getWebhookDeliveries")
+	return router
+}
+
+// every route is named after its handler, requirePermission looks the names up in routePermissions (auth.go)
+// auth is nil when the API runs with -auth=false, then every route is open to anyone
+func handleRequests(auth authenticator) {
+	router := newRouter()
+	if auth != nil {
+		router.Use(requirePermission(auth))
+	} else {
+		log.Println("WARNING: -auth=false, every route is open to anyone")
+	}
 	log.Println("Running on localhost:8000")
 	log.Fatal(http.ListenAndServe(":8000", router))
 }
 
 func main() {
-	initialInventory := []Item{
-		{
-			PID:   "A12T-4GH7-QPL9-3N4M",
-			Name:  "Lettuce",
-			Price: 3.46,
-		},
-		{
-			PID:   "E5T6-9UI3-TH15-QR88",
-			Name:  "Peach",
-			Price: 2.99,
-		},
-		{
-			PID:   "YRT6-72AS-K736-L4AR",
-			Name:  "Green Pepper",
-			Price: 0.79,
-		},
-		{
-			PID:   "TQ4C-VV6T-75ZX-1RMR",
-			Name:  "Gala Apple",
-			Price: 3.59,
-		},
+	storeType := flag.String("store", "memory", "where the inventory is kept: memory, file or wal")
+	dataPath := flag.String("data", "", "inventory file for -store=file (default inventory.json) "+
+		"or data directory for -store=wal (default inventory-data)")
+	compactEvery := flag.Int("compact-every", 1000, "number of wal records written before they are compacted into a snapshot")
+	rounding := flag.String("rounding", "half-even", "how prices with more than two decimals are rounded: half-even or half-up")
+	keysPath := flag.String("keys", "keys.json", "file the API keys are kept in, only hashes of the keys are written to it")
+	tokenSecret := flag.String("token-secret", "", "file holding the secret (32 bytes or more) to sign tokens with HMAC")
+	tokenKey := flag.String("token-key", "", "PEM file holding the Ed25519 private key to sign tokens with")
+	tokenTTL := flag.Duration("token-max-ttl", time.Hour, "the longest a signed token may last")
+	flag.DurationVar(&retention, "retention", retention, "how long a deleted item is kept before POST /inventory/purge removes it for good")
+	flag.DurationVar(&idempotency.window, "idempotency-window", idempotency.window, "how long the response to an Idempotency-Key is replayed to requests that repeat it")
+	alertSinks := flag.String("alerts", "log,queue", "where low-stock alerts go, any of log, queue (GET /alerts) and webhook")
+	alertWebhook := flag.String("alert-webhook", "", "URL every low-stock alert is POSTed to with -alerts=webhook")
+	flag.IntVar(&webhooks.maxAttempts, "webhook-attempts", webhooks.maxAttempts, "how many times a webhook delivery is tried before it is dead-lettered")
+	flag.DurationVar(&webhooks.backoff, "webhook-backoff", webhooks.backoff, "how long to wait before retrying a failed webhook delivery, doubled after every failure")
+	flag.IntVar(&webhooks.logLimit, "webhook-log", webhooks.logLimit, "how many webhook deliveries the delivery log keeps, dead letters are kept until they are redelivered")
+	auditPath := flag.String("audit", "", "file the audit trail is appended to, without it the trail only lives in memory")
+	authOn := flag.Bool("auth", true, "check every request's API key or token and role, -auth=false leaves every route open (development only)")
+	flag.Parse()
+
+	var err error
+	if moneyRounding, err = parseRoundingMode(*rounding); err != nil {
+		log.Fatal(err)
+	}
+
+	switch *storeType {
+	case "memory":
+		// store already starts out holding the default inventory
+	case "file":
+		if *dataPath == "" {
+			*dataPath = "inventory.json"
+		}
+		fileStore, err := openFileStore(*dataPath, defaultInventory())
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = fileStore
+	case "wal":
+		if *dataPath == "" {
+			*dataPath = "inventory-data"
+		}
+		walStore, err := openWALStore(*dataPath, defaultInventory(), *compactEvery)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = walStore
+	default:
+		log.Fatalf("unknown -store value %q, expected memory, file or wal", *storeType)
+	}
+	ledger = loadTransactionLedger(store.Transactions())
+	sinks, err := parseAlertSinks(*alertSinks, *alertWebhook)
+	if err != nil {
+		log.Fatal(err)
+	}
+	alerts = newAlerter(sinks...)
+	if *auditPath != "" {
+		if audit, err = openAuditLog(*auditPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var auth authenticator
+	if *authOn {
+		if keys, err = openKeyStore(*keysPath); err != nil {
+			log.Fatal(err)
+		}
+		if tokens, err = loadTokenSigner(*tokenSecret, *tokenKey, *tokenTTL); err != nil {
+			log.Fatal(err)
+		}
+		// nobody could hand out keys without an admin, so the first start makes one
+		if !keys.hasActive(RoleAdmin) {
+			_, secret, err := keys.create("admin", RoleAdmin, nil, "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("created an admin API key, it won't be shown again: %v", secret)
+		}
+		auth = bearerAuth{}
 	}
-	inventory = append(inventory, initialInventory...)
-	handleRequests()
+	handleRequests(auth)
 }
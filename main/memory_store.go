@@ -0,0 +1,436 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is the original slice based inventory, nothing survives a restart.
+// net/http runs every request on its own goroutine, so reads share the lock and
+// anything that changes the inventory holds it exclusively.
+//
+// Items sit in slots in inventory order. Deleting an item only empties its slot so nothing
+// has to shift, and once more than half the slots are empty they are squeezed out in one go.
+// PIDs, names and tags are all looked up through hash indexes keyed by foldKey, so no lookup
+// walks the slots and no comparison has to case-fold both sides. The words of every item are
+// in a full-text index as well (fulltext.go). Deleted items keep their PID but are in no other index.
+//
+// Every version of every item is kept in history, in the order they were made (see history.go)
+type memoryStore struct {
+	mu    sync.RWMutex
+	slots []*Item
+	live  int
+	// pids maps every folded PID to its slot
+	pids map[string]int
+	// names maps every folded name to the folded PIDs of the items with that name
+	names map[string]map[string]bool
+	// tags maps every tag to the folded PIDs of the items that have it
+	tags map[string]map[string]bool
+	text *textIndex
+	// history has every revision, oldest first, and versions maps every folded PID to its revisions in it
+	history  []Revision
+	versions map[string][]int
+}
+
+// newMemoryStore starts a store holding items, each with a first revision made right now.
+// An item without a version, from before versions were kept, becomes version 1
+func newMemoryStore(items []Item) *memoryStore {
+	s := &memoryStore{}
+	versioned := make([]Item, len(items))
+	for i, item := range items {
+		if item.Version == 0 {
+			item.Version = 1
+		}
+		versioned[i] = item
+	}
+	s.load(versioned)
+	s.versions = map[string][]int{}
+	now := time.Now().UTC()
+	for _, item := range versioned {
+		s.revise(item.PID, &item, now)
+	}
+	return s
+}
+
+// load throws away the inventory and every index and starts over with items, the caller holds the lock
+func (s *memoryStore) load(items []Item) {
+	s.slots = make([]*Item, 0, len(items))
+	s.live = 0
+	s.pids = make(map[string]int, len(items))
+	s.names = make(map[string]map[string]bool, len(items))
+	s.tags = map[string]map[string]bool{}
+	s.text = newTextIndex()
+	for _, item := range items {
+		s.insert(item)
+	}
+}
+
+// insert puts item in a new slot at the end of the inventory, the caller holds the lock
+func (s *memoryStore) insert(item Item) {
+	s.pids[foldKey(item.PID)] = len(s.slots)
+	s.slots = append(s.slots, &item)
+	s.live++
+	s.index(item)
+}
+
+// index adds item to the name, tag and full-text indexes, unindex takes it back out.
+// A deleted item is left out of them, nobody should find it by looking for it
+func (s *memoryStore) index(item Item) {
+	if item.DeletedAt != nil {
+		return
+	}
+	key := foldKey(item.PID)
+	addToSet(s.names, foldKey(item.Name), key)
+	for _, tag := range item.Tags {
+		addToSet(s.tags, tag, key)
+	}
+	s.text.add(key, item)
+}
+
+func (s *memoryStore) unindex(item Item) {
+	if item.DeletedAt != nil {
+		return
+	}
+	key := foldKey(item.PID)
+	removeFromSet(s.names, foldKey(item.Name), key)
+	for _, tag := range item.Tags {
+		removeFromSet(s.tags, tag, key)
+	}
+	s.text.remove(key, item)
+}
+
+func addToSet(index map[string]map[string]bool, key string, member string) {
+	if index[key] == nil {
+		index[key] = map[string]bool{}
+	}
+	index[key][member] = true
+}
+
+// removeFromSet drops empty sets so the index doesn't fill up with keys nothing has anymore
+func removeFromSet(index map[string]map[string]bool, key string, member string) {
+	delete(index[key], member)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// indexOf returns the slot of the item with the given PID, or -1
+func (s *memoryStore) indexOf(pid string) int {
+	if i, found := s.pids[foldKey(pid)]; found {
+		return i
+	}
+	return -1
+}
+
+// itemsAt returns copies of the items with the given folded PIDs, in inventory order
+func (s *memoryStore) itemsAt(pids map[string]bool) []Item {
+	positions := make([]int, 0, len(pids))
+	for key := range pids {
+		positions = append(positions, s.pids[key])
+	}
+	sort.Ints(positions)
+	items := make([]Item, len(positions))
+	for i, position := range positions {
+		items[i] = *s.slots[position]
+	}
+	return items
+}
+
+func (s *memoryStore) Get(pid string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.indexOf(pid); i >= 0 {
+		return *s.slots[i], nil
+	}
+	return Item{}, ErrItemNotFound
+}
+
+// List hands out a copy so callers can't alter the inventory behind our back
+func (s *memoryStore) List() []Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	items := make([]Item, 0, s.live)
+	for _, item := range s.slots {
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+func (s *memoryStore) FindByName(name string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	first := -1
+	for key := range s.names[foldKey(name)] {
+		if i := s.pids[key]; first < 0 || i < first {
+			first = i
+		}
+	}
+	if first < 0 {
+		return Item{}, ErrItemNotFound
+	}
+	return *s.slots[first], nil
+}
+
+func (s *memoryStore) ListByTags(tags []string, matchAll bool) []Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := map[string]bool{}
+	if matchAll && len(tags) > 0 {
+		// start from the smallest set of PIDs, anything else can only shrink it
+		smallest := s.tags[tags[0]]
+		for _, tag := range tags[1:] {
+			if len(s.tags[tag]) < len(smallest) {
+				smallest = s.tags[tag]
+			}
+		}
+		for key := range smallest {
+			matches[key] = true
+			for _, tag := range tags {
+				if !s.tags[tag][key] {
+					delete(matches, key)
+					break
+				}
+			}
+		}
+	} else {
+		for _, tag := range tags {
+			for key := range s.tags[tag] {
+				matches[key] = true
+			}
+		}
+	}
+	return s.itemsAt(matches)
+}
+
+func (s *memoryStore) SearchText(query string, limit int) []textResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	scores := s.text.search(query)
+	results := make([]textResult, 0, len(scores))
+	for key, score := range scores {
+		results = append(results, textResult{Item: *s.slots[s.pids[key]], Score: score})
+	}
+	return rankTextResults(results, limit)
+}
+
+func (s *memoryStore) Add(item Item) error {
+	return s.AddBatch([]Item{item})
+}
+
+func (s *memoryStore) AddBatch(items []Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// check the whole batch before touching the slots so a bad batch changes nothing
+	if err := s.validateAdd(items); err != nil {
+		return err
+	}
+	s.stampAdd(items)
+	s.addLocked(items, time.Now().UTC())
+	return nil
+}
+
+// addAt is AddBatch for items that already have their versions, made at the given time.
+// walStore replays its records through it so they keep the versions and times they were logged with
+func (s *memoryStore) addAt(items []Item, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.validateAdd(items); err != nil {
+		return err
+	}
+	s.addLocked(items, at)
+	return nil
+}
+
+// stampAdd gives every item the version after the last one its PID had, 1 for a PID never seen before.
+// The caller holds the lock (or is the only writer, like walStore)
+func (s *memoryStore) stampAdd(items []Item) {
+	for i := range items {
+		items[i].Version = s.nextVersion(items[i].PID)
+	}
+}
+
+// addLocked expects the caller to hold the lock and the items to have been validated
+func (s *memoryStore) addLocked(items []Item, at time.Time) {
+	for _, item := range items {
+		s.insert(item)
+		s.revise(item.PID, &item, at)
+	}
+}
+
+// checkAdd reports whether AddBatch would accept items, without adding them
+func (s *memoryStore) checkAdd(items []Item) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.validateAdd(items)
+}
+
+// validateAdd expects the caller to hold the lock
+func (s *memoryStore) validateAdd(items []Item) error {
+	batch := make(map[string]bool, len(items))
+	for _, item := range items {
+		key := foldKey(item.PID)
+		if _, found := s.pids[key]; found || batch[key] {
+			return ErrDuplicatePID
+		}
+		batch[key] = true
+	}
+	return nil
+}
+
+func (s *memoryStore) Update(item Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexOf(item.PID) < 0 {
+		return ErrItemNotFound
+	}
+	item.Version = s.nextVersion(item.PID)
+	s.updateLocked([]Item{item}, time.Now().UTC())
+	return nil
+}
+
+func (s *memoryStore) Delete(pid string) (Item, error) {
+	return s.DeleteIf(pid, nil)
+}
+
+func (s *memoryStore) DeleteIf(pid string, check func(item Item) error) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteLocked(pid, check, time.Now().UTC())
+}
+
+// deleteAt is Delete made at the given time, for walStore's replay
+func (s *memoryStore) deleteAt(pid string, at time.Time) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteLocked(pid, nil, at)
+}
+
+// deleteLocked expects the caller to hold the lock
+func (s *memoryStore) deleteLocked(pid string, check func(item Item) error, at time.Time) (Item, error) {
+	i := s.indexOf(pid)
+	if i < 0 {
+		return Item{}, ErrItemNotFound
+	}
+	deleted := *s.slots[i]
+	if check != nil {
+		if err := check(deleted); err != nil {
+			return Item{}, err
+		}
+	}
+	s.unindex(deleted)
+	// the purge is a revision too, one without an item, so the history shows when the item stopped being there
+	s.revise(deleted.PID, nil, at)
+	delete(s.pids, foldKey(pid))
+	s.slots[i] = nil
+	s.live--
+	if len(s.slots)-s.live > s.live {
+		s.compact()
+	}
+	return deleted, nil
+}
+
+// compact squeezes the empty slots out, the caller holds the lock
+func (s *memoryStore) compact() {
+	slots := make([]*Item, 0, s.live)
+	for _, item := range s.slots {
+		if item != nil {
+			s.pids[foldKey(item.PID)] = len(slots)
+			slots = append(slots, item)
+		}
+	}
+	s.slots = slots
+}
+
+func (s *memoryStore) Modify(pid string, change func(item *Item) error) (Item, error) {
+	return modifyOne(s, pid, change)
+}
+
+func (s *memoryStore) ModifyBatch(pids []string, change func(items []*Item) error) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	modified, err := s.prepareModify(pids, change)
+	if err != nil {
+		return nil, err
+	}
+	s.updateLocked(modified, time.Now().UTC())
+	return modified, nil
+}
+
+// RecordTransaction is just ModifyBatch, nothing the memory store holds outlives the ledger
+func (s *memoryStore) RecordTransaction(pids []string, tx *Transaction, change func(items []*Item) error) ([]Item, error) {
+	return s.ModifyBatch(pids, change)
+}
+
+func (s *memoryStore) Transactions() []Transaction {
+	return nil
+}
+
+// prepareModify runs change on copies of the items and returns what they should become, each
+// with its next version. Nothing is saved. The caller has to hold the lock (or be the only writer, like walStore)
+func (s *memoryStore) prepareModify(pids []string, change func(items []*Item) error) ([]Item, error) {
+	modified := make([]Item, len(pids))
+	pointers := make([]*Item, len(pids))
+	seen := make(map[string]bool, len(pids))
+	for i, pid := range pids {
+		index := s.indexOf(pid)
+		if index < 0 {
+			return nil, ErrItemNotFound
+		}
+		if seen[foldKey(pid)] {
+			return nil, ErrDuplicatePID
+		}
+		seen[foldKey(pid)] = true
+		modified[i] = *s.slots[index]
+		// change gets its own tags so it can't reach into the stored item's slice
+		modified[i].Tags = append([]string(nil), modified[i].Tags...)
+		pointers[i] = &modified[i]
+	}
+	if err := change(pointers); err != nil {
+		return nil, err
+	}
+	// change isn't allowed to move an item to another PID, or to pick its version
+	for i := range modified {
+		modified[i].PID = s.slots[s.indexOf(pids[i])].PID
+		modified[i].Version = s.nextVersion(pids[i])
+	}
+	return modified, nil
+}
+
+// updateAt replaces several items at once, made at the given time. The items already have their
+// versions, it is how walStore applies its records. Readers see all of the new items or none of them
+func (s *memoryStore) updateAt(items []Item, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		if s.indexOf(item.PID) < 0 {
+			return ErrItemNotFound
+		}
+	}
+	s.updateLocked(items, at)
+	return nil
+}
+
+// updateLocked expects the caller to hold the lock and every item to exist
+func (s *memoryStore) updateLocked(items []Item, at time.Time) {
+	for _, item := range items {
+		i := s.indexOf(item.PID)
+		s.unindex(*s.slots[i])
+		updated := item
+		s.slots[i] = &updated
+		s.index(updated)
+		s.revise(updated.PID, &updated, at)
+	}
+}
+
+// replace swaps in a whole new inventory and cuts the history back to its first revisions,
+// used to roll back a write that couldn't be saved
+func (s *memoryStore) replace(items []Item, revisions int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load(items)
+	s.loadHistory(s.history[:revisions])
+}
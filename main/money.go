@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Money is an amount in cents. Prices used to be float64 dollars, which can't hold most
+// two decimal amounts exactly (0.1 + 0.2 != 0.3), so sums of them drifted. Every
+// calculation on an amount goes through the methods below and stays in whole cents.
+//
+// In JSON, Money is still written as a plain number of dollars ("price": 3.46) so clients
+// didn't have to change. Input with more than two decimals is rounded to the cent using
+// moneyRounding, the digits are read as written and never go through a float64.
+type Money int64
+
+// RoundingMode picks what happens to an amount that falls exactly halfway between two cents,
+// anything that isn't a tie always goes to the nearest cent
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds ties to the even cent (3.345 -> 3.34, 3.355 -> 3.36), so over
+	// many amounts the rounding doesn't lean one way. This is the default
+	RoundHalfEven RoundingMode = iota
+	// RoundHalfUp rounds ties away from zero (3.345 -> 3.35), what most people do by hand
+	RoundHalfUp
+)
+
+// moneyRounding is how prices received from clients are rounded, set once in main() from -rounding
+var moneyRounding = RoundHalfEven
+
+var errMoneyOutOfRange = errors.New("amount is out of range")
+
+// parseRoundingMode turns the -rounding flag value into a RoundingMode
+func parseRoundingMode(mode string) (RoundingMode, error) {
+	switch mode {
+	case "half-even":
+		return RoundHalfEven, nil
+	case "half-up":
+		return RoundHalfUp, nil
+	}
+	return 0, fmt.Errorf("unknown rounding mode %q, expected half-even or half-up", mode)
+}
+
+// ParseMoney reads a decimal number of dollars ("3.46", "-0.5", "1e2") exactly and rounds it to the cent
+func ParseMoney(amount string, mode RoundingMode) (Money, error) {
+	dollars, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return 0, fmt.Errorf("%q is not an amount of money", amount)
+	}
+	return roundCents(new(big.Rat).Mul(dollars, big.NewRat(100, 1)), mode)
+}
+
+// roundCents rounds an exact number of cents to a whole cent
+func roundCents(cents *big.Rat, mode RoundingMode) (Money, error) {
+	quotient, remainder := new(big.Int).QuoRem(cents.Num(), cents.Denom(), new(big.Int))
+	// compare twice the remainder with the denominator to see which side of half a cent we are on
+	twice := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2))
+	switch twice.Cmp(cents.Denom()) {
+	case 1:
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+	case 0:
+		if mode == RoundHalfUp || quotient.Bit(0) == 1 {
+			quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+		}
+	}
+
+	if !quotient.IsInt64() {
+		return 0, errMoneyOutOfRange
+	}
+	return Money(quotient.Int64()), nil
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) Money {
+	return m + other
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) Money {
+	return m - other
+}
+
+// CheckedMul returns m times a quantity, e.g. a unit price times the number sold. Both come from
+// clients, so a product that doesn't fit in a Money is errMoneyOutOfRange instead of an amount that wrapped around
+func (m Money) CheckedMul(quantity int) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(quantity)))
+	if !product.IsInt64() {
+		return 0, errMoneyOutOfRange
+	}
+	return Money(product.Int64()), nil
+}
+
+// CheckedAdd is Add with the same check as CheckedMul, for totals of amounts that come from a client
+func (m Money) CheckedAdd(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, errMoneyOutOfRange
+	}
+	return sum, nil
+}
+
+// MulRatio returns m * numerator / denominator rounded to the cent with moneyRounding, e.g. the
+// cost of 3 units when 7 units cost m. The result is exact before that one rounding, denominator can't be 0
+func (m Money) MulRatio(numerator int64, denominator int64) Money {
+	ratio := new(big.Rat).SetFrac(big.NewInt(numerator), big.NewInt(denominator))
+	result, err := roundCents(ratio.Mul(ratio, new(big.Rat).SetInt64(int64(m))), moneyRounding)
+	if err != nil {
+		// the only error is overflow, which only an impossible amount of money can cause
+		panic(err)
+	}
+	return result
+}
+
+// String formats the amount as dollars with exactly two decimals, e.g. 3.46 or -0.05
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+	}
+	// take the absolute value in uint64 so the most negative amount doesn't overflow
+	abs := uint64(cents)
+	if cents < 0 {
+		abs = uint64(-(cents + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON only takes JSON numbers, the digits are parsed exactly and rounded with moneyRounding
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	// encoding/json has already checked data is valid JSON, so a number is anything starting like one
+	if text[0] != '-' && (text[0] < '0' || text[0] > '9') {
+		return fmt.Errorf("%v is not a number of dollars", text)
+	}
+	parsed, err := ParseMoney(text, moneyRounding)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		amount   string
+		mode     RoundingMode
+		expected Money
+	}{
+		{"3.46", RoundHalfEven, 346},
+		{"3", RoundHalfEven, 300},
+		{"0.5", RoundHalfEven, 50},
+		{"1e2", RoundHalfEven, 10000},
+		{"1.299", RoundHalfEven, 130},
+		{"6.493", RoundHalfEven, 649},
+		// ties go to the even cent with half-even, away from zero with half-up
+		{"3.345", RoundHalfEven, 334},
+		{"3.355", RoundHalfEven, 336},
+		{"3.345", RoundHalfUp, 335},
+		{"3.355", RoundHalfUp, 336},
+		{"-3.345", RoundHalfEven, -334},
+		{"-3.345", RoundHalfUp, -335},
+		// only an exact tie is a tie, a float64 would have turned this into 3.34499999...
+		{"3.3450000000000000001", RoundHalfEven, 335},
+	}
+	for _, c := range cases {
+		actual, err := ParseMoney(c.amount, c.mode)
+		if err != nil || actual != c.expected {
+			t.Errorf("ParseMoney(%v, %v) -- actual - %v, %v | expected - %v", c.amount, c.mode, actual, err, c.expected)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "1e30"} {
+		if _, err := ParseMoney(bad, RoundHalfEven); err == nil {
+			t.Errorf("ParseMoney(%q) -- expected an error", bad)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for _, m := range []Money{0, 5, 346, 10000, -5, -346} {
+		data, err := json.Marshal(m)
+		checkError(err, t)
+		var back Money
+		checkError(json.Unmarshal(data, &back), t)
+		if back != m {
+			t.Errorf("%v didn't survive JSON, came back as %v (%s)", int64(m), int64(back), data)
+		}
+	}
+
+	if data, _ := json.Marshal(Money(-5)); string(data) != "-0.05" {
+		t.Errorf("Money(-5) marshalled to %s, expected -0.05", data)
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`"3.46"`), &m); err == nil {
+		t.Errorf("a string price should be refused")
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	// the classic float64 problem, ten dimes are exactly a dollar
+	var total Money
+	for i := 0; i < 10; i++ {
+		total = total.Add(10)
+	}
+	if total != 100 {
+		t.Errorf("ten dimes -- actual - %v | expected - 1.00", total)
+	}
+	if change := Money(1000).Sub(897); change.String() != "1.03" {
+		t.Errorf("10.00 - 8.97 -- actual - %v | expected - 1.03", change)
+	}
+	// a total too big for a Money is an error instead of wrapping around to some other amount
+	if line, err := Money(100000).CheckedMul(100000000000000000); err == nil {
+		t.Errorf("1e17 x 1000.00 -- actual - %v | expected it to be out of range", line)
+	}
+	if line, err := Money(299).CheckedMul(3); err != nil || line != 897 {
+		t.Errorf("3 x 2.99 -- actual - %v %v | expected - 8.97", line, err)
+	}
+	if sum, err := Money(math.MaxInt64).CheckedAdd(1); err == nil {
+		t.Errorf("the largest amount + 0.01 -- actual - %v | expected it to be out of range", sum)
+	}
+	if sum, err := Money(math.MinInt64).CheckedAdd(-1); err == nil {
+		t.Errorf("the smallest amount - 0.01 -- actual - %v | expected it to be out of range", sum)
+	}
+	if sum, err := Money(1000).CheckedAdd(-897); err != nil || sum != 103 {
+		t.Errorf("checked 10.00 - 8.97 -- actual - %v %v | expected - 1.03", sum, err)
+	}
+	// 3 of 7 units that cost 10.00 together is 4.285714..., which rounds to 4.29
+	if share := Money(1000).MulRatio(3, 7); share != 429 {
+		t.Errorf("10.00 * 3 / 7 -- actual - %v | expected - 4.29", share)
+	}
+	// 1 of 8 units that cost 1.00 is exactly 12.5 cents, a tie that goes to the even cent
+	if share := Money(100).MulRatio(1, 8); share != 12 {
+		t.Errorf("1.00 * 1 / 8 -- actual - %v | expected - 0.12", share)
+	}
+}
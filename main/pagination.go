@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// a page is everything when no limit is asked for, a cursor alone gets defaultPageSize items
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// inventoryQuery is everything GET /inventory can be asked to do, parsed from the query string
+type inventoryQuery struct {
+	tags     []string
+	matchAll bool
+	minPrice *Money
+	maxPrice *Money
+	// sort is pid, name, price or -price. Empty keeps inventory order, which is only allowed
+	// when the results aren't paged, since positions shift as items are deleted
+	sort  string
+	limit int
+	after *pageCursor
+	// includeDeleted keeps the deleted items in, the handler checks the caller may see them
+	includeDeleted bool
+	// asOf is the time the inventory is shown as of, zero is now. The handler checks the caller may see the history
+	asOf time.Time
+}
+
+// pageCursor is the sort key of the last item of a page. The next page starts right after that
+// key, not at an offset, so items added or deleted in the meantime never make a page skip or
+// repeat an item that was there all along. The PID is the tie-breaker, it makes every key unique
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Name  string `json:"n,omitempty"`
+	Price Money  `json:"p,omitempty"`
+	PID   string `json:"id"`
+}
+
+// encode turns the cursor into the opaque string clients pass back as ?cursor=
+func (c pageCursor) encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeCursor(value string) (*pageCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	var cursor pageCursor
+	if err == nil {
+		err = json.Unmarshal(decoded, &cursor)
+	}
+	if err != nil || cursor.PID == "" {
+		return nil, fmt.Errorf("is not a cursor this API handed out")
+	}
+	return &cursor, nil
+}
+
+// cursorAt is the cursor that continues after item
+func cursorAt(item Item, sortBy string) pageCursor {
+	return pageCursor{Sort: sortBy, Name: foldKey(item.Name), Price: item.Price, PID: foldKey(item.PID)}
+}
+
+// before is true when a sorts ahead of b, both are cursors so items compare by their folded keys
+func (a pageCursor) before(b pageCursor) bool {
+	switch a.Sort {
+	case "name":
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case "price":
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+	case "-price":
+		if a.Price != b.Price {
+			return a.Price > b.Price
+		}
+	}
+	return a.PID < b.PID
+}
+
+// _parseInventoryQuery reads the filters, sort and page of GET /inventory, problems point at the bad parameter
+func _parseInventoryQuery(values url.Values) (inventoryQuery, []fieldError) {
+	var query inventoryQuery
+	var problems []fieldError
+	var err error
+
+	if query.tags, err = normalizeTags(values["tag"]); err != nil {
+		problems = append(problems, fieldError{Field: "tag", Message: err.Error()})
+	}
+	match := values.Get("match")
+	if match != "" && match != "all" && match != "any" {
+		problems = append(problems, fieldError{Field: "match", Message: "has to be all or any"})
+	}
+	query.matchAll = match != "any"
+	var valid bool
+	if query.includeDeleted, valid = _parseIncludeDeleted(values.Get("includeDeleted")); !valid {
+		problems = append(problems, fieldError{Field: "includeDeleted", Message: "has to be true or false"})
+	}
+	// a date is the inventory as it was at the end of that day
+	if query.asOf, err = _parseReportTime(values.Get("asOf"), true, time.UTC); err != nil {
+		problems = append(problems, fieldError{Field: "asOf", Message: "has to be an RFC 3339 time or a YYYY-MM-DD date"})
+	}
+
+	bounds := []struct {
+		field string
+		price **Money
+	}{{"minPrice", &query.minPrice}, {"maxPrice", &query.maxPrice}}
+	for _, bound := range bounds {
+		if value := values.Get(bound.field); value != "" {
+			price, err := ParseMoney(value, moneyRounding)
+			if err != nil {
+				problems = append(problems, fieldError{Field: bound.field, Message: "has to be an amount of dollars, e.g. 2.50"})
+				continue
+			}
+			*bound.price = &price
+		}
+	}
+	if query.minPrice != nil && query.maxPrice != nil && *query.minPrice > *query.maxPrice {
+		problems = append(problems, fieldError{Field: "maxPrice", Message: "can't be less than minPrice"})
+	}
+
+	query.sort = values.Get("sort")
+	switch query.sort {
+	case "", "pid", "name", "price", "-price":
+	default:
+		problems = append(problems, fieldError{Field: "sort", Message: "has to be pid, name, price or -price"})
+	}
+
+	if value := values.Get("limit"); value != "" {
+		if query.limit, err = strconv.Atoi(value); err != nil || query.limit < 1 || query.limit > maxPageSize {
+			problems = append(problems, fieldError{Field: "limit", Message: fmt.Sprintf("has to be a number from 1 to %d", maxPageSize)})
+		}
+	}
+	if value := values.Get("cursor"); value != "" {
+		if query.after, err = decodeCursor(value); err != nil {
+			problems = append(problems, fieldError{Field: "cursor", Message: err.Error()})
+		} else if query.limit == 0 {
+			query.limit = defaultPageSize
+		}
+	}
+
+	// pages need an order that doesn't shift, the PID is always there to fall back on
+	if query.limit > 0 && query.sort == "" {
+		query.sort = "pid"
+	}
+	if query.after != nil && query.after.Sort != query.sort {
+		problems = append(problems, fieldError{Field: "cursor", Message: "was made for sort=" + query.after.Sort + ", keep the same sort for every page"})
+	}
+	return query, problems
+}
+
+// run returns the page of items the query asks for, the number of items that match the filters
+// across every page, and the cursor of the next page (nil on the last page)
+func (query inventoryQuery) run(s Store) ([]Item, int, *pageCursor) {
+	var items []Item
+	// the tag index only has the current items and leaves deleted ones out, without it the tags are checked one item at a time
+	indexed := len(query.tags) > 0 && !query.includeDeleted && query.asOf.IsZero()
+	switch {
+	case !query.asOf.IsZero():
+		items = s.ListAsOf(query.asOf)
+	case indexed:
+		items = s.ListByTags(query.tags, query.matchAll)
+	default:
+		items = s.List()
+	}
+
+	matches := items[:0]
+	for _, item := range items {
+		if item.DeletedAt != nil && !query.includeDeleted {
+			continue
+		}
+		if len(query.tags) > 0 && !indexed && !_hasTags(item, query.tags, query.matchAll) {
+			continue
+		}
+		if (query.minPrice == nil || item.Price >= *query.minPrice) && (query.maxPrice == nil || item.Price <= *query.maxPrice) {
+			matches = append(matches, item)
+		}
+	}
+	total := len(matches)
+	if query.sort == "" {
+		return matches, total, nil
+	}
+
+	// every item's key is folded once up front rather than on every comparison
+	keys := make([]pageCursor, len(matches))
+	order := make([]int, len(matches))
+	for i, item := range matches {
+		keys[i] = cursorAt(item, query.sort)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]].before(keys[order[j]]) })
+
+	start := 0
+	if query.after != nil {
+		start = sort.Search(len(order), func(i int) bool { return query.after.before(keys[order[i]]) })
+	}
+	end := len(order)
+	var next *pageCursor
+	if query.limit > 0 && end-start > query.limit {
+		end = start + query.limit
+		next = &keys[order[end-1]]
+	}
+	page := make([]Item, 0, end-start)
+	for _, i := range order[start:end] {
+		page = append(page, matches[i])
+	}
+	return page, total, next
+}
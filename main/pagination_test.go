@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// pageReq sends GET /inventory with the given query and returns the items and the response for its headers
+func pageReq(query string, t *testing.T) ([]Item, *httptest.ResponseRecorder) {
+	respRecorder := serveRoute(newRouter(), "GET", "/inventory"+query, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "pageReq "+query)
+
+	var items []Item
+	err := json.NewDecoder(respRecorder.Body).Decode(&items)
+	checkResponseError(err, respRecorder, "[]Item", t)
+	return items, respRecorder
+}
+
+// checkTotal logs an error when the X-Total-Count header isn't total
+func checkTotal(respRecorder *httptest.ResponseRecorder, total int, t *testing.T, checkpoint string) {
+	if actual := respRecorder.Header().Get("X-Total-Count"); actual != strconv.Itoa(total) {
+		t.Errorf("%v -- actual X-Total-Count - %v | expected - %v", checkpoint, actual, total)
+	}
+}
+
+func TestInventoryPages(t *testing.T) {
+	// 25 fruits, prices go down as the numbers go up and every fifth one costs the same
+	var fruits []Item
+	for i := 0; i < 25; i++ {
+		price := Money(1000 - i*10)
+		if i%5 == 0 {
+			price = 500
+		}
+		fruits = append(fruits, Item{PID: fmt.Sprintf("FRUT-0000-0000-%04d", i), Name: fmt.Sprintf("Fruit %02d", i), Price: price})
+	}
+	useStore(newMemoryStore(fruits), t)
+
+	// 1. walking every page by price gets each item exactly once, in order ====================================================
+	t.Log("1. page through by price, 10 at a time")
+	var walked []Item
+	query := "?sort=price&limit=10"
+	for pages := 0; query != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("1 -- too many pages, the cursor isn't moving")
+		}
+		items, respRecorder := pageReq(query, t)
+		checkTotal(respRecorder, 25, t, "1")
+		walked = append(walked, items...)
+		query = ""
+		if cursor := respRecorder.Header().Get("X-Next-Cursor"); cursor != "" {
+			query = "?sort=price&limit=10&cursor=" + url.QueryEscape(cursor)
+		}
+	}
+	if len(walked) != 25 {
+		t.Fatalf("1 -- actual items walked - %v | expected - 25", len(walked))
+	}
+	seen := map[string]bool{}
+	for i, item := range walked {
+		if seen[item.PID] {
+			t.Errorf("1 -- %v came back twice", item.PID)
+		}
+		seen[item.PID] = true
+		if i > 0 && (item.Price < walked[i-1].Price || (item.Price == walked[i-1].Price && item.PID < walked[i-1].PID)) {
+			t.Errorf("1 -- %v is out of order after %v", item, walked[i-1])
+		}
+	}
+
+	// 2. adding and deleting items between pages doesn't skip or repeat anything ===============================================
+	t.Log("2. change the inventory between pages sorted by name")
+	first, respRecorder := pageReq("?sort=name&limit=5", t)
+	next := respRecorder.Header().Get("X-Next-Cursor")
+	if link := respRecorder.Header().Get("Link"); link == "" {
+		t.Errorf("2 -- expected a Link header to the next page")
+	}
+	_, err := store.Delete(first[2].PID)
+	checkError(err, t)
+	_, err = store.Delete(first[4].PID)
+	checkError(err, t)
+	checkError(store.Add(Item{PID: "AAAA-0000-0000-0000", Name: "Apricot", Price: 100}), t)
+	second, _ := pageReq("?sort=name&limit=5&cursor="+url.QueryEscape(next), t)
+	if second[0].Name != "Fruit 05" || second[4].Name != "Fruit 09" {
+		t.Errorf("2 -- actual second page - %v | expected Fruit 05 to Fruit 09", second)
+	}
+
+	// 3. price filters and descending price =====================================================================================
+	t.Log("3. filter by price, most expensive first")
+	// fruits 02 and 04 were deleted above, 05 and 10 are only 5.00
+	items, respRecorder := pageReq("?minPrice=9.00&maxPrice=9.80&sort=-price", t)
+	checkTotal(respRecorder, 5, t, "3")
+	if len(items) != 5 || items[0].Price != 970 || items[4].Price != 910 {
+		t.Errorf("3 -- actual - %v | expected 5 items from 9.70 down to 9.10", items)
+	}
+	if respRecorder.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("3 -- a query without a limit shouldn't have a next page")
+	}
+
+	// 4. bad parameters ===========================================================================================================
+	t.Log("4. bad parameters")
+	for _, bad := range []string{"?limit=0", "?limit=5000", "?sort=color", "?minPrice=cheap", "?minPrice=5&maxPrice=1", "?cursor=nonsense",
+		"?sort=price&cursor=" + url.QueryEscape(next)} {
+		respRecorder := serveRoute(newRouter(), "GET", "/inventory"+bad, nil)
+		checkStatus(respRecorder.Code, http.StatusBadRequest, t, "4 "+bad)
+		decodeAPIError(respRecorder, codeInvalidParameter, t, "4 "+bad)
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// PurchaseOrderStatus is where an order is in its life:
+//
+//	draft -> submitted -> partially_received -> received
+//	  |          |               |
+//	  +----------+---------------+-> cancelled
+//
+// received and cancelled are final
+type PurchaseOrderStatus string
+
+const (
+	// the order is still being put together, its supplier and lines can be replaced
+	OrderDraft PurchaseOrderStatus = "draft"
+	// the order went out to the supplier, nothing has arrived yet
+	OrderSubmitted PurchaseOrderStatus = "submitted"
+	// some of the order arrived, the rest is still to come
+	OrderPartiallyReceived PurchaseOrderStatus = "partially_received"
+	// every line arrived in full
+	OrderReceived PurchaseOrderStatus = "received"
+	// we don't expect anything more, whatever was received before stays received
+	OrderCancelled PurchaseOrderStatus = "cancelled"
+)
+
+// PurchaseOrderLine is one item we order, UnitCost is what the supplier charges us per unit.
+// Name is captured from the inventory when the line is saved, Received counts up as shipments arrive
+type PurchaseOrderLine struct {
+	PID      string `json:"pid"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	UnitCost Money  `json:"unitCost"`
+	Total    Money  `json:"total"`
+	Received int    `json:"received"`
+}
+
+type PurchaseOrder struct {
+	ID         string              `json:"id"`
+	SupplierID string              `json:"supplierId"`
+	Status     PurchaseOrderStatus `json:"status"`
+	Lines      []PurchaseOrderLine `json:"lines"`
+	Total      Money               `json:"total"`
+	// Receipts are the IDs of the purchase transactions every shipment was recorded as, oldest first
+	Receipts  []string  `json:"receipts"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// receiptLine is how many units of one line of an order arrived
+type receiptLine struct {
+	PID      string `json:"pid"`
+	Quantity int    `json:"quantity"`
+}
+
+// receiptRequest is the body of POST /purchase-orders/{id}/receive, no lines means everything still outstanding arrived
+type receiptRequest struct {
+	Lines []receiptLine `json:"lines"`
+}
+
+var (
+	errOrderNotFound = errors.New("purchase order not found")
+	// errOrderStatus means the order's status doesn't allow what was asked, e.g. receiving a draft
+	errOrderStatus = errors.New("the purchase order's status doesn't allow that")
+	// errOrderNotYours means a supplier tried to receive another supplier's order
+	errOrderNotYours = errors.New("the purchase order is made out to another supplier")
+)
+
+// purchaseOrderBook is every purchase order, in the order they were created. An order still open when the
+// API stops is gone, the stock it already received stays in the inventory
+type purchaseOrderBook struct {
+	mu     sync.Mutex
+	orders []*PurchaseOrder
+	lastID int
+}
+
+// purchaseOrders is the book the /purchase-orders handlers write to
+var purchaseOrders = newPurchaseOrderBook()
+
+func newPurchaseOrderBook() *purchaseOrderBook {
+	return &purchaseOrderBook{}
+}
+
+// clone copies order so it can be handed out while the book keeps changing the original
+func (order PurchaseOrder) clone() PurchaseOrder {
+	order.Lines = append([]PurchaseOrderLine{}, order.Lines...)
+	order.Receipts = append([]string{}, order.Receipts...)
+	return order
+}
+
+// find returns the order with the given ID (case-insensitive), the caller holds the lock
+func (b *purchaseOrderBook) find(id string) (*PurchaseOrder, error) {
+	for _, order := range b.orders {
+		if strings.EqualFold(order.ID, id) {
+			return order, nil
+		}
+	}
+	return nil, errOrderNotFound
+}
+
+// create checks order against the suppliers and the inventory and saves it as a draft
+func (b *purchaseOrderBook) create(order PurchaseOrder) (PurchaseOrder, error) {
+	if err := _checkPurchaseOrder(&order); err != nil {
+		return PurchaseOrder{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	order.ID = fmt.Sprintf("PO-%06d", b.lastID)
+	order.Status = OrderDraft
+	order.Receipts = []string{}
+	order.CreatedAt = time.Now().UTC()
+	order.UpdatedAt = order.CreatedAt
+	b.orders = append(b.orders, &order)
+	return order.clone(), nil
+}
+
+func (b *purchaseOrderBook) get(id string) (PurchaseOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.find(id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	return order.clone(), nil
+}
+
+// list returns a copy of every order, only those with status and for supplierID when they aren't empty
+func (b *purchaseOrderBook) list(status PurchaseOrderStatus, supplierID string) []PurchaseOrder {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	orders := []PurchaseOrder{}
+	for _, order := range b.orders {
+		if (status == "" || order.Status == status) && (supplierID == "" || strings.EqualFold(order.SupplierID, supplierID)) {
+			orders = append(orders, order.clone())
+		}
+	}
+	return orders
+}
+
+// replace swaps the supplier and lines of a draft for those of update
+func (b *purchaseOrderBook) replace(id string, update PurchaseOrder) (PurchaseOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.find(id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if order.Status != OrderDraft {
+		return PurchaseOrder{}, fmt.Errorf("%w: %v is %v, only a draft can be changed", errOrderStatus, order.ID, order.Status)
+	}
+	if err := _checkPurchaseOrder(&update); err != nil {
+		return PurchaseOrder{}, err
+	}
+	order.SupplierID, order.Lines, order.Total = update.SupplierID, update.Lines, update.Total
+	order.UpdatedAt = time.Now().UTC()
+	return order.clone(), nil
+}
+
+// submit sends a draft to its supplier. The lines are checked against the inventory again,
+// an item may have been deleted since the draft was saved
+func (b *purchaseOrderBook) submit(id string) (PurchaseOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.find(id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if order.Status != OrderDraft {
+		return PurchaseOrder{}, fmt.Errorf("%w: %v is %v, only a draft can be submitted", errOrderStatus, order.ID, order.Status)
+	}
+	checked := order.clone()
+	if err := _checkPurchaseOrder(&checked); err != nil {
+		return PurchaseOrder{}, err
+	}
+	order.Status = OrderSubmitted
+	order.UpdatedAt = time.Now().UTC()
+	return order.clone(), nil
+}
+
+// cancel closes an order that isn't finished yet, stock that was already received stays on hand
+func (b *purchaseOrderBook) cancel(id string) (PurchaseOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.find(id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if order.Status == OrderReceived || order.Status == OrderCancelled {
+		return PurchaseOrder{}, fmt.Errorf("%w: %v is already %v", errOrderStatus, order.ID, order.Status)
+	}
+	order.Status = OrderCancelled
+	order.UpdatedAt = time.Now().UTC()
+	return order.clone(), nil
+}
+
+// receive books a shipment against a submitted order. The stock and the cost go through ledger.record
+// as one purchase transaction, and the order only counts the shipment once that succeeded, so the
+// stock, the ledger and the order always agree. The book stays locked throughout so two people
+// receiving the same order at once can't take in more than was ordered. When supplierID isn't empty
+// the order has to be made out to that supplier
+func (b *purchaseOrderBook) receive(inventory Store, id string, lines []receiptLine, supplierID string) (PurchaseOrder, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	order, err := b.find(id)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if supplierID != "" && !strings.EqualFold(order.SupplierID, supplierID) {
+		return PurchaseOrder{}, errOrderNotYours
+	}
+	if order.Status != OrderSubmitted && order.Status != OrderPartiallyReceived {
+		return PurchaseOrder{}, fmt.Errorf("%w: %v is %v, only a submitted order can be received", errOrderStatus, order.ID, order.Status)
+	}
+
+	if len(lines) == 0 {
+		for _, line := range order.Lines {
+			if outstanding := line.Quantity - line.Received; outstanding > 0 {
+				lines = append(lines, receiptLine{PID: line.PID, Quantity: outstanding})
+			}
+		}
+	}
+	tx := Transaction{Type: TransactionPurchase, PurchaseOrderID: order.ID, Timestamp: time.Now().UTC()}
+	onOrder := make([]int, len(lines))
+	var problems []fieldError
+	for i, received := range lines {
+		onOrder[i] = -1
+		for l, line := range order.Lines {
+			if samePID(line.PID, received.PID) {
+				onOrder[i] = l
+			}
+		}
+		for _, other := range lines[:i] {
+			if samePID(other.PID, received.PID) {
+				problems = append(problems, atIndex(i, []fieldError{{Field: "pid", Message: "is on more than one line"}})...)
+			}
+		}
+		switch {
+		case onOrder[i] < 0:
+			problems = append(problems, atIndex(i, []fieldError{{Field: "pid", Message: "isn't on the order"}})...)
+		case received.Quantity <= 0:
+			problems = append(problems, atIndex(i, []fieldError{{Field: "quantity", Message: "has to be a positive number"}})...)
+		default:
+			line := order.Lines[onOrder[i]]
+			if outstanding := line.Quantity - line.Received; received.Quantity > outstanding {
+				problems = append(problems, atIndex(i, []fieldError{{Field: "quantity",
+					Message: fmt.Sprintf("is more than the %v still outstanding", outstanding)}})...)
+			}
+			tx.Lines = append(tx.Lines, TransactionLine{PID: line.PID, Quantity: received.Quantity, UnitPrice: line.UnitCost})
+		}
+	}
+	if len(problems) > 0 {
+		return PurchaseOrder{}, fieldErrors(problems)
+	}
+
+	tx, err = ledger.record(inventory, tx)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	for i, received := range lines {
+		order.Lines[onOrder[i]].Received += received.Quantity
+	}
+	complete := true
+	for _, line := range order.Lines {
+		complete = complete && line.Received == line.Quantity
+	}
+	order.Status = OrderPartiallyReceived
+	if complete {
+		order.Status = OrderReceived
+	}
+	order.Receipts = append(order.Receipts, tx.ID)
+	order.UpdatedAt = tx.Timestamp
+	return order.clone(), nil
+}
+
+// _checkPurchaseOrder validates order against the suppliers and the inventory. Every line has to be an item
+// that is in the inventory (and not deleted), ordered once, with a positive quantity and unit cost.
+// It fills in each line's name, total and the order total, and sets each PID to how the inventory spells it
+func _checkPurchaseOrder(order *PurchaseOrder) error {
+	var problems []fieldError
+	// an order whose lines are all fine can still add up to more than a Money holds
+	var tooLarge error
+	order.SupplierID = strings.TrimSpace(order.SupplierID)
+	if supplier, found := suppliers.get(order.SupplierID); found {
+		order.SupplierID = supplier.ID
+	} else {
+		problems = append(problems, fieldError{Field: "supplierId", Message: "isn't a supplier we know"})
+	}
+	if len(order.Lines) == 0 {
+		problems = append(problems, fieldError{Field: "lines", Message: "has to have at least one line"})
+	}
+
+	order.Total = 0
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		var lineProblems []fieldError
+		if item, err := store.Get(line.PID); err != nil || item.DeletedAt != nil {
+			lineProblems = append(lineProblems, fieldError{Field: "pid", Message: "isn't in the inventory"})
+		} else {
+			line.PID, line.Name = item.PID, item.Name
+		}
+		for _, other := range order.Lines[:i] {
+			if samePID(other.PID, line.PID) {
+				lineProblems = append(lineProblems, fieldError{Field: "pid", Message: "is on more than one line"})
+			}
+		}
+		if line.Quantity <= 0 {
+			lineProblems = append(lineProblems, fieldError{Field: "quantity", Message: "has to be a positive number"})
+		}
+		if line.UnitCost <= 0 {
+			lineProblems = append(lineProblems, fieldError{Field: "unitCost", Message: "has to be a positive amount"})
+		}
+		problems = append(problems, atIndex(i, lineProblems)...)
+		line.Received = 0
+		var err error
+		if line.Total, err = line.UnitCost.CheckedMul(line.Quantity); err != nil {
+			err = fmt.Errorf("%w: the total of line %v", err, i)
+		} else if order.Total, err = order.Total.CheckedAdd(line.Total); err != nil {
+			err = fmt.Errorf("%w: the total of the order", err)
+		}
+		if err != nil && tooLarge == nil {
+			tooLarge = err
+		}
+	}
+	if len(problems) > 0 {
+		return fieldErrors(problems)
+	}
+	return tooLarge
+}
+
+// _writePurchasingError turns an error coming back from the purchase order book into the matching response
+func _writePurchasingError(w http.ResponseWriter, caller string, err error) {
+	var problems fieldErrors
+	switch {
+	case errors.As(err, &problems):
+		_writeError(w, caller, http.StatusBadRequest, codeValidationFailed, "The purchase order has invalid fields.", problems...)
+	case errors.Is(err, errOrderNotFound):
+		_writeError(w, caller, http.StatusNotFound, codeNotFound, "Could not find the purchase order.")
+	case errors.Is(err, errOrderStatus):
+		_writeError(w, caller, http.StatusConflict, codeInvalidStatus, "The purchase order can't do that in its status: "+err.Error())
+	case errors.Is(err, errOrderNotYours):
+		_writeError(w, caller, http.StatusForbidden, codeForbidden, "A supplier can only receive the purchase orders made out to them.")
+	default:
+		// an item on the order was deleted since it was submitted, or the inventory couldn't be saved
+		_writeStoreError(w, caller, err)
+	}
+}
+
+// _decodePurchaseOrder reads the supplier and lines of an order from the body of a create or replace,
+// anything else in it (ID, status, received counts) is ignored
+func _decodePurchaseOrder(w http.ResponseWriter, r *http.Request, caller string) (PurchaseOrder, bool) {
+	var order PurchaseOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		_writeError(w, caller, http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the purchase order received. Please provide a JSON object with a 'supplierId' and 'lines' "+
+				"that each have a 'pid', a positive 'quantity' and the 'unitCost' the supplier charges.", _decodeProblem(err))
+		return PurchaseOrder{}, false
+	}
+	return order, true
+}
+
+// employees draft an order for a supplier, it isn't sent until it is submitted
+func createPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: createPurchaseOrder()")
+
+	order, ok := _decodePurchaseOrder(w, r, "createPurchaseOrder")
+	if !ok {
+		return
+	}
+	order, err := purchaseOrders.create(order)
+	if err != nil {
+		_writePurchasingError(w, "createPurchaseOrder", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
+
+// _supplierScope returns the supplier whose orders are the only ones the caller may see and receive,
+// that of their key for a supplier and "" for everyone else. A supplier key that isn't tied to a
+// supplier has no orders of its own, it is refused with a 403 and ok is false
+func _supplierScope(w http.ResponseWriter, r *http.Request, caller string) (supplierID string, ok bool) {
+	principal, found := principalOf(r)
+	if !found || principal.Role != RoleSupplier {
+		return "", true
+	}
+	if principal.SupplierID == "" {
+		_writeError(w, caller, http.StatusForbidden, codeForbidden,
+			"This supplier key isn't tied to a supplier, ask an admin for one with a 'supplierId' to see and receive purchase orders.")
+		return "", false
+	}
+	return principal.SupplierID, true
+}
+
+// lists every order in the order they were created, ?status= and ?supplierId= narrow it down.
+// A supplier only gets the orders made out to them
+func getPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getPurchaseOrders()")
+
+	own, ok := _supplierScope(w, r, "getPurchaseOrders")
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	status := PurchaseOrderStatus(query.Get("status"))
+	switch status {
+	case "", OrderDraft, OrderSubmitted, OrderPartiallyReceived, OrderReceived, OrderCancelled:
+	default:
+		_writeError(w, "getPurchaseOrders", http.StatusBadRequest, codeInvalidParameter,
+			"status has to be draft, submitted, partially_received, received or cancelled.",
+			fieldError{Field: "status", Message: "has to be draft, submitted, partially_received, received or cancelled"})
+		return
+	}
+
+	orders := []PurchaseOrder{}
+	supplierID := strings.TrimSpace(query.Get("supplierId"))
+	if own == "" || supplierID == "" || strings.EqualFold(supplierID, own) {
+		if own != "" {
+			supplierID = own
+		}
+		orders = purchaseOrders.list(status, supplierID)
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(orders)
+}
+
+// a supplier can only look up the orders made out to them, any other is not found
+func getPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getPurchaseOrder()")
+
+	own, ok := _supplierScope(w, r, "getPurchaseOrder")
+	if !ok {
+		return
+	}
+	order, err := purchaseOrders.get(mux.Vars(r)["id"])
+	if err == nil && own != "" && !strings.EqualFold(order.SupplierID, own) {
+		err = errOrderNotFound
+	}
+	if err != nil {
+		_writePurchasingError(w, "getPurchaseOrder", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
+
+// a draft can be changed as often as needed, the body replaces its supplier and every line
+func replacePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: replacePurchaseOrder()")
+
+	update, ok := _decodePurchaseOrder(w, r, "replacePurchaseOrder")
+	if !ok {
+		return
+	}
+	order, err := purchaseOrders.replace(mux.Vars(r)["id"], update)
+	if err != nil {
+		_writePurchasingError(w, "replacePurchaseOrder", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
+
+func submitPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: submitPurchaseOrder()")
+
+	order, err := purchaseOrders.submit(mux.Vars(r)["id"])
+	if err != nil {
+		_writePurchasingError(w, "submitPurchaseOrder", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
+
+func cancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: cancelPurchaseOrder()")
+
+	order, err := purchaseOrders.cancel(mux.Vars(r)["id"])
+	if err != nil {
+		_writePurchasingError(w, "cancelPurchaseOrder", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
+
+// suppliers dropping off a shipment for an order, the stock goes up and what we paid is recorded as a
+// purchase transaction at the order's unit costs. A shipment can be part of the order, the order
+// is received once every line has arrived in full
+func receivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: receivePurchaseOrder()")
+
+	var req receiptRequest
+	// an empty body receives everything that is still outstanding
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		_writeError(w, "receivePurchaseOrder", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the shipment received. Please provide a JSON object with 'lines' that each have a 'pid' and a positive 'quantity'.",
+			_decodeProblem(err))
+		return
+	}
+	// a supplier only drops off their own shipments
+	supplierID, ok := _supplierScope(w, r, "receivePurchaseOrder")
+	if !ok {
+		return
+	}
+	order, err := purchaseOrders.receive(_auditedStore(w, r), mux.Vars(r)["id"], req.Lines, supplierID)
+	if err != nil {
+		_writePurchasingError(w, "receivePurchaseOrder", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(order)
+}
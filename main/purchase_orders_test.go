@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// usePurchasing starts the test over with no suppliers and no purchase orders, and puts the old ones back afterwards
+func usePurchasing(t *testing.T) {
+	oldSuppliers, oldOrders := suppliers, purchaseOrders
+	suppliers, purchaseOrders = newSupplierRegistry(), newPurchaseOrderBook()
+	t.Cleanup(func() { suppliers, purchaseOrders = oldSuppliers, oldOrders })
+}
+
+// supplierReq registers supplier through the router, checks the status and returns the registered supplier when it succeeded
+func supplierReq(supplier Supplier, expStatus int, t *testing.T) Supplier {
+	respRecorder := serveRoute(newRouter(), "POST", "/suppliers", supplier)
+	checkStatus(respRecorder.Code, expStatus, t, "supplierReq "+supplier.Name)
+
+	var registered Supplier
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&registered)
+		checkResponseError(err, respRecorder, "Supplier", t)
+	}
+	return registered
+}
+
+// orderReq sends a request to a purchase order route, checks the status and returns the order when it succeeded
+func orderReq(method string, path string, body interface{}, expStatus int, t *testing.T) PurchaseOrder {
+	respRecorder := serveRoute(newRouter(), method, path, body)
+	checkStatus(respRecorder.Code, expStatus, t, "orderReq "+method+" "+path)
+
+	var order PurchaseOrder
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&order)
+		checkResponseError(err, respRecorder, "PurchaseOrder", t)
+	}
+	return order
+}
+
+// checkReceived logs an error when the lines of order haven't received exactly the expected quantities, in order
+func checkReceived(order PurchaseOrder, expected []int, t *testing.T, checkpoint string) {
+	if len(order.Lines) != len(expected) {
+		t.Errorf("%v -- actual lines - %+v | expected %v of them", checkpoint, order.Lines, len(expected))
+		return
+	}
+	for i, line := range order.Lines {
+		if line.Received != expected[i] {
+			t.Errorf("%v -- received of %v: actual - %v | expected - %v", checkpoint, line.Name, line.Received, expected[i])
+		}
+	}
+}
+
+func TestSuppliers(t *testing.T) {
+	usePurchasing(t)
+	router := newRouter()
+
+	t.Log("1. register a supplier, then some that aren't valid")
+	farm := supplierReq(Supplier{Name: " Green Acres Farm ", Email: "orders@greenacres.example"}, http.StatusOK, t)
+	if !strings.HasPrefix(farm.ID, "SUP-") || farm.Name != "Green Acres Farm" || farm.CreatedAt.IsZero() {
+		t.Errorf("1 -- unexpected supplier: %+v", farm)
+	}
+	supplierReq(Supplier{Name: "green acres farm"}, http.StatusBadRequest, t)
+	respRecorder := serveRoute(router, "POST", "/suppliers", Supplier{Email: "not an email"})
+	details := decodeAPIError(respRecorder, codeValidationFailed, t, "1 invalid").Details
+	if len(details) != 2 {
+		t.Errorf("1 -- actual details - %+v | expected the name and the email", details)
+	}
+
+	t.Log("2. look the supplier up")
+	var found Supplier
+	respRecorder = serveRoute(router, "GET", "/suppliers/"+strings.ToLower(farm.ID), nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "2 by id")
+	checkResponseError(json.NewDecoder(respRecorder.Body).Decode(&found), respRecorder, "Supplier", t)
+	if found != farm {
+		t.Errorf("2 -- actual - %+v | expected - %+v", found, farm)
+	}
+	decodeAPIError(serveRoute(router, "GET", "/suppliers/SUP-0404", nil), codeNotFound, t, "2 unknown")
+}
+
+func TestPurchaseOrders(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	usePurchasing(t)
+	router := newRouter()
+	lettuce, peach, pepper := "A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88", "YRT6-72AS-K736-L4AR"
+	farm := supplierReq(Supplier{Name: "Green Acres Farm"}, http.StatusOK, t)
+
+	// 1. the lines have to be items in the inventory ============================================================================
+	t.Log("1. draft an order with bad lines and an unknown supplier")
+	deleteItemReq(pepper, t)
+	respRecorder := serveRoute(router, "POST", "/purchase-orders", PurchaseOrder{
+		SupplierID: "SUP-0404",
+		Lines: []PurchaseOrderLine{
+			{PID: "N0NE-0000-0000-0000", Quantity: 5, UnitCost: 100},
+			{PID: pepper, Quantity: 5, UnitCost: 100},
+			{PID: lettuce, Quantity: 0, UnitCost: 0},
+			{PID: "a12t-4gh7-qpl9-3n4m", Quantity: 5, UnitCost: 100},
+		},
+	})
+	details := decodeAPIError(respRecorder, codeValidationFailed, t, "1 bad order").Details
+	if len(details) != 6 {
+		t.Errorf("1 -- actual details - %+v | expected the supplier, two unknown PIDs, the quantity, the cost and the repeated PID", details)
+	}
+	orderReq("POST", "/purchase-orders", PurchaseOrder{SupplierID: farm.ID}, http.StatusBadRequest, t)
+
+	// 2. a draft can be changed, but not received ===============================================================================
+	t.Log("2. draft an order and change it")
+	order := orderReq("POST", "/purchase-orders", PurchaseOrder{
+		SupplierID: strings.ToLower(farm.ID),
+		Lines:      []PurchaseOrderLine{{PID: "a12t-4gh7-qpl9-3n4m", Quantity: 10, UnitCost: 120}},
+	}, http.StatusOK, t)
+	if order.ID != "PO-000001" || order.Status != OrderDraft || order.SupplierID != farm.ID || order.Lines[0].Name != "Lettuce" || order.Total != 1200 {
+		t.Errorf("2 -- unexpected order: %+v", order)
+	}
+	path := "/purchase-orders/" + order.ID
+	order = orderReq("PUT", path, PurchaseOrder{
+		SupplierID: farm.ID,
+		Lines: []PurchaseOrderLine{
+			{PID: lettuce, Quantity: 10, UnitCost: 120},
+			{PID: peach, Quantity: 20, UnitCost: 80, Received: 20},
+		},
+	}, http.StatusOK, t)
+	checkReceived(order, []int{0, 0}, t, "2 replaced")
+	if order.Total != 2800 {
+		t.Errorf("2 -- actual total - %v | expected - 28.00", order.Total)
+	}
+	decodeAPIError(serveRoute(router, "POST", path+"/receive", nil), codeInvalidStatus, t, "2 receive a draft")
+
+	// 3. a shipment comes in part of the way =====================================================================================
+	t.Log("3. submit the order and receive part of it")
+	order = orderReq("POST", path+"/submit", nil, http.StatusOK, t)
+	if order.Status != OrderSubmitted {
+		t.Errorf("3 -- actual status - %v | expected - submitted", order.Status)
+	}
+	decodeAPIError(serveRoute(router, "PUT", path, PurchaseOrder{SupplierID: farm.ID}), codeInvalidStatus, t, "3 change a submitted order")
+	decodeAPIError(serveRoute(router, "POST", path+"/submit", nil), codeInvalidStatus, t, "3 submit twice")
+	order = orderReq("POST", path+"/receive", receiptRequest{Lines: []receiptLine{{PID: "e5t6-9ui3-th15-qr88", Quantity: 15}}}, http.StatusOK, t)
+	if order.Status != OrderPartiallyReceived || len(order.Receipts) != 1 {
+		t.Errorf("3 -- unexpected order: %+v", order)
+	}
+	checkReceived(order, []int{0, 15}, t, "3")
+	checkQuantity(peach, 15, t, "3")
+	// the shipment is on the books at what we paid for it
+	tx, found := ledger.get(order.Receipts[0])
+	if !found || tx.Type != TransactionPurchase || tx.PurchaseOrderID != order.ID || tx.Total != 1200 || tx.Lines[0].UnitPrice != 80 {
+		t.Errorf("3 -- unexpected purchase transaction: %+v", tx)
+	}
+
+	// 4. nothing more than was ordered ===========================================================================================
+	t.Log("4. receive more than is outstanding, and an item that isn't on the order")
+	respRecorder = serveRoute(router, "POST", path+"/receive", receiptRequest{Lines: []receiptLine{
+		{PID: peach, Quantity: 6},
+		{PID: pepper, Quantity: 1},
+	}})
+	if details := decodeAPIError(respRecorder, codeValidationFailed, t, "4").Details; len(details) != 2 {
+		t.Errorf("4 -- actual details - %+v | expected the peach quantity and the pepper", details)
+	}
+	checkQuantity(peach, 15, t, "4")
+
+	// 5. the rest arrives ==========================================================================================================
+	t.Log("5. receive everything that is left")
+	order = orderReq("POST", path+"/receive", nil, http.StatusOK, t)
+	if order.Status != OrderReceived || len(order.Receipts) != 2 {
+		t.Errorf("5 -- unexpected order: %+v", order)
+	}
+	checkReceived(order, []int{10, 20}, t, "5")
+	checkQuantity(lettuce, 10, t, "5")
+	checkQuantity(peach, 20, t, "5")
+	decodeAPIError(serveRoute(router, "POST", path+"/receive", nil), codeInvalidStatus, t, "5 receive again")
+	decodeAPIError(serveRoute(router, "POST", path+"/cancel", nil), codeInvalidStatus, t, "5 cancel")
+
+	// 6. a partly received order can be cancelled, what arrived stays ============================================================
+	t.Log("6. cancel an order after part of it arrived")
+	second := orderReq("POST", "/purchase-orders", PurchaseOrder{
+		SupplierID: farm.ID,
+		Lines:      []PurchaseOrderLine{{PID: lettuce, Quantity: 6, UnitCost: 110}},
+	}, http.StatusOK, t)
+	orderReq("POST", "/purchase-orders/"+second.ID+"/submit", nil, http.StatusOK, t)
+	orderReq("POST", "/purchase-orders/"+second.ID+"/receive", receiptRequest{Lines: []receiptLine{{PID: lettuce, Quantity: 2}}}, http.StatusOK, t)
+	second = orderReq("POST", "/purchase-orders/"+second.ID+"/cancel", nil, http.StatusOK, t)
+	if second.Status != OrderCancelled {
+		t.Errorf("6 -- actual status - %v | expected - cancelled", second.Status)
+	}
+	checkQuantity(lettuce, 12, t, "6")
+
+	// 7. listing =====================================================================================================================
+	t.Log("7. list the orders")
+	var orders []PurchaseOrder
+	respRecorder = serveRoute(router, "GET", "/purchase-orders?status=received&supplierId="+farm.ID, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "7 received")
+	checkResponseError(json.NewDecoder(respRecorder.Body).Decode(&orders), respRecorder, "[]PurchaseOrder", t)
+	if len(orders) != 1 || orders[0].ID != order.ID {
+		t.Errorf("7 -- actual - %+v | expected only the received order", orders)
+	}
+	decodeAPIError(serveRoute(router, "GET", "/purchase-orders?status=lost", nil), codeInvalidParameter, t, "7 bad status")
+	decodeAPIError(serveRoute(router, "GET", "/purchase-orders/PO-999999", nil), codeNotFound, t, "7 unknown order")
+}
+
+func TestPurchasingPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	usePurchasing(t)
+	router, keyOf := authRouter(t)
+	farm, err := suppliers.add(Supplier{Name: "Green Acres Farm"})
+	checkError(err, t)
+	order, err := purchaseOrders.create(PurchaseOrder{SupplierID: farm.ID, Lines: []PurchaseOrderLine{{PID: "A12T-4GH7-QPL9-3N4M", Quantity: 4, UnitCost: 120}}})
+	checkError(err, t)
+	path := "/purchase-orders/" + order.ID
+
+	dairy, err := suppliers.add(Supplier{Name: "Hillside Dairy"})
+	checkError(err, t)
+	farmKey, _ := createKeyReq(router, keyOf[RoleAdmin], keyRequest{Principal: "green-acres", Role: RoleSupplier, SupplierID: strings.ToLower(farm.ID)}, http.StatusOK, t)
+	dairyKey, dairyAPIKey := createKeyReq(router, keyOf[RoleAdmin], keyRequest{Principal: "hillside", Role: RoleSupplier, SupplierID: dairy.ID}, http.StatusOK, t)
+	createKeyReq(router, keyOf[RoleAdmin], keyRequest{Principal: "nobody", Role: RoleSupplier, SupplierID: "SUP-0404"}, http.StatusBadRequest, t)
+	createKeyReq(router, keyOf[RoleAdmin], keyRequest{Principal: "till-1", Role: RoleEmployee, SupplierID: farm.ID}, http.StatusBadRequest, t)
+	if dairyAPIKey.SupplierID != dairy.ID {
+		t.Errorf("supplier key -- actual - %+v | expected it to work for %v", dairyAPIKey, dairy.ID)
+	}
+
+	authReq(router, keyOf[RoleShopper], "GET", "/purchase-orders", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleExec], "GET", "/suppliers", http.StatusOK, t)
+	// suppliers see what we ordered from them and drop the shipment off, but they don't write our orders
+	authReq(router, farmKey, "GET", path, http.StatusOK, t)
+	authReq(router, farmKey, "POST", path+"/submit", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleEmployee], "POST", path+"/submit", http.StatusOK, t)
+	authReq(router, keyOf[RoleExec], "POST", path+"/cancel", http.StatusForbidden, t)
+
+	// nor what we ordered from anyone else
+	_, err = purchaseOrders.create(PurchaseOrder{SupplierID: dairy.ID, Lines: []PurchaseOrderLine{{PID: "A12T-4GH7-QPL9-3N4M", Quantity: 6, UnitCost: 90}}})
+	checkError(err, t)
+	for _, c := range []struct {
+		key      string
+		query    string
+		expected int
+	}{
+		{keyOf[RoleEmployee], "", 2},
+		{farmKey, "", 1},
+		{farmKey, "?supplierId=" + dairy.ID, 0},
+		{dairyKey, "?supplierId=" + dairy.ID, 1},
+	} {
+		var orders []PurchaseOrder
+		respRecorder := authReq(router, c.key, "GET", "/purchase-orders"+c.query, http.StatusOK, t)
+		checkError(json.NewDecoder(respRecorder.Body).Decode(&orders), t)
+		if len(orders) != c.expected {
+			t.Errorf("list %v -- actual orders - %+v | expected %v of them", c.query, orders, c.expected)
+		}
+	}
+	decodeAPIError(authReq(router, dairyKey, "GET", path, http.StatusNotFound, t), codeNotFound, t, "another supplier's order")
+	decodeAPIError(authReq(router, keyOf[RoleSupplier], "GET", "/purchase-orders", http.StatusForbidden, t), codeForbidden, t, "list without a supplier")
+
+	// a supplier key only receives the orders made out to its own supplier, and no stock outside of them
+	decodeAPIError(authReq(router, keyOf[RoleSupplier], "POST", path+"/receive", http.StatusForbidden, t), codeForbidden, t, "no supplier")
+	decodeAPIError(authReq(router, dairyKey, "POST", path+"/receive", http.StatusForbidden, t), codeForbidden, t, "another supplier")
+	authReq(router, farmKey, "POST", "/inventory/A12T-4GH7-QPL9-3N4M/receive", http.StatusForbidden, t)
+	checkQuantity("A12T-4GH7-QPL9-3N4M", 0, t, "refused receipts")
+	authReq(router, farmKey, "POST", path+"/receive", http.StatusOK, t)
+	checkQuantity("A12T-4GH7-QPL9-3N4M", 4, t, "received")
+}
+
+func TestSupplierKeysAfterRestart(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	usePurchasing(t)
+	router, keyOf := authRouter(t)
+	farm, err := suppliers.add(Supplier{Name: "Green Acres Farm"})
+	checkError(err, t)
+	farmKey, _ := createKeyReq(router, keyOf[RoleAdmin], keyRequest{Principal: "green-acres", Role: RoleSupplier, SupplierID: farm.ID}, http.StatusOK, t)
+
+	// the keys are saved but the suppliers aren't, so after a restart they are registered again, in another order
+	suppliers, purchaseOrders = newSupplierRegistry(), newPurchaseOrderBook()
+	dairy, err := suppliers.add(Supplier{Name: "Hillside Dairy"})
+	checkError(err, t)
+	farmAgain, err := suppliers.add(Supplier{Name: "Green Acres Farm"})
+	checkError(err, t)
+	if dairy.ID == farm.ID || farmAgain.ID == farm.ID {
+		t.Fatalf("a supplier registered after the restart got the id of one from before: %v, %v and %v", farm.ID, dairy.ID, farmAgain.ID)
+	}
+
+	// the old key can't receive anyone's orders now, not even those of the same company registered again
+	for _, supplier := range []Supplier{dairy, farmAgain} {
+		order, err := purchaseOrders.create(PurchaseOrder{SupplierID: supplier.ID, Lines: []PurchaseOrderLine{{PID: "A12T-4GH7-QPL9-3N4M", Quantity: 4, UnitCost: 120}}})
+		checkError(err, t)
+		_, err = purchaseOrders.submit(order.ID)
+		checkError(err, t)
+		decodeAPIError(authReq(router, farmKey, "POST", "/purchase-orders/"+order.ID+"/receive", http.StatusForbidden, t), codeForbidden, t, supplier.Name)
+	}
+	checkQuantity("A12T-4GH7-QPL9-3N4M", 0, t, "old supplier key")
+}
+
+func TestPurchaseOrderTotalsOverflow(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	usePurchasing(t)
+	router := newRouter()
+	farm := supplierReq(Supplier{Name: "Green Acres Farm"}, http.StatusOK, t)
+
+	// a line whose total wraps around, and lines that are each fine but add up to too much
+	for i, lines := range [][]PurchaseOrderLine{
+		{{PID: "A12T-4GH7-QPL9-3N4M", Quantity: 100000000000000000, UnitCost: 100000}},
+		{{PID: "A12T-4GH7-QPL9-3N4M", Quantity: 1, UnitCost: math.MaxInt64}, {PID: "E5T6-9UI3-TH15-QR88", Quantity: 1, UnitCost: 1}},
+	} {
+		respRecorder := serveRoute(router, "POST", "/purchase-orders", PurchaseOrder{SupplierID: farm.ID, Lines: lines})
+		decodeAPIError(respRecorder, codeInvalidParameter, t, fmt.Sprintf("overflow %v", i+1))
+	}
+	if orders := purchaseOrders.list("", ""); len(orders) != 0 {
+		t.Errorf("overflow -- actual orders - %+v | expected none to be saved", orders)
+	}
+}
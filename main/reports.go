@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	// bundle the timezone database so ?tz= works on machines that don't have one installed
+	_ "time/tzdata"
+)
+
+// profitFigures are the money columns of every row of the profit report
+// revenue is sales minus refunds, cost of goods is what the goods sold (minus the ones
+// returned) cost us, and margin is revenue minus cost of goods.
+// UncostedUnits are the units sold that no purchase transaction paid for, stock that came in through
+// receiveStock or was added with a quantity. They are in the revenue with no cost, so the margin is
+// overstated by whatever they really cost
+type profitFigures struct {
+	Revenue       Money `json:"revenue"`
+	CostOfGoods   Money `json:"costOfGoods"`
+	Margin        Money `json:"margin"`
+	UncostedUnits int   `json:"uncostedUnits"`
+}
+
+type itemProfit struct {
+	PID       string `json:"pid"`
+	Name      string `json:"name"`
+	UnitsSold int    `json:"unitsSold"`
+	profitFigures
+}
+
+type periodProfit struct {
+	Period string       `json:"period"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Items  []itemProfit `json:"items"`
+	profitFigures
+}
+
+type profitReport struct {
+	From        *time.Time     `json:"from,omitempty"`
+	To          *time.Time     `json:"to,omitempty"`
+	Granularity string         `json:"granularity"`
+	Timezone    string         `json:"timezone"`
+	Periods     []periodProfit `json:"periods"`
+	Items       []itemProfit   `json:"items"`
+	Total       profitFigures  `json:"total"`
+}
+
+// costBasis is everything we've paid for one item so far, its average unit cost is cost / units
+type costBasis struct {
+	units int64
+	cost  Money
+	// unsold is how many of the purchased units haven't been sold yet, a sale of more than that
+	// is of stock that came in some other way. uncostedSold counts those, less the ones returned
+	unsold       int64
+	uncostedSold int64
+}
+
+// costOf returns what quantity units cost at the average purchase price so far
+// an item we never recorded a purchase for costs nothing, there's nothing to go on
+func (c *costBasis) costOf(quantity int64) Money {
+	if c.units == 0 {
+		return 0
+	}
+	return c.cost.MulRatio(quantity, c.units)
+}
+
+// sell returns what quantity units sold cost and how many of them have no cost. Purchased units
+// are sold first, anything past them is stock we never paid for in a transaction
+func (c *costBasis) sell(quantity int) (Money, int) {
+	costed := int64(quantity)
+	if costed > c.unsold {
+		costed = c.unsold
+	}
+	uncosted := int64(quantity) - costed
+	c.unsold -= costed
+	c.uncostedSold += uncosted
+	return c.costOf(costed), int(uncosted)
+}
+
+// takeBack undoes sell for quantity returned units, uncosted ones first so a return of
+// stock that had no cost doesn't take a cost off that was never counted
+func (c *costBasis) takeBack(quantity int) (Money, int) {
+	uncosted := int64(quantity)
+	if uncosted > c.uncostedSold {
+		uncosted = c.uncostedSold
+	}
+	costed := int64(quantity) - uncosted
+	c.uncostedSold -= uncosted
+	c.unsold += costed
+	return c.costOf(costed), int(uncosted)
+}
+
+// add folds one transaction line into the figures, sales count up and returns count down
+func (f *profitFigures) add(revenue Money, cost Money, uncosted int) {
+	f.Revenue = f.Revenue.Add(revenue)
+	f.CostOfGoods = f.CostOfGoods.Add(cost)
+	f.Margin = f.Revenue.Sub(f.CostOfGoods)
+	f.UncostedUnits += uncosted
+}
+
+// periodStart returns the start of the day, week (starting Monday) or month that t falls in,
+// in loc, along with the label of that period. Boundaries are local midnights so a day that
+// has a DST change in it is 23 or 25 hours long, just like on the store's clock
+func periodStart(t time.Time, granularity string, loc *time.Location) (time.Time, string) {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch granularity {
+	case "week":
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		start := time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, loc)
+		isoYear, isoWeek := start.ISOWeek()
+		return start, fmt.Sprintf("%d-W%02d", isoYear, isoWeek)
+	case "month":
+		start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		return start, start.Format("2006-01")
+	}
+	start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	return start, start.Format("2006-01-02")
+}
+
+// periodEnd returns the start of the period after the one starting at start
+func periodEnd(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// buildProfitReport buckets the sales and returns in [from, to) by period, a zero from or to is unbounded.
+// Every transaction is walked in time order, even those before from, so the average cost of
+// an item is what we had paid for it up to the moment of each sale. Only periods that have
+// sales or returns in them are in the report
+func buildProfitReport(transactions []Transaction, from time.Time, to time.Time, granularity string, loc *time.Location) profitReport {
+	report := profitReport{Granularity: granularity, Timezone: loc.String(), Periods: []periodProfit{}}
+	if !from.IsZero() {
+		report.From = &from
+	}
+	if !to.IsZero() {
+		report.To = &to
+	}
+
+	sorted := make([]Transaction, len(transactions))
+	copy(sorted, transactions)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	bases := map[string]*costBasis{}
+	periods := map[string]*periodProfit{}
+	periodItems := map[string]map[string]*itemProfit{}
+	totalItems := map[string]*itemProfit{}
+
+	for _, tx := range sorted {
+		inRange := !tx.Timestamp.Before(from) && (to.IsZero() || tx.Timestamp.Before(to))
+		start, label := periodStart(tx.Timestamp, granularity, loc)
+
+		for _, line := range tx.Lines {
+			key := strings.ToUpper(line.PID)
+			basis := bases[key]
+			if basis == nil {
+				basis = &costBasis{}
+				bases[key] = basis
+			}
+
+			var units, uncosted int
+			var revenue, cost Money
+			switch tx.Type {
+			case TransactionPurchase:
+				basis.units += int64(line.Quantity)
+				basis.unsold += int64(line.Quantity)
+				basis.cost = basis.cost.Add(line.Total)
+				continue
+			case TransactionSale:
+				units, revenue = line.Quantity, line.Total
+				cost, uncosted = basis.sell(line.Quantity)
+			case TransactionReturn:
+				// the refund comes off revenue and the goods go back on the shelf at their average cost
+				units, revenue = -line.Quantity, -line.Total
+				cost, uncosted = basis.takeBack(line.Quantity)
+				cost, uncosted = -cost, -uncosted
+			}
+			if !inRange {
+				continue
+			}
+
+			period := periods[label]
+			if period == nil {
+				period = &periodProfit{Period: label, Start: start, End: periodEnd(start, granularity)}
+				periods[label] = period
+				periodItems[label] = map[string]*itemProfit{}
+			}
+			period.add(revenue, cost, uncosted)
+			report.Total.add(revenue, cost, uncosted)
+			for _, items := range []map[string]*itemProfit{periodItems[label], totalItems} {
+				item := items[key]
+				if item == nil {
+					item = &itemProfit{PID: line.PID}
+					items[key] = item
+				}
+				item.Name = line.Name
+				item.UnitsSold += units
+				item.add(revenue, cost, uncosted)
+			}
+		}
+	}
+
+	for label, period := range periods {
+		period.Items = sortedItemProfits(periodItems[label])
+		report.Periods = append(report.Periods, *period)
+	}
+	sort.Slice(report.Periods, func(i, j int) bool { return report.Periods[i].Start.Before(report.Periods[j].Start) })
+	report.Items = sortedItemProfits(totalItems)
+	return report
+}
+
+// sortedItemProfits lists the items best seller (by revenue) first
+func sortedItemProfits(items map[string]*itemProfit) []itemProfit {
+	list := []itemProfit{}
+	for _, item := range items {
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Revenue != list[j].Revenue {
+			return list[i].Revenue > list[j].Revenue
+		}
+		return list[i].PID < list[j].PID
+	})
+	return list
+}
+
+// _parseReportTime reads a from/to query value, either RFC 3339 or a plain date in loc.
+// A plain date for 'to' means the whole of that day is included
+func _parseReportTime(value string, isTo bool, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if isTo {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+// exec staff look at revenue, cost of goods and margin by day, week or month
+// ?from= and ?to= limit the report (RFC 3339 or YYYY-MM-DD), ?granularity= is day (default),
+// week or month, and ?tz= is the IANA timezone the periods start at midnight in (default UTC)
+func getProfitReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getProfitReport()")
+
+	query := r.URL.Query()
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	tz := query.Get("tz")
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	loc, err := time.LoadLocation(tz)
+	var from, to time.Time
+	if err == nil {
+		from, err = _parseReportTime(query.Get("from"), false, loc)
+	}
+	if err == nil {
+		to, err = _parseReportTime(query.Get("to"), true, loc)
+	}
+	if err == nil && granularity != "day" && granularity != "week" && granularity != "month" {
+		err = fmt.Errorf("unknown granularity %q", granularity)
+	}
+	if err == nil && !to.IsZero() && !to.After(from) {
+		err = fmt.Errorf("to has to be after from")
+	}
+	if err != nil {
+		_writeError(w, "getProfitReport", http.StatusBadRequest, codeInvalidParameter,
+			"Could not understand the report parameters. 'from' and 'to' should be RFC 3339 times or YYYY-MM-DD dates, "+
+				"'granularity' day, week or month and 'tz' an IANA timezone like America/Chicago.", fieldError{Message: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(buildProfitReport(ledger.list(""), from, to, granularity, loc))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// checkFigures logs an error when the revenue, cost of goods or margin aren't what we expect
+func checkFigures(actual profitFigures, revenue Money, cost Money, t *testing.T, checkpoint string) {
+	expected := profitFigures{Revenue: revenue, CostOfGoods: cost, Margin: revenue.Sub(cost)}
+	if actual != expected {
+		t.Errorf("%v -- actual - %+v | expected - %+v", checkpoint, actual, expected)
+	}
+}
+
+func TestProfitReport(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	checkError(err, t)
+	at := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, chicago)
+	}
+	peach, pepper := "E5T6-9UI3-TH15-QR88", "YRT6-72AS-K736-L4AR"
+
+	transactions := []Transaction{
+		// bought 7 peaches for 10.00 (1.428571... each) and 10 peppers for 4.00
+		{Type: TransactionPurchase, Timestamp: at(3, 1, 9), Lines: []TransactionLine{
+			{PID: peach, Name: "Peach", Quantity: 7, UnitPrice: 142, Total: 1000},
+			{PID: pepper, Name: "Green Pepper", Quantity: 10, UnitPrice: 40, Total: 400},
+		}},
+		// late on the 7th Chicago time, which is already the 8th in UTC
+		{Type: TransactionSale, Timestamp: at(3, 7, 23), Lines: []TransactionLine{
+			{PID: peach, Name: "Peach", Quantity: 3, UnitPrice: 299, Total: 897},
+		}},
+		// the 8th is the day clocks spring forward, a 23 hour day
+		{Type: TransactionSale, Timestamp: at(3, 8, 22), Lines: []TransactionLine{
+			{PID: "e5t6-9ui3-th15-qr88", Name: "Peach", Quantity: 1, UnitPrice: 299, Total: 299},
+			{PID: pepper, Name: "Green Pepper", Quantity: 5, UnitPrice: 79, Total: 395},
+		}},
+		{Type: TransactionReturn, Timestamp: at(3, 9, 10), Lines: []TransactionLine{
+			{PID: pepper, Name: "Green Pepper", Quantity: 1, UnitPrice: 79, Total: 79},
+		}},
+		// buying more peaches at a new price changes the average cost of later sales only
+		{Type: TransactionPurchase, Timestamp: at(4, 1, 9), Lines: []TransactionLine{
+			{PID: peach, Name: "Peach", Quantity: 3, UnitPrice: 200, Total: 600},
+		}},
+		{Type: TransactionSale, Timestamp: at(4, 2, 9), Lines: []TransactionLine{
+			{PID: peach, Name: "Peach", Quantity: 2, UnitPrice: 299, Total: 598},
+		}},
+	}
+	// the ledger doesn't have to be in time order
+	transactions[2], transactions[3] = transactions[3], transactions[2]
+
+	// 1. by day in Chicago =================================================================================================
+	t.Log("1. daily report")
+	daily := buildProfitReport(transactions, time.Time{}, time.Time{}, "day", chicago)
+	labels := []string{"2026-03-07", "2026-03-08", "2026-03-09", "2026-04-02"}
+	if len(daily.Periods) != len(labels) {
+		t.Fatalf("1 -- actual periods - %+v | expected labels - %v", daily.Periods, labels)
+	}
+	for i, label := range labels {
+		if daily.Periods[i].Period != label {
+			t.Errorf("1 -- period %v: actual - %v | expected - %v", i, daily.Periods[i].Period, label)
+		}
+	}
+	if hours := daily.Periods[1].End.Sub(daily.Periods[1].Start).Hours(); hours != 23 {
+		t.Errorf("1 -- the DST day should be 23 hours long, is %v", hours)
+	}
+	// 3 of 7 peaches that cost 10.00 is 4.29
+	checkFigures(daily.Periods[0].profitFigures, 897, 429, t, "1 March 7th")
+	// 1 peach at 1.43 and 5 of 10 peppers that cost 4.00 is 2.00
+	checkFigures(daily.Periods[1].profitFigures, 694, 343, t, "1 March 8th")
+	checkFigures(daily.Periods[2].profitFigures, -79, -40, t, "1 March 9th (return)")
+	// 2 of 10 peaches that cost 16.00 is 3.20
+	checkFigures(daily.Periods[3].profitFigures, 598, 320, t, "1 April 2nd")
+	checkFigures(daily.Total, 2110, 1052, t, "1 total")
+
+	if len(daily.Items) != 2 || daily.Items[0].Name != "Peach" || daily.Items[0].UnitsSold != 6 || daily.Items[1].UnitsSold != 4 {
+		t.Errorf("1 -- unexpected item totals: %+v", daily.Items)
+	}
+
+	// 2. the same days in UTC fall differently ===============================================================================
+	t.Log("2. daily report in UTC")
+	utc := buildProfitReport(transactions, time.Time{}, time.Time{}, "day", time.UTC)
+	if utc.Periods[0].Period != "2026-03-08" {
+		t.Errorf("2 -- the first sale should be on the 8th in UTC, is on %v", utc.Periods[0].Period)
+	}
+
+	// 3. by week and month, limited to March =================================================================================
+	t.Log("3. weekly and monthly reports for March")
+	from, to := at(3, 1, 0), at(4, 1, 0)
+	weekly := buildProfitReport(transactions, from, to, "week", chicago)
+	if len(weekly.Periods) != 2 || weekly.Periods[0].Period != "2026-W10" || weekly.Periods[1].Period != "2026-W11" {
+		t.Errorf("3 -- unexpected weeks: %+v", weekly.Periods)
+	}
+	monthly := buildProfitReport(transactions, from, to, "month", chicago)
+	if len(monthly.Periods) != 1 || monthly.Periods[0].Period != "2026-03" {
+		t.Fatalf("3 -- unexpected months: %+v", monthly.Periods)
+	}
+	checkFigures(monthly.Periods[0].profitFigures, 1512, 732, t, "3 March")
+	checkFigures(monthly.Total, 1512, 732, t, "3 total")
+
+	// 4. the endpoint ========================================================================================================
+	t.Log("4. GET /reports/profit")
+	useLedger(newTransactionLedger(), t)
+	ledger.transactions = transactions
+	respRecorder := serveRoute(newRouter(), "GET", "/reports/profit?from=2026-03-01&to=2026-03-31&granularity=month&tz=America/Chicago", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "4 report")
+	var report profitReport
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&report), t)
+	checkFigures(report.Total, 1512, 732, t, "4 total")
+
+	for _, bad := range []string{"?granularity=year", "?tz=Mars/Olympus_Mons", "?from=yesterday", "?from=2026-03-02&to=2026-03-01"} {
+		checkStatus(serveRoute(newRouter(), "GET", "/reports/profit"+bad, nil).Code, http.StatusBadRequest, t, "4 "+bad)
+	}
+}
+
+func TestProfitReportUncostedStock(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	router := newRouter()
+	peach := "E5T6-9UI3-TH15-QR88"
+
+	// 5 peaches come in without a cost and 2 more are bought at 1.50
+	checkStatus(serveRoute(router, "POST", "/inventory/"+peach+"/receive", stockRequest{Quantity: 5}).Code, http.StatusOK, t, "receive")
+	transactionReq(Transaction{Type: TransactionPurchase, Lines: []TransactionLine{{PID: peach, Quantity: 2, UnitPrice: 150}}}, http.StatusOK, t)
+	// the bought ones are sold first, the other 2 have no cost
+	transactionReq(Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: peach, Quantity: 4}}}, http.StatusOK, t)
+	// and a return gives back one of those, without taking a cost off that was never counted
+	transactionReq(Transaction{Type: TransactionReturn, Lines: []TransactionLine{{PID: peach, Quantity: 1}}}, http.StatusOK, t)
+
+	respRecorder := serveRoute(router, "GET", "/reports/profit", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "report")
+	var report profitReport
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&report), t)
+	expected := profitFigures{Revenue: 897, CostOfGoods: 300, Margin: 597, UncostedUnits: 1}
+	if report.Total != expected {
+		t.Errorf("total -- actual - %+v | expected - %+v", report.Total, expected)
+	}
+	if len(report.Items) != 1 || report.Items[0].profitFigures != expected {
+		t.Errorf("items -- actual - %+v | expected one with %+v", report.Items, expected)
+	}
+}
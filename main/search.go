@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// how many results a name search returns when no ?limit= is given
+const defaultSearchResults = 20
+
+// searchResult is one item found by a name search, Score is from 0 to 1 (1 being an exact match)
+// and Match says how the name matched: pid, exact, prefix, word, substring or fuzzy
+type searchResult struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"`
+	Match string  `json:"match"`
+}
+
+// every kind of match has its own band of scores so a better kind always ranks first, within a band
+// the more of the name the search value covers the higher the score ("apple" ranks "Apple Pie" above
+// "Apple Pie Filling"). Fuzzy matches score from 0 to 0.2, fewer typos scoring higher
+var matchBands = map[string]float64{
+	"exact":     1,
+	"prefix":    0.8,
+	"word":      0.6,
+	"substring": 0.4,
+}
+
+// scoreName rates how well name matches query, both already lower case. ok is false when they don't match at all
+func scoreName(name string, query string) (score float64, match string, ok bool) {
+	coverage := float64(utf8.RuneCountInString(query)) / float64(utf8.RuneCountInString(name))
+	switch {
+	case name == query:
+		return matchBands["exact"], "exact", true
+	case strings.HasPrefix(name, query):
+		match = "prefix"
+	case _hasWordPrefix(name, query):
+		match = "word"
+	case strings.Contains(name, query):
+		match = "substring"
+	default:
+		distance, ok := _fuzzyDistance(name, query)
+		if !ok {
+			return 0, "", false
+		}
+		// one typo in a short word counts for more than one in a long one
+		return 0.2 * (1 - float64(distance)/float64(utf8.RuneCountInString(query)+1)), "fuzzy", true
+	}
+	return matchBands[match] + 0.2*math.Min(coverage, 1), match, true
+}
+
+// _hasWordPrefix is true when a word of name other than the first starts with query ("apple" in "gala apple")
+func _hasWordPrefix(name string, query string) bool {
+	words := strings.Fields(name)
+	for i := 1; i < len(words); i++ {
+		if strings.HasPrefix(strings.Join(words[i:], " "), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// _fuzzyDistance is the smallest number of typos between query and the whole name or any one word of it.
+// A typo is a letter added, removed or changed. ok is false when there are more typos than
+// query can take: one for up to four letters, two for anything longer, none below three letters
+func _fuzzyDistance(name string, query string) (int, bool) {
+	allowed := 2
+	switch length := utf8.RuneCountInString(query); {
+	case length < 3:
+		return 0, false
+	case length <= 4:
+		allowed = 1
+	}
+
+	best := allowed + 1
+	for _, candidate := range append([]string{name}, strings.Fields(name)...) {
+		if distance := _editDistance(candidate, query, best); distance < best {
+			best = distance
+		}
+	}
+	return best, best <= allowed
+}
+
+// _editDistance is the Levenshtein distance between a and b, giving up (and returning limit)
+// as soon as it can't come in under limit
+func _editDistance(a string, b string, limit int) int {
+	ar, br := []rune(a), []rune(b)
+	if diff := len(ar) - len(br); diff >= limit || -diff >= limit {
+		return limit
+	}
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		rowBest := current[0]
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = _minInt(_minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			rowBest = _minInt(rowBest, current[j])
+		}
+		if rowBest >= limit {
+			return limit
+		}
+		previous, current = current, previous
+	}
+	return _minInt(previous[len(br)], limit)
+}
+
+func _minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// roundScore rounds a search score to three decimals, plenty to rank by and it keeps the JSON readable.
+// Both searches round before they sort so the order matches the scores they return
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// searchNames ranks every item whose name matches query, best first, and returns up to limit of them
+// ties go to the shorter name and then to the PID so the order never changes between calls
+func searchNames(items []Item, query string, limit int) []searchResult {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	results := []searchResult{}
+	if query == "" {
+		return results
+	}
+	for _, item := range items {
+		name := strings.ToLower(strings.Join(strings.Fields(item.Name), " "))
+		if score, match, ok := scoreName(name, query); ok {
+			results = append(results, searchResult{Item: item, Score: roundScore(score), Match: match})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Item.Name) != len(b.Item.Name) {
+			return len(a.Item.Name) < len(b.Item.Name)
+		}
+		return foldKey(a.Item.PID) < foldKey(b.Item.PID)
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// searchReq sends GET /inventory/{searchValue}?mode=search and returns the results
+func searchReq(searchValue string, expStatus int, t *testing.T) []searchResult {
+	respRecorder := serveRoute(newRouter(), "GET", "/inventory/"+searchValue+"?mode=search", nil)
+	checkStatus(respRecorder.Code, expStatus, t, "searchReq "+searchValue)
+
+	var results []searchResult
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&results)
+		checkResponseError(err, respRecorder, "[]searchResult", t)
+	}
+	return results
+}
+
+func TestSearchNames(t *testing.T) {
+	useStore(newMemoryStore(append(defaultInventory(),
+		Item{PID: "P1E0-0000-0000-0001", Name: "Peach Pie", Price: 899},
+		Item{PID: "P1E0-0000-0000-0002", Name: "Apple Pie", Price: 799},
+	)), t)
+
+	// 1. each kind of match, best kind first ==================================================================================
+	t.Log("1. search by part of a name or with a typo")
+	cases := []struct {
+		search  string
+		names   []string
+		matches []string
+	}{
+		{"peach", []string{"Peach", "Peach Pie"}, []string{"exact", "prefix"}},
+		{"apple", []string{"Apple Pie", "Gala Apple"}, []string{"prefix", "word"}},
+		{"pep", []string{"Green Pepper"}, []string{"word"}},
+		{"ettuc", []string{"Lettuce"}, []string{"substring"}},
+		{"lettuse", []string{"Lettuce"}, []string{"fuzzy"}},
+		{"pech", []string{"Peach", "Peach Pie"}, []string{"fuzzy", "fuzzy"}},
+	}
+	for _, c := range cases {
+		results := searchReq(c.search, http.StatusOK, t)
+		var names, matches []string
+		for i, result := range results {
+			names = append(names, result.Item.Name)
+			matches = append(matches, result.Match)
+			if i > 0 && result.Score > results[i-1].Score {
+				t.Errorf("1 %v -- results aren't ranked by score: %+v", c.search, results)
+			}
+		}
+		checkNames(names, c.names, t, "1 "+c.search+" names")
+		checkNames(matches, c.matches, t, "1 "+c.search+" matches")
+	}
+
+	// 2. a PID is still looked up exactly, a search with nothing close is a 404 ================================================
+	t.Log("2. search by PID and for nothing")
+	results := searchReq("e5t6-9ui3-th15-qr88", http.StatusOK, t)
+	if len(results) != 1 || results[0].Match != "pid" || results[0].Item.Name != "Peach" {
+		t.Errorf("2 -- actual - %+v | expected only the peach, matched by pid", results)
+	}
+	searchReq("Durian", http.StatusNotFound, t)
+	searchReq("pe", http.StatusOK, t)
+	checkStatus(serveRoute(newRouter(), "GET", "/inventory/peach?mode=guess", nil).Code, http.StatusBadRequest, t, "2 bad mode")
+
+	// 3. the limit cuts off the worst matches ==================================================================================
+	t.Log("3. limit")
+	respRecorder := serveRoute(newRouter(), "GET", "/inventory/pie?mode=search&limit=1", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "3 limit")
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&results), t)
+	if len(results) != 1 {
+		t.Errorf("3 -- actual results - %+v | expected just 1", results)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+// stem reduces an English word to its stem with the Porter stemming algorithm
+// (https://tartarus.org/martin/PorterStemmer/), so "peppers" finds "pepper" and "organically" finds "organic".
+// The word has to be lower case already, anything that isn't plain a-z is left as it is
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	p := &porter{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.replaceSuffix(step2Suffixes)
+		p.replaceSuffix(step3Suffixes)
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porter is a word being stemmed, b[0..k] is what's left of it and j marks the end of the stem
+// while a suffix is being looked at, the same names as the original C version
+type porter struct {
+	b []byte
+	k int
+	j int
+}
+
+type suffixRule struct {
+	suffix      string
+	replacement string
+}
+
+var step2Suffixes = []suffixRule{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"},
+	{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"},
+	{"fulness", "ful"}, {"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+	{"logi", "log"},
+}
+
+var step3Suffixes = []suffixRule{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var step4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent",
+	"ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// cons is true when b[i] is a consonant, y is one unless it follows a consonant
+func (p *porter) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m counts the vowel-consonant sequences in b[0..j], <c>vc<v> is 1 and <c>vcvc<v> is 2
+func (p *porter) m() int {
+	n, i := 0, 0
+	for ; i <= p.j && p.cons(i); i++ {
+	}
+	for i <= p.j {
+		for ; i <= p.j && !p.cons(i); i++ {
+		}
+		if i > p.j {
+			break
+		}
+		n++
+		for ; i <= p.j && p.cons(i); i++ {
+		}
+	}
+	return n
+}
+
+func (p *porter) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleCons is true when b[j-1..j] is the same consonant twice
+func (p *porter) doubleCons(j int) bool {
+	return j >= 1 && p.b[j] == p.b[j-1] && p.cons(j)
+}
+
+// cvc is true when b[i-2..i] is consonant, vowel, consonant and the last one isn't w, x or y,
+// which is how short words like hop and fil end before an e is put back
+func (p *porter) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	return p.b[i] != 'w' && p.b[i] != 'x' && p.b[i] != 'y'
+}
+
+// ends is true when b[0..k] ends with s, j is then set to just before s
+func (p *porter) ends(s string) bool {
+	if len(s) > p.k+1 || string(p.b[p.k-len(s)+1:p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - len(s)
+	return true
+}
+
+// setTo replaces b[j+1..k] with s
+func (p *porter) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = len(p.b) - 1
+}
+
+// replaceSuffix swaps the first of the rules' suffixes that the word ends with, as long as there's
+// a vowel-consonant sequence left before it. Steps 2 and 3 are nothing but this
+func (p *porter) replaceSuffix(rules []suffixRule) {
+	for _, rule := range rules {
+		if p.ends(rule.suffix) {
+			if p.m() > 0 {
+				p.setTo(rule.replacement)
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing: caresses -> caress, ponies -> poni, hopping -> hop, filing -> file
+func (p *porter) step1ab() {
+	if p.b[p.k] == 's' {
+		if p.ends("sses") {
+			p.k -= 2
+		} else if p.ends("ies") {
+			p.setTo("i")
+		} else if p.b[p.k-1] != 's' {
+			p.k--
+		}
+	}
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+	} else if (p.ends("ed") || p.ends("ing")) && p.vowelInStem() {
+		p.k = p.j
+		switch {
+		case p.ends("at"):
+			p.setTo("ate")
+		case p.ends("bl"):
+			p.setTo("ble")
+		case p.ends("iz"):
+			p.setTo("ize")
+		case p.doubleCons(p.k):
+			if c := p.b[p.k]; c != 'l' && c != 's' && c != 'z' {
+				p.k--
+			}
+		default:
+			p.j = p.k
+			if p.m() == 1 && p.cvc(p.k) {
+				p.setTo("e")
+			}
+		}
+	}
+	p.b = p.b[:p.k+1]
+}
+
+// step1c turns a final y into i when there is another vowel in the stem: happy -> happi
+func (p *porter) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// step4 takes off -ant, -ence and the like when the stem is long enough: adjustment -> adjust
+func (p *porter) step4() {
+	for _, suffix := range step4Suffixes {
+		if p.ends(suffix) {
+			// -ion only comes off after an s or a t: adoption -> adopt but not onion -> on
+			if suffix == "ion" && (p.j < 0 || (p.b[p.j] != 's' && p.b[p.j] != 't')) {
+				return
+			}
+			if p.m() > 1 {
+				p.k = p.j
+			}
+			return
+		}
+	}
+}
+
+// step5 takes off a final -e and turns -ll into -l when the stem is long enough: probate -> probat, controll -> control
+func (p *porter) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		if m := p.m(); m > 1 || (m == 1 && !p.cvc(p.k-1)) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doubleCons(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
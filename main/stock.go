@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// stockRequest is the body of every stock endpoint
+// receive and sell use Quantity, adjust uses Delta and Reason
+type stockRequest struct {
+	Quantity int    `json:"quantity"`
+	Delta    int    `json:"delta"`
+	Reason   string `json:"reason"`
+}
+
+// adjustReasons are the reason codes an employee can give when correcting stock by hand
+var adjustReasons = map[string]bool{
+	"damaged":  true,
+	"expired":  true,
+	"theft":    true,
+	"recount":  true,
+	"returned": true,
+}
+
+// suppliers dropping off a shipment add to the quantity on hand
+func receiveStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: receiveStock()")
+
+	var req stockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Quantity <= 0 {
+		_writeError(w, "receiveStock", http.StatusBadRequest, codeValidationFailed, "Please provide a JSON object with a positive 'quantity'.",
+			fieldError{Field: "quantity", Message: "has to be a positive number"})
+		return
+	}
+	_changeStock(w, r, "receiveStock", "quantity", req.Quantity)
+}
+
+// every sale takes from the quantity on hand, we can't sell what we don't have
+func sellStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: sellStock()")
+
+	var req stockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Quantity <= 0 {
+		_writeError(w, "sellStock", http.StatusBadRequest, codeValidationFailed, "Please provide a JSON object with a positive 'quantity'.",
+			fieldError{Field: "quantity", Message: "has to be a positive number"})
+		return
+	}
+	_changeStock(w, r, "sellStock", "quantity", -req.Quantity)
+}
+
+// employees correct the quantity by hand (spoiled produce, a recount, etc.)
+// the delta can go either way but a reason code from adjustReasons is required
+func adjustStock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: adjustStock()")
+
+	var req stockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	var problems []fieldError
+	if err != nil || req.Delta == 0 {
+		problems = append(problems, fieldError{Field: "delta", Message: "has to be a non-zero number"})
+	}
+	if err != nil || !adjustReasons[req.Reason] {
+		problems = append(problems, fieldError{Field: "reason", Message: "has to be damaged, expired, theft, recount or returned"})
+	}
+	if len(problems) > 0 {
+		_writeError(w, "adjustStock", http.StatusBadRequest, codeValidationFailed,
+			"Please provide a JSON object with a non-zero 'delta' and a 'reason' of damaged, expired, theft, recount or returned.", problems...)
+		return
+	}
+	log.Printf("adjusting stock of %v by %v -- reason: %v", mux.Vars(r)["pid"], req.Delta, req.Reason)
+	_changeStock(w, r, "adjustStock", "delta", req.Delta)
+}
+
+// _changeStock adds delta to the quantity of the item in the URL and responds with the updated item
+// a delta that would leave the quantity below zero is refused with a 409 Conflict,
+// one that would take it past what an int can hold is a 400 on field (see _addStock)
+func _changeStock(w http.ResponseWriter, r *http.Request, caller string, field string, delta int) {
+	pid := mux.Vars(r)["pid"]
+
+	item, err := _auditedStore(w, r).Modify(pid, _liveOnly(func(item *Item) error {
+		return _addStock(item, delta, field)
+	}))
+	if err != nil {
+		_writeStoreError(w, caller, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(item)
+}
+
+// _addStock adds delta to the quantity of item. The quantity is never negative so only a positive
+// delta can overflow it, that is checked before adding instead of after it has already wrapped around
+func _addStock(item *Item, delta int, field string) error {
+	if delta > 0 && delta > math.MaxInt-item.Quantity {
+		return fieldErrors{{Field: field, Message: fmt.Sprintf("would take the quantity on hand past %v", math.MaxInt)}}
+	}
+	if item.Quantity+delta < 0 {
+		return ErrInsufficientStock
+	}
+	item.Quantity += delta
+	return nil
+}
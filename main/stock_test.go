@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// stockReq sends a stock request through the router, checks the status and returns the item when it succeeded
+func stockReq(action string, pid string, body stockRequest, expStatus int, t *testing.T) Item {
+	respRecorder := serveRoute(newRouter(), "POST", "/inventory/"+pid+"/"+action, body)
+	checkStatus(respRecorder.Code, expStatus, t, action+"Req")
+
+	var item Item
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&item)
+		checkResponseError(err, respRecorder, "Item", t)
+	}
+	return item
+}
+
+func TestStock(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	peach := "E5T6-9UI3-TH15-QR88"
+
+	// 1. a supplier drops off 40 peaches ===================================================================================
+	t.Log("1. receive 40 peaches")
+	if item := stockReq("receive", peach, stockRequest{Quantity: 40}, http.StatusOK, t); item.Quantity != 40 {
+		t.Errorf("1 -- actual quantity - %v | expected - %v", item.Quantity, 40)
+	}
+
+	// 2. we sell 15 of them =================================================================================================
+	t.Log("2. sell 15 peaches")
+	if item := stockReq("sell", peach, stockRequest{Quantity: 15}, http.StatusOK, t); item.Quantity != 25 {
+		t.Errorf("2 -- actual quantity - %v | expected - %v", item.Quantity, 25)
+	}
+
+	// 3. selling more than we have is a conflict and leaves the quantity alone ==============================================
+	t.Log("3. sell 26 peaches, 409")
+	stockReq("sell", peach, stockRequest{Quantity: 26}, http.StatusConflict, t)
+	if item, _ := store.Get(peach); item.Quantity != 25 {
+		t.Errorf("3 -- actual quantity - %v | expected - %v", item.Quantity, 25)
+	}
+
+	// 4. manual adjustments need a reason and can't go negative either ======================================================
+	t.Log("4. adjust peaches")
+	if item := stockReq("adjust", peach, stockRequest{Delta: -5, Reason: "damaged"}, http.StatusOK, t); item.Quantity != 20 {
+		t.Errorf("4 -- actual quantity - %v | expected - %v", item.Quantity, 20)
+	}
+	if item := stockReq("adjust", peach, stockRequest{Delta: 3, Reason: "recount"}, http.StatusOK, t); item.Quantity != 23 {
+		t.Errorf("4 -- actual quantity - %v | expected - %v", item.Quantity, 23)
+	}
+	stockReq("adjust", peach, stockRequest{Delta: -24, Reason: "theft"}, http.StatusConflict, t)
+	stockReq("adjust", peach, stockRequest{Delta: -1}, http.StatusBadRequest, t)
+	stockReq("adjust", peach, stockRequest{Delta: -1, Reason: "ate it"}, http.StatusBadRequest, t)
+
+	// 5. bad quantities and unknown PIDs ====================================================================================
+	t.Log("5. bad stock requests")
+	stockReq("receive", peach, stockRequest{Quantity: 0}, http.StatusBadRequest, t)
+	stockReq("sell", peach, stockRequest{Quantity: -3}, http.StatusBadRequest, t)
+	stockReq("receive", "Th1s-P1Dd-N0t3-X1ST", stockRequest{Quantity: 1}, http.StatusNotFound, t)
+
+	// 6. receiving more than an int can count is a bad quantity, not a shortage ===============================================
+	t.Log("6. receive and adjust past the largest quantity")
+	respRecorder := serveRoute(newRouter(), "POST", "/inventory/"+peach+"/receive", stockRequest{Quantity: math.MaxInt})
+	details := decodeAPIError(respRecorder, codeValidationFailed, t, "6 receive").Details
+	if len(details) != 1 || details[0].Field != "quantity" {
+		t.Errorf("6 -- actual details - %+v | expected one for the quantity", details)
+	}
+	respRecorder = serveRoute(newRouter(), "POST", "/inventory/"+peach+"/adjust", stockRequest{Delta: math.MaxInt - 22, Reason: "recount"})
+	details = decodeAPIError(respRecorder, codeValidationFailed, t, "6 adjust").Details
+	if len(details) != 1 || details[0].Field != "delta" {
+		t.Errorf("6 -- actual details - %+v | expected one for the delta", details)
+	}
+	if item, _ := store.Get(peach); item.Quantity != 23 {
+		t.Errorf("6 -- actual quantity - %v | expected - %v", item.Quantity, 23)
+	}
+	if item := stockReq("adjust", peach, stockRequest{Delta: math.MaxInt - 23, Reason: "recount"}, http.StatusOK, t); item.Quantity != math.MaxInt {
+		t.Errorf("6 -- actual quantity - %v | expected - %v", item.Quantity, math.MaxInt)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is everything the handlers need from the inventory's storage.
+// The handlers only ever talk to the package level 'store' variable, so swapping
+// the in-memory implementation for a durable one is a one line change in main()
+type Store interface {
+	// Get returns the item with the given PID (case-insensitive)
+	Get(pid string) (Item, error)
+	// List returns a copy of the inventory in insertion order
+	List() []Item
+	// Add appends one item, failing with ErrDuplicatePID if the PID is taken
+	Add(item Item) error
+	// AddBatch appends every item or none of them, and sets the Version of each of them to the one it was added as
+	AddBatch(items []Item) error
+	// Update replaces the item that has the same PID as the one given.
+	// The store picks the version of every item it saves, whatever Version the item comes with is ignored
+	Update(item Item) error
+	// Delete removes the item with the given PID (case-insensitive) for good and returns it as it was.
+	// Handlers only soft delete items (see deleted.go), this is for purging them
+	Delete(pid string) (Item, error)
+	// DeleteIf is Delete, unless check refuses the item. check sees the item with no other write in
+	// between, and whatever error it returns is returned with nothing deleted
+	DeleteIf(pid string, check func(item Item) error) (Item, error)
+	// Modify hands change a copy of the item with the given PID and saves whatever it
+	// leaves behind, all without letting another write in between. If change returns
+	// an error nothing is saved and that error is returned. The PID can't be changed
+	Modify(pid string, change func(item *Item) error) (Item, error)
+	// ModifyBatch is Modify for several items at once, change gets them in the order of pids
+	// and either every one of them is saved or none are
+	ModifyBatch(pids []string, change func(items []*Item) error) ([]Item, error)
+	// RecordTransaction is ModifyBatch that also saves tx, as change leaves it, in the same write.
+	// The stock a transaction moved and the transaction itself are saved together or not at all
+	RecordTransaction(pids []string, tx *Transaction, change func(items []*Item) error) ([]Item, error)
+	// Transactions returns every transaction saved with RecordTransaction, oldest first.
+	// The memory store has nothing to restart from so it returns none, the ledger keeps them
+	Transactions() []Transaction
+	// ListByTags returns the items, in inventory order, that have every one of the tags
+	// (matchAll) or at least one of them. Tags are looked up in an index, not by scanning.
+	// Like FindByName and SearchText it leaves out deleted items, Get and List still have them
+	ListByTags(tags []string, matchAll bool) []Item
+	// FindByName returns the first item, in inventory order, with the given name (case-insensitive)
+	FindByName(name string) (Item, error)
+	// SearchText returns up to limit items matching any word of query in their name, tags or
+	// description, ranked best first with BM25 (see fulltext.go)
+	SearchText(query string, limit int) []textResult
+	// History returns every revision of the item with the given PID (case-insensitive), oldest first,
+	// even once it has been purged. ErrItemNotFound means there never was such an item
+	History(pid string) ([]Revision, error)
+	// ListAsOf returns the inventory as it was just before at, deleted items and all, in inventory order
+	ListAsOf(at time.Time) []Item
+}
+
+var (
+	ErrItemNotFound      = errors.New("item not found")
+	ErrDuplicatePID      = errors.New("pid already exists")
+	ErrInsufficientStock = errors.New("not enough stock on hand")
+)
+
+// foldKey is how PIDs and names are compared everywhere, ignoring case
+// the indexes are keyed by it so a lookup never has to fold every item it passes
+func foldKey(value string) string {
+	return strings.ToUpper(value)
+}
+
+// samePID compares PIDs the same way every endpoint does, ignoring case
+func samePID(a string, b string) bool {
+	return foldKey(a) == foldKey(b)
+}
+
+// modifyOne is Modify for any store, in terms of its ModifyBatch
+func modifyOne(s Store, pid string, change func(item *Item) error) (Item, error) {
+	modified, err := s.ModifyBatch([]string{pid}, func(items []*Item) error {
+		return change(items[0])
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return modified[0], nil
+}
+
+// fileStore keeps the inventory in memory and rewrites a JSON file after every mutation.
+// The file is written to a temp file first and renamed over the old one, so a crash
+// mid-write leaves the previous inventory on disk rather than half a file.
+// The file holds the items, their history and the transactions that moved their stock.
+// Writers are serialized by mu so the file is always written in the order changes happened
+type fileStore struct {
+	mu           sync.Mutex
+	mem          *memoryStore
+	path         string
+	transactions []Transaction
+}
+
+// fileContents is what is in the file, files saved before the history and the transactions were kept hold just the items
+type fileContents struct {
+	Items        []Item        `json:"items"`
+	History      []Revision    `json:"history"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// openFileStore loads the inventory saved at path, if the file doesn't exist yet
+// it is created holding the seed items
+func openFileStore(path string, seed []Item) (*fileStore, error) {
+	s := &fileStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.mem = newMemoryStore(seed)
+		return s, s.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var contents fileContents
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &contents.Items)
+	} else {
+		err = json.Unmarshal(data, &contents)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mem = newMemoryStore(contents.Items)
+	// a file from before the history was kept starts it over from now
+	if contents.History != nil {
+		s.mem.loadHistory(contents.History)
+	}
+	s.transactions = contents.Transactions
+	return s, nil
+}
+
+func (s *fileStore) Get(pid string) (Item, error) {
+	return s.mem.Get(pid)
+}
+
+func (s *fileStore) List() []Item {
+	return s.mem.List()
+}
+
+func (s *fileStore) ListByTags(tags []string, matchAll bool) []Item {
+	return s.mem.ListByTags(tags, matchAll)
+}
+
+func (s *fileStore) FindByName(name string) (Item, error) {
+	return s.mem.FindByName(name)
+}
+
+func (s *fileStore) SearchText(query string, limit int) []textResult {
+	return s.mem.SearchText(query, limit)
+}
+
+func (s *fileStore) History(pid string) ([]Revision, error) {
+	return s.mem.History(pid)
+}
+
+func (s *fileStore) ListAsOf(at time.Time) []Item {
+	return s.mem.ListAsOf(at)
+}
+
+func (s *fileStore) Add(item Item) error {
+	return s.mutate(func() error { return s.mem.Add(item) })
+}
+
+func (s *fileStore) AddBatch(items []Item) error {
+	return s.mutate(func() error { return s.mem.AddBatch(items) })
+}
+
+func (s *fileStore) Update(item Item) error {
+	return s.mutate(func() error { return s.mem.Update(item) })
+}
+
+func (s *fileStore) Delete(pid string) (Item, error) {
+	return s.DeleteIf(pid, nil)
+}
+
+func (s *fileStore) DeleteIf(pid string, check func(item Item) error) (Item, error) {
+	var deleted Item
+	err := s.mutate(func() error {
+		var err error
+		deleted, err = s.mem.DeleteIf(pid, check)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *fileStore) Modify(pid string, change func(item *Item) error) (Item, error) {
+	return modifyOne(s, pid, change)
+}
+
+func (s *fileStore) ModifyBatch(pids []string, change func(items []*Item) error) ([]Item, error) {
+	var modified []Item
+	err := s.mutate(func() (err error) {
+		modified, err = s.mem.ModifyBatch(pids, change)
+		return err
+	})
+	return modified, err
+}
+
+func (s *fileStore) RecordTransaction(pids []string, tx *Transaction, change func(items []*Item) error) ([]Item, error) {
+	var modified []Item
+	err := s.mutate(func() (err error) {
+		if modified, err = s.mem.ModifyBatch(pids, change); err != nil {
+			return err
+		}
+		s.transactions = append(s.transactions, *tx)
+		return nil
+	})
+	return modified, err
+}
+
+func (s *fileStore) Transactions() []Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transaction(nil), s.transactions...)
+}
+
+// mutate applies change in memory and then writes it out, if the write fails the in-memory inventory,
+// its history and the transactions are rolled back so we never acknowledge something we didn't save
+func (s *fileStore) mutate(change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before, revisions, transactions := s.mem.List(), s.mem.revisionCount(), len(s.transactions)
+	if err := change(); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		s.mem.replace(before, revisions)
+		s.transactions = s.transactions[:transactions]
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) save() error {
+	transactions := s.transactions
+	if transactions == nil {
+		transactions = []Transaction{}
+	}
+	contents := fileContents{Items: s.mem.List(), History: s.mem.revisions(), Transactions: transactions}
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes data next to path, flushes it to disk and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// once the rename has happened this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory so files created or renamed inside it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exerciseStore walks a Store through every method, checking the results as it goes
+// any Store implementation should pass this exact sequence
+func exerciseStore(s Store, t *testing.T) {
+	pear := Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}
+	orange := Item{PID: "0RNG-0000-0000-0002", Name: "Orange", Price: 89}
+
+	if err := s.Add(pear); err != nil {
+		t.Fatalf("Add -- unexpected error: %v", err)
+	}
+	if err := s.Add(pear); !errors.Is(err, ErrDuplicatePID) {
+		t.Errorf("Add -- expected ErrDuplicatePID for a repeated PID, got: %v", err)
+	}
+
+	// the whole batch is rejected when one of its items repeats another
+	if err := s.AddBatch([]Item{orange, orange}); !errors.Is(err, ErrDuplicatePID) {
+		t.Errorf("AddBatch -- expected ErrDuplicatePID for a repeated PID in the batch, got: %v", err)
+	}
+	if _, err := s.Get(orange.PID); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("AddBatch -- a rejected batch should not add anything, got: %v", err)
+	}
+	if err := s.AddBatch([]Item{orange}); err != nil {
+		t.Fatalf("AddBatch -- unexpected error: %v", err)
+	}
+
+	pear.Price = 150
+	if err := s.Update(pear); err != nil {
+		t.Fatalf("Update -- unexpected error: %v", err)
+	}
+	if got, _ := s.Get("p3ar-0000-0000-0001"); !sameItem(got, pear) {
+		t.Errorf("Get -- actual - %v | expected - %v", got, pear)
+	}
+	if err := s.Update(Item{PID: "N0NE-0000-0000-0000"}); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Update -- expected ErrItemNotFound, got: %v", err)
+	}
+
+	// names are found regardless of case, and a renamed item is only found by its new name
+	if got, err := s.FindByName("PEAR"); err != nil || !sameItem(got, pear) {
+		t.Errorf("FindByName -- actual - %v, %v | expected - %v", got, err, pear)
+	}
+	pear.Name = "Bosc Pear"
+	checkError(s.Update(pear), t)
+	if _, err := s.FindByName("pear"); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("FindByName -- expected ErrItemNotFound for the old name, got: %v", err)
+	}
+	if got, err := s.FindByName("bosc pear"); err != nil || !sameItem(got, pear) {
+		t.Errorf("FindByName -- actual - %v, %v | expected - %v", got, err, pear)
+	}
+
+	// Modify saves what change leaves behind, unless change fails
+	modified, err := s.Modify(pear.PID, func(item *Item) error {
+		item.Quantity = 12
+		item.PID = "not allowed"
+		return nil
+	})
+	pear.Quantity = 12
+	if err != nil || !sameItem(modified, pear) {
+		t.Errorf("Modify -- actual - %v, %v | expected - %v", modified, err, pear)
+	}
+	_, err = s.Modify(pear.PID, func(item *Item) error {
+		item.Quantity = 0
+		return ErrInsufficientStock
+	})
+	if got, _ := s.Get(pear.PID); !errors.Is(err, ErrInsufficientStock) || !sameItem(got, pear) {
+		t.Errorf("Modify -- a failed change should save nothing: actual - %v, %v | expected - %v", got, err, pear)
+	}
+	if _, err := s.Modify("N0NE-0000-0000-0000", func(item *Item) error { return nil }); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Modify -- expected ErrItemNotFound, got: %v", err)
+	}
+
+	// ModifyBatch saves every item or none of them
+	_, err = s.ModifyBatch([]string{pear.PID, orange.PID}, func(items []*Item) error {
+		items[0].Quantity = 1
+		return ErrInsufficientStock
+	})
+	if got, _ := s.Get(pear.PID); !errors.Is(err, ErrInsufficientStock) || !sameItem(got, pear) {
+		t.Errorf("ModifyBatch -- a failed change should save nothing: actual - %v, %v | expected - %v", got, err, pear)
+	}
+	if _, err := s.ModifyBatch([]string{pear.PID, "N0NE-0000-0000-0000"}, func(items []*Item) error { return nil }); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("ModifyBatch -- expected ErrItemNotFound, got: %v", err)
+	}
+	if _, err := s.ModifyBatch([]string{pear.PID, "p3ar-0000-0000-0001"}, func(items []*Item) error { return nil }); !errors.Is(err, ErrDuplicatePID) {
+		t.Errorf("ModifyBatch -- expected ErrDuplicatePID, got: %v", err)
+	}
+	batch, err := s.ModifyBatch([]string{orange.PID, pear.PID}, func(items []*Item) error {
+		items[0].Quantity = 3
+		items[1].Quantity = 4
+		return nil
+	})
+	orange.Quantity, pear.Quantity = 3, 4
+	if err != nil || len(batch) != 2 || !sameItem(batch[0], orange) || !sameItem(batch[1], pear) {
+		t.Errorf("ModifyBatch -- actual - %v, %v | expected - %v", batch, err, []Item{orange, pear})
+	}
+
+	deleted, err := s.Delete(pear.PID)
+	if err != nil {
+		t.Fatalf("Delete -- unexpected error: %v", err)
+	}
+	if !sameItem(deleted, pear) {
+		t.Errorf("Delete -- actual deleted item - %v | expected - %v", deleted, pear)
+	}
+	if _, err := s.Delete(pear.PID); !errors.Is(err, ErrItemNotFound) {
+		t.Errorf("Delete -- expected ErrItemNotFound, got: %v", err)
+	}
+
+	items := s.List()
+	if len(items) != 1 || !sameItem(items[0], orange) {
+		t.Errorf("List -- actual - %v | expected - %v", items, []Item{orange})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	exerciseStore(newMemoryStore(nil), t)
+}
+
+// deleting most of the inventory squeezes out the empty slots, nothing should move out of order or get lost
+func TestMemoryStoreCompacts(t *testing.T) {
+	items := benchmarkInventory(100)
+	s := newMemoryStore(items)
+	var kept []Item
+	for i, item := range items {
+		if i%10 == 3 {
+			kept = append(kept, item)
+			continue
+		}
+		_, err := s.Delete(item.PID)
+		checkError(err, t)
+	}
+	if len(s.slots) > 2*len(kept) {
+		t.Errorf("expected the empty slots to be compacted, %v slots hold %v items", len(s.slots), len(kept))
+	}
+	compareActualWithExpected(s.List(), kept, t, "compacted memory store")
+	for _, item := range kept {
+		if got, err := s.FindByName(item.Name); err != nil || !sameItem(got, item) {
+			t.Errorf("FindByName -- actual - %v, %v | expected - %v", got, err, item)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	s, err := openFileStore(path, nil)
+	checkError(err, t)
+	exerciseStore(s, t)
+
+	// reopening the file should give back exactly what we left behind
+	reopened, err := openFileStore(path, defaultInventory())
+	checkError(err, t)
+	compareActualWithExpected(reopened.List(), s.List(), t, "reopened file store")
+}
+
+func TestFileStoreSeedsNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+
+	s, err := openFileStore(path, defaultInventory())
+	checkError(err, t)
+	compareActualWithExpected(s.List(), defaultInventory(), t, "seeded file store")
+
+	reopened, err := openFileStore(path, nil)
+	checkError(err, t)
+	compareActualWithExpected(reopened.List(), defaultInventory(), t, "reopened seeded file store")
+}
+
+func TestFileStoreKeepsTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	s, err := openFileStore(path, defaultInventory())
+	checkError(err, t)
+	l := recordForRestart(s, t)
+
+	reopened, err := openFileStore(path, nil)
+	checkError(err, t)
+	checkReloadedLedger(reopened, l, t)
+}
+
+func TestFileStoreReadsItemsOnlyFile(t *testing.T) {
+	// files saved before the transactions were kept are just the array of items
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	data, err := json.Marshal(defaultInventory())
+	checkError(err, t)
+	checkError(os.WriteFile(path, data, 0o644), t)
+
+	s, err := openFileStore(path, nil)
+	checkError(err, t)
+	compareActualWithExpected(s.List(), defaultInventory(), t, "items only file")
+	if transactions := s.Transactions(); len(transactions) != 0 {
+		t.Errorf("items only file -- actual transactions - %v | expected none", transactions)
+	}
+}
+
+// benchmarkInventory makes n distinct items with well formed PIDs
+func benchmarkInventory(n int) []Item {
+	items := make([]Item, n)
+	for i := range items {
+		items[i] = Item{
+			PID:   fmt.Sprintf("BNCH-%04d-%04d-0000", i/10000, i%10000),
+			Name:  fmt.Sprintf("Item %d", i),
+			Price: 100,
+		}
+	}
+	return items
+}
+
+func BenchmarkGetByPID(b *testing.B) {
+	items := benchmarkInventory(100000)
+	s := newMemoryStore(items)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Get(items[i%len(items)].PID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindByName(b *testing.B) {
+	items := benchmarkInventory(100000)
+	s := newMemoryStore(items)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.FindByName(items[i%len(items)].Name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAddBatch adds 100k items to an empty store per iteration
+func BenchmarkAddBatch(b *testing.B) {
+	items := benchmarkInventory(100000)
+	for i := 0; i < b.N; i++ {
+		if err := newMemoryStore(nil).AddBatch(items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAddItems sends 100k items through POST /inventory/addItems, validation included
+func BenchmarkAddItems(b *testing.B) {
+	items := benchmarkInventory(100000)
+	router := newRouter()
+	for i := 0; i < b.N; i++ {
+		// a fresh trail too, or every iteration appends to the 100k events of the ones before
+		useStore(newMemoryStore(defaultInventory()), b)
+		useAudit(newAuditLog(), b)
+		if code := serveRoute(router, "POST", "/inventory/addItems", items).Code; code != http.StatusOK {
+			b.Fatalf("addItems returned %v", code)
+		}
+	}
+}
+
+// BenchmarkDelete deletes every item of a 100k inventory, one at a time, per iteration
+func BenchmarkDelete(b *testing.B) {
+	items := benchmarkInventory(100000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := newMemoryStore(items)
+		b.StartTimer()
+		for _, item := range items {
+			if _, err := s.Delete(item.PID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Supplier is a company we buy stock from, purchase orders are made out to one
+type Supplier struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// supplierRegistry is every supplier we know, in the order they were added. It isn't saved anywhere,
+// after a restart the suppliers have to be registered again and get new IDs.
+// The IDs are random rather than counted, supplier keys are saved in -keys and one made for a supplier
+// before a restart must never match whoever is registered first after it
+type supplierRegistry struct {
+	mu        sync.RWMutex
+	suppliers []Supplier
+}
+
+// suppliers is the registry behind the /suppliers handlers, purchase orders are checked against it too
+var suppliers = newSupplierRegistry()
+
+func newSupplierRegistry() *supplierRegistry {
+	return &supplierRegistry{}
+}
+
+// add gives supplier an ID and keeps it, two suppliers can't have the same name (case-insensitive)
+func (s *supplierRegistry) add(supplier Supplier) (Supplier, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.suppliers {
+		if strings.EqualFold(other.Name, supplier.Name) {
+			return Supplier{}, fieldErrors{{Field: "name", Message: "already belongs to supplier " + other.ID}}
+		}
+	}
+	id := make([]byte, 6)
+	for supplier.ID == "" || s.has(supplier.ID) {
+		if _, err := rand.Read(id); err != nil {
+			return Supplier{}, err
+		}
+		supplier.ID = "SUP-" + strings.ToUpper(hex.EncodeToString(id))
+	}
+	supplier.CreatedAt = time.Now().UTC()
+	s.suppliers = append(s.suppliers, supplier)
+	return supplier, nil
+}
+
+// get returns the supplier with the given ID (case-insensitive)
+func (s *supplierRegistry) get(id string) (Supplier, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, supplier := range s.suppliers {
+		if strings.EqualFold(supplier.ID, id) {
+			return supplier, true
+		}
+	}
+	return Supplier{}, false
+}
+
+// has is whether a supplier has the given ID, the caller holds the lock
+func (s *supplierRegistry) has(id string) bool {
+	for _, supplier := range s.suppliers {
+		if strings.EqualFold(supplier.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns a copy of every supplier
+func (s *supplierRegistry) list() []Supplier {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Supplier{}, s.suppliers...)
+}
+
+// _checkSupplier validates a new supplier, it tidies up the whitespace on the way
+func _checkSupplier(supplier *Supplier) []fieldError {
+	supplier.Name = strings.TrimSpace(supplier.Name)
+	supplier.Email = strings.TrimSpace(supplier.Email)
+	supplier.Phone = strings.TrimSpace(supplier.Phone)
+	var problems []fieldError
+	if supplier.Name == "" {
+		problems = append(problems, fieldError{Field: "name", Message: "is required"})
+	}
+	if supplier.Email != "" && (!strings.Contains(supplier.Email, "@") || strings.ContainsAny(supplier.Email, " \t")) {
+		problems = append(problems, fieldError{Field: "email", Message: "has to be an email address"})
+	}
+	return problems
+}
+
+// employees register a supplier before they order from them
+func createSupplier(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: createSupplier()")
+
+	var supplier Supplier
+	if err := json.NewDecoder(r.Body).Decode(&supplier); err != nil {
+		_writeError(w, "createSupplier", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the supplier received. Please provide a JSON object with a 'name'.", _decodeProblem(err))
+		return
+	}
+	if problems := _checkSupplier(&supplier); len(problems) > 0 {
+		_writeError(w, "createSupplier", http.StatusBadRequest, codeValidationFailed, "The supplier has invalid fields.", problems...)
+		return
+	}
+	supplier, err := suppliers.add(supplier)
+	var problems fieldErrors
+	if errors.As(err, &problems) {
+		_writeError(w, "createSupplier", http.StatusBadRequest, codeValidationFailed, "The supplier has invalid fields.", problems...)
+		return
+	}
+	if err != nil {
+		_writeError(w, "createSupplier", http.StatusInternalServerError, codeInternal, "Could not register the supplier: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(supplier)
+}
+
+func getSuppliers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getSuppliers()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(suppliers.list())
+}
+
+func getSupplier(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getSupplier()")
+
+	id := mux.Vars(r)["id"]
+	supplier, found := suppliers.get(id)
+	if !found {
+		_writeError(w, "getSupplier", http.StatusNotFound, codeNotFound, "Could not find supplier: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(supplier)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// a tag is lower case letters and numbers, words joined by single dashes (gluten-free, grass-fed)
+var tagRegex = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+var errTagNotFound = errors.New("item doesn't have that tag")
+
+// tagsRequest is the body of POST /inventory/{pid}/tags
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// normalizeTags lower-cases and trims every tag, sorts them and drops repeats, so "Organic"
+// and " organic" are the same tag everywhere. No tags comes back as nil
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("%q is not a valid tag", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// employees attach qualities to an item, tags it already has are left alone
+// it returns the updated item
+func addTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: addTags()")
+
+	var req tagsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		req.Tags, err = normalizeTags(req.Tags)
+	}
+	if err != nil || len(req.Tags) == 0 {
+		problem := fieldError{Field: "tags", Message: "has to list at least one tag"}
+		if err != nil {
+			problem.Message = err.Error()
+		}
+		_writeError(w, "addTags", http.StatusBadRequest, codeValidationFailed,
+			"Please provide a JSON object with 'tags', a list of tags made of letters, numbers and dashes (e.g. gluten-free).", problem)
+		return
+	}
+
+	item, err := _auditedStore(w, r).Modify(mux.Vars(r)["pid"], _liveOnly(func(item *Item) error {
+		tags, err := normalizeTags(append(item.Tags, req.Tags...))
+		item.Tags = tags
+		return err
+	}))
+	if err != nil {
+		_writeStoreError(w, "addTags", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(item)
+}
+
+// employees take a quality off an item, it returns the updated item
+func removeTag(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: removeTag()")
+
+	params := mux.Vars(r)
+	tag := strings.ToLower(strings.TrimSpace(params["tag"]))
+
+	item, err := _auditedStore(w, r).Modify(params["pid"], _liveOnly(func(item *Item) error {
+		for i, existing := range item.Tags {
+			if existing == tag {
+				item.Tags = append(item.Tags[:i], item.Tags[i+1:]...)
+				if len(item.Tags) == 0 {
+					item.Tags = nil
+				}
+				return nil
+			}
+		}
+		return errTagNotFound
+	}))
+	if errors.Is(err, errTagNotFound) {
+		_writeError(w, "removeTag", http.StatusNotFound, codeNotFound, "The item doesn't have the tag: "+tag)
+		return
+	} else if err != nil {
+		_writeStoreError(w, "removeTag", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(item)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// filterInventoryReq sends GET /inventory with the given query and returns the names of the items that come back
+func filterInventoryReq(query string, expStatus int, t *testing.T) []string {
+	respRecorder := serveRoute(newRouter(), "GET", "/inventory"+query, nil)
+	checkStatus(respRecorder.Code, expStatus, t, "filterInventoryReq "+query)
+
+	names := []string{}
+	if expStatus == http.StatusOK {
+		var items []Item
+		err := json.NewDecoder(respRecorder.Body).Decode(&items)
+		checkResponseError(err, respRecorder, "[]Item", t)
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}
+
+// checkNames logs an error when the names aren't exactly the expected ones, in order
+func checkNames(actual []string, expected []string, t *testing.T, checkpoint string) {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%v -- actual - %v | expected - %v", checkpoint, actual, expected)
+	}
+}
+
+func TestTags(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router := newRouter()
+	lettuce, peach, apple := "A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88", "TQ4C-VV6T-75ZX-1RMR"
+
+	// 1. new items can come with tags, which are normalized ================================================================
+	t.Log("1. add a tagged item")
+	kale := Item{PID: "KA1E-0000-0000-0001", Name: "Kale", Price: 250, Tags: []string{"Organic", " gluten-free", "organic"}}
+	addItemReq(kale, t)
+	stored, _ := store.Get(kale.PID)
+	checkNames(stored.Tags, []string{"gluten-free", "organic"}, t, "1")
+
+	// 2. tags are added to existing items ===================================================================================
+	t.Log("2. tag lettuce, peach and apple")
+	for pid, tags := range map[string][]string{lettuce: {"organic"}, peach: {"organic", "local"}, apple: {"local"}} {
+		respRecorder := serveRoute(router, "POST", "/inventory/"+pid+"/tags", tagsRequest{Tags: tags})
+		checkStatus(respRecorder.Code, http.StatusOK, t, "2 addTags")
+	}
+	checkStatus(serveRoute(router, "POST", "/inventory/"+peach+"/tags", tagsRequest{Tags: []string{"not a tag!"}}).Code,
+		http.StatusBadRequest, t, "2 bad tag")
+	checkStatus(serveRoute(router, "POST", "/inventory/Th1s-P1Dd-N0t3-X1ST/tags", tagsRequest{Tags: []string{"local"}}).Code,
+		http.StatusNotFound, t, "2 unknown item")
+
+	// 3. filter with AND and OR, results in inventory order ==================================================================
+	t.Log("3. filter by tags")
+	checkNames(filterInventoryReq("?tag=organic", http.StatusOK, t), []string{"Lettuce", "Peach", "Kale"}, t, "3 organic")
+	checkNames(filterInventoryReq("?tag=organic&tag=LOCAL", http.StatusOK, t), []string{"Peach"}, t, "3 organic and local")
+	checkNames(filterInventoryReq("?tag=gluten-free&tag=local&match=any", http.StatusOK, t), []string{"Peach", "Gala Apple", "Kale"}, t, "3 gluten-free or local")
+	checkNames(filterInventoryReq("?tag=grass-fed", http.StatusOK, t), []string{}, t, "3 nobody")
+	filterInventoryReq("?tag=organic&match=some", http.StatusBadRequest, t)
+	filterInventoryReq("?tag=$$$", http.StatusBadRequest, t)
+
+	// 4. the index follows removals, updates and deletes =====================================================================
+	t.Log("4. remove a tag, patch tags and delete an item")
+	checkStatus(serveRoute(router, "DELETE", "/inventory/"+peach+"/tags/organic", nil).Code, http.StatusOK, t, "4 removeTag")
+	checkStatus(serveRoute(router, "DELETE", "/inventory/"+peach+"/tags/organic", nil).Code, http.StatusNotFound, t, "4 removeTag again")
+	checkStatus(serveRoute(router, "PATCH", "/inventory/"+apple, map[string]interface{}{"tags": []string{"Organic"}}).Code,
+		http.StatusOK, t, "4 patch tags")
+	deleteItemReq(lettuce, t)
+	checkNames(filterInventoryReq("?tag=organic", http.StatusOK, t), []string{"Gala Apple", "Kale"}, t, "4 organic")
+	checkNames(filterInventoryReq("?tag=local", http.StatusOK, t), []string{"Peach"}, t, "4 local")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// signed tokens are JSON Web Tokens (RFC 7519) we sign ourselves, either with a shared HMAC secret
+// (HS256) or an Ed25519 key (EdDSA). A client trades its API key for one at POST /auth/tokens and
+// sends that instead, so the long lived key doesn't have to travel with every request
+const (
+	algHMAC    = "HS256"
+	algEd25519 = "EdDSA"
+	// defaultTokenTTL is how long a token lasts when the request doesn't say
+	defaultTokenTTL = 15 * time.Minute
+)
+
+// tokenClaims is the payload of a token. KeyID ties it to the API key it was issued for,
+// revoking the key stops the token as well
+type tokenClaims struct {
+	Subject   string       `json:"sub"`
+	Role      Role         `json:"role"`
+	Scopes    []Permission `json:"scope,omitempty"`
+	KeyID     string       `json:"kid"`
+	ID        string       `json:"jti"`
+	IssuedAt  int64        `json:"iat"`
+	ExpiresAt int64        `json:"exp"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// tokenSigner signs and checks tokens with one key. Only tokens with the signer's own alg are
+// accepted, so an HMAC token can never be passed off as an Ed25519 one or the other way around
+type tokenSigner struct {
+	alg        string
+	hmacSecret []byte
+	privateKey ed25519.PrivateKey
+	// maxTTL is the longest a client may ask a token to last
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// tokens signs the tokens handed out by issueToken, it stays nil unless main is given a
+// -token-secret or -token-key and then only API keys are accepted
+var tokens *tokenSigner
+
+var errInvalidToken = errors.New("invalid token")
+
+func newHMACSigner(secret []byte, maxTTL time.Duration) (*tokenSigner, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("the token secret has to be at least 32 bytes")
+	}
+	return &tokenSigner{alg: algHMAC, hmacSecret: secret, maxTTL: maxTTL, now: time.Now}, nil
+}
+
+func newEd25519Signer(key ed25519.PrivateKey, maxTTL time.Duration) *tokenSigner {
+	return &tokenSigner{alg: algEd25519, privateKey: key, maxTTL: maxTTL, now: time.Now}
+}
+
+// loadTokenSigner reads the -token-secret file (any bytes, at least 32 of them) or the -token-key file,
+// a PEM encoded Ed25519 private key like `openssl genpkey -algorithm ed25519` writes
+func loadTokenSigner(secretPath string, keyPath string, maxTTL time.Duration) (*tokenSigner, error) {
+	switch {
+	case secretPath != "" && keyPath != "":
+		return nil, errors.New("give -token-secret or -token-key, not both")
+	case secretPath != "":
+		secret, err := os.ReadFile(secretPath)
+		if err != nil {
+			return nil, err
+		}
+		return newHMACSigner([]byte(strings.TrimSpace(string(secret))), maxTTL)
+	case keyPath != "":
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%v isn't a PEM file", keyPath)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", keyPath, err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%v isn't an Ed25519 private key", keyPath)
+		}
+		return newEd25519Signer(key, maxTTL), nil
+	}
+	return nil, nil
+}
+
+func (s *tokenSigner) signature(data []byte) []byte {
+	if s.alg == algEd25519 {
+		return ed25519.Sign(s.privateKey, data)
+	}
+	mac := hmac.New(sha256.New, s.hmacSecret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (s *tokenSigner) validSignature(data []byte, signature []byte) bool {
+	if s.alg == algEd25519 {
+		return ed25519.Verify(s.privateKey.Public().(ed25519.PublicKey), data, signature)
+	}
+	return hmac.Equal(s.signature(data), signature)
+}
+
+// sign issues a token for principal that lasts ttl
+func (s *tokenSigner) sign(principal Principal, ttl time.Duration) (string, tokenClaims, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", tokenClaims{}, err
+	}
+	now := s.now()
+	claims := tokenClaims{
+		Subject: principal.ID, Role: principal.Role, Scopes: principal.Scopes, KeyID: principal.KeyID,
+		ID: hex.EncodeToString(id), IssuedAt: now.Unix(), ExpiresAt: now.Add(ttl).Unix(),
+	}
+	header, err := json.Marshal(tokenHeader{Alg: s.alg, Typ: "JWT"})
+	if err != nil {
+		return "", tokenClaims{}, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", tokenClaims{}, err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.signature([]byte(signed))), claims, nil
+}
+
+// verify checks the signature and expiry of token and that its API key is still active
+func (s *tokenSigner) verify(token string) (Principal, error) {
+	claims, err := s.parse(token)
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	key, active := keys.active(claims.KeyID)
+	if !active || key.Principal != claims.Subject || key.Role != claims.Role {
+		return Principal{}, ErrUnauthenticated
+	}
+	return Principal{ID: claims.Subject, Role: claims.Role, Scopes: claims.Scopes, KeyID: claims.KeyID, SupplierID: key.SupplierID, viaToken: true}, nil
+}
+
+func (s *tokenSigner) parse(token string) (tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return tokenClaims{}, errInvalidToken
+	}
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != s.alg {
+		return tokenClaims{}, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !s.validSignature([]byte(parts[0]+"."+parts[1]), signature) {
+		return tokenClaims{}, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return tokenClaims{}, errInvalidToken
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return tokenClaims{}, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// tokenRequest is the body of POST /auth/tokens, both fields can be left out.
+// ExpiresIn is in seconds, Scopes can only narrow down what the key can do
+type tokenRequest struct {
+	ExpiresIn int          `json:"expiresIn"`
+	Scopes    []Permission `json:"scopes"`
+}
+
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// a POS terminal or supplier integration trades its API key for a short lived token,
+// only a key can get a token, a token can't be used to get another one
+func issueToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: issueToken()")
+
+	if tokens == nil {
+		_writeError(w, "issueToken", http.StatusNotFound, codeNotFound, "Signed tokens aren't turned on, use your API key.")
+		return
+	}
+	principal, found := principalOf(r)
+	if !found || principal.viaToken {
+		_writeError(w, "issueToken", http.StatusForbidden, codeForbidden, "Only an API key can be traded for a token.")
+		return
+	}
+
+	request := tokenRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			_writeError(w, "issueToken", http.StatusBadRequest, codeInvalidJSON,
+				"Could not parse the token request. Please provide a JSON object, 'expiresIn' and 'scopes' are both optional.", _decodeProblem(err))
+			return
+		}
+	}
+	ttl := defaultTokenTTL
+	var problems []fieldError
+	if request.ExpiresIn != 0 {
+		ttl = time.Duration(request.ExpiresIn) * time.Second
+		if request.ExpiresIn < 0 || ttl > tokens.maxTTL {
+			problems = append(problems, fieldError{Field: "expiresIn",
+				Message: fmt.Sprintf("has to be from 1 to %d seconds", int(tokens.maxTTL/time.Second))})
+		}
+	}
+	if len(request.Scopes) > 0 {
+		for _, scope := range request.Scopes {
+			if !principal.can(scope) {
+				problems = append(problems, fieldError{Field: "scopes", Message: fmt.Sprintf("%v isn't something this key can do", scope)})
+			}
+		}
+		principal.Scopes = request.Scopes
+	}
+	if len(problems) > 0 {
+		_writeError(w, "issueToken", http.StatusBadRequest, codeValidationFailed, "The token request has invalid fields.", problems...)
+		return
+	}
+
+	token, claims, err := tokens.sign(principal, ttl)
+	if err != nil {
+		log.Printf("500 error - issueToken(): %v", err)
+		_writeError(w, "issueToken", http.StatusInternalServerError, codeInternal, "Could not sign a token, please try again.")
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(tokenResponse{Token: token, ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC()})
+}
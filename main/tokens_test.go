@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTokens points the middleware and issueToken at s for the rest of the test
+func useTokens(s *tokenSigner, t *testing.T) {
+	old := tokens
+	tokens = s
+	t.Cleanup(func() { tokens = old })
+}
+
+// issueTokenReq trades credential for a token through the router and returns the token
+func issueTokenReq(router http.Handler, credential string, request *tokenRequest, expStatus int, t *testing.T) string {
+	var body interface{}
+	if request != nil {
+		body = request
+	}
+	respRecorder := serveAs(router, credential, "POST", "/auth/tokens", body)
+	checkStatus(respRecorder.Code, expStatus, t, "issueTokenReq")
+
+	var response tokenResponse
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&response)
+		checkResponseError(err, respRecorder, "tokenResponse", t)
+	}
+	return response.Token
+}
+
+func TestSignedTokens(t *testing.T) {
+	hmacSigner, err := newHMACSigner([]byte(strings.Repeat("s", 32)), time.Hour)
+	checkError(err, t)
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	checkError(err, t)
+	for _, signer := range []*tokenSigner{hmacSigner, newEd25519Signer(private, time.Hour)} {
+		t.Run(signer.alg, func(t *testing.T) { exerciseTokens(signer, t) })
+	}
+
+	if _, err := newHMACSigner([]byte("short"), time.Hour); err == nil {
+		t.Errorf("newHMACSigner -- expected an error for a short secret")
+	}
+}
+
+func exerciseTokens(signer *tokenSigner, t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	router, keyOf := authRouter(t)
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return clock }
+	useTokens(signer, t)
+	peach := "/inventory/E5T6-9UI3-TH15-QR88"
+
+	// 1. an employee trades their key for a token and uses it ==================================================================
+	t.Log("1. issue and use a token")
+	token := issueTokenReq(router, keyOf[RoleEmployee], nil, http.StatusOK, t)
+	authReq(router, token, "GET", peach, http.StatusOK, t)
+	authReq(router, token, "GET", "/reports/profit", http.StatusForbidden, t)
+	// a token can't be used to get another token
+	issueTokenReq(router, token, nil, http.StatusForbidden, t)
+
+	// 2. scopes narrow a token down, they can't widen it ======================================================================
+	t.Log("2. a token only for reading the inventory")
+	readOnly := issueTokenReq(router, keyOf[RoleEmployee], &tokenRequest{Scopes: []Permission{PermInventoryRead}}, http.StatusOK, t)
+	authReq(router, readOnly, "GET", peach, http.StatusOK, t)
+	authReq(router, readOnly, "DELETE", peach, http.StatusForbidden, t)
+	issueTokenReq(router, keyOf[RoleEmployee], &tokenRequest{Scopes: []Permission{PermReportsRead}}, http.StatusBadRequest, t)
+	issueTokenReq(router, keyOf[RoleEmployee], &tokenRequest{ExpiresIn: 7200}, http.StatusBadRequest, t)
+
+	// 3. tampering, expiry and revoking the key all stop a token ===============================================================
+	t.Log("3. tampered, expired and revoked")
+	// the employee's token made out to an exec with the signature left as it was
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	checkError(err, t)
+	payload = []byte(strings.Replace(string(payload), `"role":"employee"`, `"role":"exec"`, 1))
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	authReq(router, forged, "GET", "/reports/profit", http.StatusUnauthorized, t)
+	authReq(router, parts[0]+"."+parts[1]+".", "GET", peach, http.StatusUnauthorized, t)
+
+	short := issueTokenReq(router, keyOf[RoleShopper], &tokenRequest{ExpiresIn: 60}, http.StatusOK, t)
+	authReq(router, short, "GET", peach, http.StatusOK, t)
+	clock = clock.Add(time.Minute)
+	authReq(router, short, "GET", peach, http.StatusUnauthorized, t)
+
+	for _, key := range keys.list() {
+		if key.Role == RoleEmployee {
+			authReq(router, keyOf[RoleAdmin], "DELETE", "/admin/keys/"+key.ID, http.StatusOK, t)
+		}
+	}
+	authReq(router, readOnly, "GET", peach, http.StatusUnauthorized, t)
+}
+
+func TestTokensOff(t *testing.T) {
+	router, keyOf := authRouter(t)
+	useTokens(nil, t)
+	issueTokenReq(router, keyOf[RoleEmployee], nil, http.StatusNotFound, t)
+	authReq(router, "a.b.c", "GET", "/inventory", http.StatusUnauthorized, t)
+}
+
+func TestTokenAlgorithmsDontMix(t *testing.T) {
+	router, keyOf := authRouter(t)
+	hmacSigner, err := newHMACSigner([]byte(strings.Repeat("s", 32)), time.Hour)
+	checkError(err, t)
+	useTokens(hmacSigner, t)
+	token := issueTokenReq(router, keyOf[RoleShopper], nil, http.StatusOK, t)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	checkError(err, t)
+	useTokens(newEd25519Signer(private, time.Hour), t)
+	authReq(router, token, "GET", "/inventory", http.StatusUnauthorized, t)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TransactionType says who the other side of a transaction is and which way stock moves
+type TransactionType string
+
+const (
+	// a customer buys, stock goes down and the unit price is whatever the item costs right now
+	TransactionSale TransactionType = "sale"
+	// we buy from a supplier, stock goes up and the unit price is what we paid per unit
+	TransactionPurchase TransactionType = "purchase"
+	// a customer brings something back, stock goes up and the unit price is what we refund
+	// (the item's current price unless the refund price is given)
+	TransactionReturn TransactionType = "return"
+)
+
+// TransactionLine is one item on a receipt, the name and unit price are captured when the
+// transaction is recorded so later price changes don't rewrite history
+type TransactionLine struct {
+	PID       string `json:"pid"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice Money  `json:"unitPrice"`
+	Total     Money  `json:"total"`
+}
+
+type Transaction struct {
+	ID        string            `json:"id"`
+	Type      TransactionType   `json:"type"`
+	Lines     []TransactionLine `json:"lines"`
+	Total     Money             `json:"total"`
+	Timestamp time.Time         `json:"timestamp"`
+	// PurchaseOrderID is the order a purchase was received against, it is left out of every other transaction
+	PurchaseOrderID string `json:"purchaseOrderId,omitempty"`
+}
+
+// transactionLedger is every transaction ever recorded, in the order they were recorded.
+// Each one is saved by the store along with the stock it moved, loadTransactionLedger picks them up again on startup
+type transactionLedger struct {
+	mu           sync.RWMutex
+	transactions []Transaction
+	lastID       int
+}
+
+// ledger is where the transaction and purchase order handlers record sales, purchases and returns
+// and where the profit report reads them back. main reloads it from the store it opens
+var ledger = newTransactionLedger()
+
+var errInvalidTransaction = errors.New("invalid transaction")
+
+func newTransactionLedger() *transactionLedger {
+	return &transactionLedger{}
+}
+
+// loadTransactionLedger starts the ledger with the transactions a store saved, new ones are numbered after the last of them
+func loadTransactionLedger(transactions []Transaction) *transactionLedger {
+	l := &transactionLedger{transactions: transactions}
+	for _, tx := range transactions {
+		var id int
+		if _, err := fmt.Sscanf(tx.ID, "TX-%d", &id); err == nil && id > l.lastID {
+			l.lastID = id
+		}
+	}
+	return l
+}
+
+// record moves the stock for every line of tx and saves tx with it in one store.RecordTransaction, and only
+// then adds tx to the ledger, so either the stock moved and the transaction is in the ledger or neither happened
+func (l *transactionLedger) record(inventory Store, tx Transaction) (Transaction, error) {
+	pids := make([]string, len(tx.Lines))
+	for i, line := range tx.Lines {
+		pids[i] = line.PID
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the id is saved with tx, it is only used up once tx is
+	tx.ID = fmt.Sprintf("TX-%06d", l.lastID+1)
+	_, err := inventory.RecordTransaction(pids, &tx, func(items []*Item) error {
+		tx.Total = 0
+		for i, item := range items {
+			if item.DeletedAt != nil {
+				// a discontinued item can't be bought, sold or returned until it is restored
+				return ErrItemNotFound
+			}
+			line := &tx.Lines[i]
+			line.PID = item.PID
+			line.Name = item.Name
+			switch tx.Type {
+			case TransactionSale:
+				if item.Quantity < line.Quantity {
+					return ErrInsufficientStock
+				}
+				item.Quantity -= line.Quantity
+				line.UnitPrice = item.Price
+			case TransactionReturn:
+				if err := _addStock(item, line.Quantity, "quantity"); err != nil {
+					return _lineProblems(i, err)
+				}
+				if line.UnitPrice == 0 {
+					line.UnitPrice = item.Price
+				}
+			case TransactionPurchase:
+				if err := _addStock(item, line.Quantity, "quantity"); err != nil {
+					return _lineProblems(i, err)
+				}
+			}
+			var err error
+			if line.Total, err = line.UnitPrice.CheckedMul(line.Quantity); err != nil {
+				return fmt.Errorf("%w: the total of line %v", err, i)
+			}
+			if tx.Total, err = tx.Total.CheckedAdd(line.Total); err != nil {
+				return fmt.Errorf("%w: the total of the transaction", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	l.lastID++
+	l.transactions = append(l.transactions, tx)
+	return tx, nil
+}
+
+// _lineProblems points the field errors of err at line i, any other error is returned as it is
+func _lineProblems(i int, err error) error {
+	var problems fieldErrors
+	if errors.As(err, &problems) {
+		return fieldErrors(atIndex(i, problems))
+	}
+	return err
+}
+
+// get returns the transaction with the given ID (case-insensitive)
+func (l *transactionLedger) get(id string) (Transaction, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, tx := range l.transactions {
+		if strings.EqualFold(tx.ID, id) {
+			return tx, true
+		}
+	}
+	return Transaction{}, false
+}
+
+// list returns a copy of the ledger, only transactions of txType when it isn't empty
+func (l *transactionLedger) list(txType TransactionType) []Transaction {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	transactions := []Transaction{}
+	for _, tx := range l.transactions {
+		if txType == "" || tx.Type == txType {
+			transactions = append(transactions, tx)
+		}
+	}
+	return transactions
+}
+
+// cashiers post sales and returns, receiving posts purchases from suppliers
+// the stock of every line moves with the transaction, a sale of more than we have is a 409
+func createTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: createTransaction()")
+
+	var tx Transaction
+	err := json.NewDecoder(r.Body).Decode(&tx)
+	if err == nil {
+		err = _checkTransaction(tx)
+	}
+	if err != nil {
+		_writeError(w, "createTransaction", http.StatusBadRequest, codeValidationFailed,
+			"Could not parse the transaction received. Please provide a JSON object with a 'type' of sale, purchase or return "+
+				"and 'lines' that each have a 'pid' and a positive 'quantity'.", fieldError{Message: err.Error()})
+		return
+	}
+	// the stock moves now, so that is when the transaction happened. A timestamp sent with it is
+	// ignored, a backdated sale would land in a report that was already closed
+	tx.Timestamp = time.Now().UTC()
+
+	tx, err = ledger.record(_auditedStore(w, r), tx)
+	if err != nil {
+		_writeStoreError(w, "createTransaction", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(tx)
+}
+
+// _checkTransaction validates everything about a transaction that doesn't depend on the inventory
+func _checkTransaction(tx Transaction) error {
+	switch tx.Type {
+	case TransactionSale, TransactionPurchase, TransactionReturn:
+	default:
+		return fmt.Errorf("%w: unknown type %q", errInvalidTransaction, tx.Type)
+	}
+	if len(tx.Lines) == 0 {
+		return fmt.Errorf("%w: no lines", errInvalidTransaction)
+	}
+	// only receiving a purchase order ties a purchase to it, see purchaseOrderBook.receive
+	if tx.PurchaseOrderID != "" {
+		return fmt.Errorf("%w: purchaseOrderId is set by receiving the purchase order, it can't be posted", errInvalidTransaction)
+	}
+	for i, line := range tx.Lines {
+		if line.PID == "" || line.Quantity <= 0 || line.UnitPrice < 0 {
+			return fmt.Errorf("%w: line %v needs a pid and a positive quantity", errInvalidTransaction, i)
+		}
+		// a sale is always at the catalog price, and we can't know what a supplier charged
+		if tx.Type == TransactionSale && line.UnitPrice != 0 {
+			return fmt.Errorf("%w: line %v of a sale can't set its own unitPrice", errInvalidTransaction, i)
+		}
+		if tx.Type == TransactionPurchase && line.UnitPrice == 0 {
+			return fmt.Errorf("%w: line %v of a purchase needs the unitPrice we paid", errInvalidTransaction, i)
+		}
+		for _, other := range tx.Lines[:i] {
+			if samePID(other.PID, line.PID) {
+				return fmt.Errorf("%w: pid %v is on more than one line", errInvalidTransaction, line.PID)
+			}
+		}
+	}
+	return nil
+}
+
+// lists every transaction in the order they were recorded, ?type= narrows it to one type
+func getTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getTransactions()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(ledger.list(TransactionType(r.URL.Query().Get("type"))))
+}
+
+func getTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getTransaction()")
+
+	id := mux.Vars(r)["id"]
+	tx, found := ledger.get(id)
+	if !found {
+		_writeError(w, "getTransaction", http.StatusNotFound, codeNotFound, "Could not find transaction: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(tx)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useLedger points the handlers at l for the rest of the test and puts the old ledger back afterwards
+func useLedger(l *transactionLedger, t *testing.T) {
+	old := ledger
+	ledger = l
+	t.Cleanup(func() { ledger = old })
+}
+
+// transactionReq posts tx through the router, checks the status and returns the recorded transaction when it succeeded
+func transactionReq(tx Transaction, expStatus int, t *testing.T) Transaction {
+	respRecorder := serveRoute(newRouter(), "POST", "/transactions", tx)
+	checkStatus(respRecorder.Code, expStatus, t, "transactionReq")
+
+	var recorded Transaction
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&recorded)
+		checkResponseError(err, respRecorder, "Transaction", t)
+	}
+	return recorded
+}
+
+// checkQuantity logs an error if the item with the given pid doesn't have the expected quantity on hand
+func checkQuantity(pid string, expected int, t *testing.T, checkpoint string) {
+	item, err := store.Get(pid)
+	checkError(err, t)
+	if item.Quantity != expected {
+		t.Errorf("%v -- quantity of %v: actual - %v | expected - %v", checkpoint, item.Name, item.Quantity, expected)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useLedger(newTransactionLedger(), t)
+	peach, pepper := "E5T6-9UI3-TH15-QR88", "YRT6-72AS-K736-L4AR"
+
+	// 1. a supplier delivers peaches and peppers ===========================================================================
+	t.Log("1. purchase peaches and peppers")
+	purchase := transactionReq(Transaction{
+		Type: TransactionPurchase,
+		Lines: []TransactionLine{
+			{PID: peach, Quantity: 20, UnitPrice: 150},
+			{PID: pepper, Quantity: 10, UnitPrice: 40},
+		},
+	}, http.StatusOK, t)
+	if purchase.ID == "" || purchase.Total != 3400 || purchase.Timestamp.IsZero() {
+		t.Errorf("1 -- unexpected purchase: %+v", purchase)
+	}
+	checkQuantity(peach, 20, t, "1")
+	checkQuantity(pepper, 10, t, "1")
+
+	// 2. a customer buys some, at the catalog price ========================================================================
+	t.Log("2. sell peaches and peppers")
+	sale := transactionReq(Transaction{
+		Type:  TransactionSale,
+		Lines: []TransactionLine{{PID: peach, Quantity: 3}, {PID: "yrt6-72as-k736-l4ar", Quantity: 2}},
+	}, http.StatusOK, t)
+	expectedLines := []TransactionLine{
+		{PID: peach, Name: "Peach", Quantity: 3, UnitPrice: 299, Total: 897},
+		{PID: pepper, Name: "Green Pepper", Quantity: 2, UnitPrice: 79, Total: 158},
+	}
+	if len(sale.Lines) != 2 || sale.Lines[0] != expectedLines[0] || sale.Lines[1] != expectedLines[1] || sale.Total != 1055 {
+		t.Errorf("2 -- actual sale - %+v | expected lines - %+v", sale, expectedLines)
+	}
+	checkQuantity(peach, 17, t, "2")
+	checkQuantity(pepper, 8, t, "2")
+
+	// 3. changing the price later doesn't change the recorded sale ==========================================================
+	t.Log("3. reprice peaches")
+	_, err := store.Modify(peach, func(item *Item) error {
+		item.Price = 349
+		return nil
+	})
+	checkError(err, t)
+	if recorded, _ := ledger.get(sale.ID); recorded.Lines[0].UnitPrice != 299 {
+		t.Errorf("3 -- recorded unit price changed to %v", recorded.Lines[0].UnitPrice)
+	}
+
+	// 4. a sale we can't fill moves no stock at all ==========================================================================
+	t.Log("4. oversell peppers, 409")
+	transactionReq(Transaction{
+		Type:  TransactionSale,
+		Lines: []TransactionLine{{PID: peach, Quantity: 1}, {PID: pepper, Quantity: 9}},
+	}, http.StatusConflict, t)
+	checkQuantity(peach, 17, t, "4")
+	checkQuantity(pepper, 8, t, "4")
+
+	// 5. a return puts stock back, refunded at the given price ===============================================================
+	t.Log("5. return a peach, backdated a year")
+	sent := time.Now().UTC()
+	returned := transactionReq(Transaction{
+		Type:      TransactionReturn,
+		Lines:     []TransactionLine{{PID: peach, Quantity: 1, UnitPrice: 299}},
+		Timestamp: sent.AddDate(-1, 0, 0),
+	}, http.StatusOK, t)
+	if returned.Total != 299 || returned.Timestamp.Before(sent) {
+		t.Errorf("5 -- unexpected return, the timestamp should be when it was posted: %+v", returned)
+	}
+	checkQuantity(peach, 18, t, "5")
+
+	// 6. bad transactions ===================================================================================================
+	t.Log("6. bad transactions")
+	transactionReq(Transaction{Type: "gift", Lines: []TransactionLine{{PID: peach, Quantity: 1}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionSale}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: peach, Quantity: 0}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: peach, Quantity: 1, UnitPrice: 1}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionPurchase, Lines: []TransactionLine{{PID: peach, Quantity: 1}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionPurchase, PurchaseOrderID: "PO-999999", Lines: []TransactionLine{{PID: peach, Quantity: 1, UnitPrice: 142}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: peach, Quantity: 1}, {PID: peach, Quantity: 1}}}, http.StatusBadRequest, t)
+	transactionReq(Transaction{Type: TransactionSale, Lines: []TransactionLine{{PID: "Th1s-P1Dd-N0t3-X1ST", Quantity: 1}}}, http.StatusNotFound, t)
+	// a purchase that would count past the largest quantity points at its line and moves no stock at all
+	respRecorder := serveRoute(newRouter(), "POST", "/transactions", Transaction{Type: TransactionPurchase,
+		Lines: []TransactionLine{{PID: pepper, Quantity: 1, UnitPrice: 40}, {PID: peach, Quantity: math.MaxInt, UnitPrice: 1}}})
+	details := decodeAPIError(respRecorder, codeValidationFailed, t, "6 overflow").Details
+	if len(details) != 1 || details[0].Index == nil || *details[0].Index != 1 || details[0].Field != "quantity" {
+		t.Errorf("6 -- actual details - %+v | expected one for the quantity of line 1", details)
+	}
+	// so would a total that is more money than we can count
+	respRecorder = serveRoute(newRouter(), "POST", "/transactions", Transaction{Type: TransactionPurchase,
+		Lines: []TransactionLine{{PID: pepper, Quantity: 100000000000000000, UnitPrice: 100000}}})
+	decodeAPIError(respRecorder, codeInvalidParameter, t, "6 total overflow")
+	respRecorder = serveRoute(newRouter(), "POST", "/transactions", Transaction{Type: TransactionPurchase,
+		Lines: []TransactionLine{{PID: pepper, Quantity: 1, UnitPrice: math.MaxInt64}, {PID: peach, Quantity: 1, UnitPrice: 1}}})
+	decodeAPIError(respRecorder, codeInvalidParameter, t, "6 sum overflow")
+	checkQuantity(peach, 18, t, "6")
+	checkQuantity(pepper, 8, t, "6")
+
+	// 7. list and get ========================================================================================================
+	t.Log("7. list and get transactions")
+	var all, sales []Transaction
+	respRecorder = serveRoute(newRouter(), "GET", "/transactions", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "7 list")
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&all), t)
+	respRecorder = serveRoute(newRouter(), "GET", "/transactions?type=sale", nil)
+	checkError(json.NewDecoder(respRecorder.Body).Decode(&sales), t)
+	if len(all) != 3 || len(sales) != 1 || sales[0].ID != sale.ID {
+		t.Errorf("7 -- actual - %v transactions, %v sales | expected - 3, 1", len(all), len(sales))
+	}
+	checkStatus(serveRoute(newRouter(), "GET", "/transactions/"+purchase.ID, nil).Code, http.StatusOK, t, "7 get")
+	checkStatus(serveRoute(newRouter(), "GET", "/transactions/TX-999999", nil).Code, http.StatusNotFound, t, "7 get missing")
+}
+
+// recordForRestart records a purchase and a sale straight through the ledger into s, plus a sale that
+// is refused, and returns the ledger. The stores' reopen tests check both come back and the refused one doesn't
+func recordForRestart(s Store, t *testing.T) *transactionLedger {
+	l := newTransactionLedger()
+	peach := "E5T6-9UI3-TH15-QR88"
+	_, err := l.record(s, Transaction{Type: TransactionPurchase, Timestamp: time.Now().UTC(),
+		Lines: []TransactionLine{{PID: peach, Quantity: 10, UnitPrice: 150}}})
+	checkError(err, t)
+	_, err = l.record(s, Transaction{Type: TransactionSale, Timestamp: time.Now().UTC(),
+		Lines: []TransactionLine{{PID: peach, Quantity: 4}}})
+	checkError(err, t)
+	if _, err = l.record(s, Transaction{Type: TransactionSale, Timestamp: time.Now().UTC(),
+		Lines: []TransactionLine{{PID: peach, Quantity: 7}}}); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("oversold -- actual error - %v | expected - %v", err, ErrInsufficientStock)
+	}
+	return l
+}
+
+// checkReloadedLedger logs an error unless reopened has the stock and the transactions l recorded
+// into the store before it was reopened, and a ledger loaded from it carries on numbering after them
+func checkReloadedLedger(reopened Store, l *transactionLedger, t *testing.T) {
+	checkpoint := "reloaded ledger"
+	item, err := reopened.Get("E5T6-9UI3-TH15-QR88")
+	checkError(err, t)
+	if item.Quantity != 6 {
+		t.Errorf("%v -- peaches on hand: actual - %v | expected - 6", checkpoint, item.Quantity)
+	}
+	if actual, expected := reopened.Transactions(), l.list(""); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("%v -- transactions:\nactual - %+v\nexpected - %+v", checkpoint, actual, expected)
+	}
+
+	reloaded := loadTransactionLedger(reopened.Transactions())
+	tx, err := reloaded.record(reopened, Transaction{Type: TransactionSale, Timestamp: time.Now().UTC(),
+		Lines: []TransactionLine{{PID: "E5T6-9UI3-TH15-QR88", Quantity: 1}}})
+	checkError(err, t)
+	if tx.ID != "TX-000003" {
+		t.Errorf("%v -- id after reload: actual - %v | expected - TX-000003", checkpoint, tx.ID)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// employees replace every property of an item in one go (the PID and quantity stay the same)
+// the body is a full "Item" object, same as addItem, the PID in it may be left out
+// send the item's ETag as If-Match and it is only replaced if nobody changed it since (see etag.go)
+func replaceItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: replaceItem()")
+
+	pid := mux.Vars(r)["pid"]
+
+	body, err := io.ReadAll(r.Body)
+	var replacement Item
+	var fields map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(body, &fields)
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &replacement)
+	}
+	if err != nil {
+		_writeError(w, "replaceItem", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the item received. Please provide a JSON object with 'price' and 'name'.", _decodeProblem(err))
+		return
+	}
+
+	item, err := _auditedStore(w, r).Modify(pid, _liveOnly(_ifMatch(r, func(item *Item) error {
+		if replacement.PID == "" {
+			replacement.PID = item.PID
+		}
+		if _, given := _findField(fields, "quantity"); !given {
+			replacement.Quantity = item.Quantity
+		}
+		if err := _checkUpdate(*item, &replacement); err != nil {
+			return err
+		}
+		*item = replacement
+		return nil
+	})))
+	_writeUpdateResult(w, "replaceItem", item, err)
+}
+
+// employees change only some of an item's properties with a JSON Merge Patch (RFC 7386)
+// e.g. {"price": 1.99} changes just the price, a null removes a property (which fails
+// validation for the required ones), the PID and quantity can't be patched
+func patchItem(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: patchItem()")
+
+	pid := mux.Vars(r)["pid"]
+
+	var patch map[string]interface{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&patch); err != nil || patch == nil {
+		_writeError(w, "patchItem", http.StatusBadRequest, codeInvalidJSON, "Could not parse the patch received. Please provide a JSON object.")
+		return
+	}
+
+	item, err := _auditedStore(w, r).Modify(pid, _liveOnly(_ifMatch(r, func(item *Item) error {
+		patched, err := _applyMergePatch(*item, patch)
+		if err != nil {
+			return err
+		}
+		if err := _checkUpdate(*item, &patched); err != nil {
+			return err
+		}
+		*item = patched
+		return nil
+	})))
+	_writeUpdateResult(w, "patchItem", item, err)
+}
+
+// _checkUpdate validates the item an update would leave behind, the same way addItem validates
+// except that the PID is the item's own. The PID and quantity have to match the current ones
+// and the tags are normalized in place. Everything that is wrong comes back as fieldErrors
+func _checkUpdate(current Item, updated *Item) error {
+	var problems fieldErrors
+	if !samePID(current.PID, updated.PID) {
+		problems = append(problems, fieldError{Field: "pid", Message: "can't be changed"})
+	}
+	if updated.Quantity != current.Quantity {
+		problems = append(problems, fieldError{Field: "quantity", Message: "can only be changed through receive, sell and adjust"})
+	}
+	if (updated.DeletedAt == nil) != (current.DeletedAt == nil) {
+		problems = append(problems, fieldError{Field: "deletedAt", Message: "can only be changed through delete and restore"})
+	}
+	updated.DeletedAt = current.DeletedAt
+	problems = append(problems, _checkItemFormat(updated)...)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// _applyMergePatch returns a copy of item with the merge patch applied, top level keys are matched
+// case-insensitively just like encoding/json matches them when we decode a whole Item
+func _applyMergePatch(item Item, patch map[string]interface{}) (Item, error) {
+	var doc map[string]interface{}
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return Item{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return Item{}, err
+	}
+
+	for key, value := range patch {
+		if existing, found := _findField(doc, key); found {
+			key = existing
+		}
+		if value == nil {
+			delete(doc, key)
+		} else {
+			doc[key] = _mergeValue(doc[key], value)
+		}
+	}
+
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return Item{}, err
+	}
+	var patched Item
+	if err := json.Unmarshal(merged, &patched); err != nil {
+		// the patch set a field to something it can't hold, e.g. a price of "free"
+		return Item{}, fieldErrors{_decodeProblem(err)}
+	}
+	return patched, nil
+}
+
+// _mergeValue is the recursive part of RFC 7386, objects merge key by key and anything else replaces
+func _mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObj, isObj := patch.(map[string]interface{})
+	if !isObj {
+		return patch
+	}
+	targetObj, isObj := target.(map[string]interface{})
+	if !isObj {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = _mergeValue(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// _findField looks a key up in a decoded JSON object ignoring case, returning the key as it is spelled there
+func _findField(fields map[string]interface{}, key string) (string, bool) {
+	for existing := range fields {
+		if strings.EqualFold(existing, key) {
+			return existing, true
+		}
+	}
+	return "", false
+}
+
+// _writeUpdateResult responds to PUT and PATCH with the updated item and its new ETag, or with whatever went wrong
+func _writeUpdateResult(w http.ResponseWriter, caller string, item Item, err error) {
+	// an invalid update comes back as fieldErrors, _writeStoreError turns those into a 400 too
+	if err != nil {
+		_writeStoreError(w, caller, err)
+		return
+	}
+	w.Header().Set("ETag", _itemETag(item))
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(item)
+}
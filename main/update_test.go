@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// updateReq sends a PUT or PATCH for pid through the router, checks the status and returns the item when it succeeded
+func updateReq(method string, pid string, body interface{}, expStatus int, t *testing.T) Item {
+	respRecorder := serveRoute(newRouter(), method, "/inventory/"+pid, body)
+	checkStatus(respRecorder.Code, expStatus, t, method+" updateReq")
+
+	var item Item
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&item)
+		checkResponseError(err, respRecorder, "Item", t)
+	}
+	return item
+}
+
+func TestUpdateItem(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	lettuce := defaultInventory()[0]
+	_, err := store.Modify(lettuce.PID, func(item *Item) error {
+		item.Quantity = 7
+		return nil
+	})
+	checkError(err, t)
+
+	// 1. PUT replaces name and price, rounds the price and keeps the quantity ================================================
+	t.Log("1. PUT lettuce")
+	expected := Item{PID: lettuce.PID, Name: "Romaine Lettuce", Price: 399, Quantity: 7}
+	actual := updateReq("PUT", "a12t-4gh7-qpl9-3n4m", map[string]interface{}{"name": "Romaine Lettuce", "price": 3.989}, http.StatusOK, t)
+	compareActualWithExpected([]Item{actual}, []Item{expected}, t, "1")
+	stored, _ := store.Get(lettuce.PID)
+	compareActualWithExpected([]Item{stored}, []Item{expected}, t, "1 stored")
+
+	// 2. bad PUTs are refused and change nothing ============================================================================
+	t.Log("2. bad PUTs")
+	updateReq("PUT", lettuce.PID, Item{PID: "E5T6-9UI3-TH15-QR88", Name: "Peach", Price: 100, Quantity: 7}, http.StatusBadRequest, t)
+	updateReq("PUT", lettuce.PID, map[string]interface{}{"price": 3.99}, http.StatusBadRequest, t)
+	updateReq("PUT", lettuce.PID, map[string]interface{}{"name": "Lettuce", "price": -3.99}, http.StatusBadRequest, t)
+	updateReq("PUT", lettuce.PID, map[string]interface{}{"name": "Lettuce", "price": 3.99, "quantity": 100}, http.StatusBadRequest, t)
+	updateReq("PUT", "Th1s-P1Dd-N0t3-X1ST", Item{Name: "Ghost", Price: 100}, http.StatusNotFound, t)
+	stored, _ = store.Get(lettuce.PID)
+	compareActualWithExpected([]Item{stored}, []Item{expected}, t, "2")
+
+	// 3. PATCH changes only what it's given, key case doesn't matter ==========================================================
+	t.Log("3. PATCH lettuce")
+	expected.Price = 250
+	actual = updateReq("PATCH", lettuce.PID, map[string]interface{}{"Price": 2.5}, http.StatusOK, t)
+	compareActualWithExpected([]Item{actual}, []Item{expected}, t, "3")
+
+	// 4. bad PATCHes are refused and change nothing ===========================================================================
+	t.Log("4. bad PATCHes")
+	updateReq("PATCH", lettuce.PID, map[string]interface{}{"name": nil}, http.StatusBadRequest, t)
+	updateReq("PATCH", lettuce.PID, map[string]interface{}{"pid": "E5T6-9UI3-TH15-QR88"}, http.StatusBadRequest, t)
+	updateReq("PATCH", lettuce.PID, map[string]interface{}{"quantity": 0}, http.StatusBadRequest, t)
+	updateReq("PATCH", lettuce.PID, map[string]interface{}{"price": "free"}, http.StatusBadRequest, t)
+	updateReq("PATCH", lettuce.PID, map[string]interface{}{"price": -5}, http.StatusBadRequest, t)
+	updateReq("PATCH", lettuce.PID, []string{"not", "an", "object"}, http.StatusBadRequest, t)
+	updateReq("PATCH", "Th1s-P1Dd-N0t3-X1ST", map[string]interface{}{"price": 1}, http.StatusNotFound, t)
+	stored, _ = store.Get(lettuce.PID)
+	compareActualWithExpected([]Item{stored}, []Item{expected}, t, "4")
+}
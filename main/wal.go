@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// walStore keeps the inventory in memory and makes every mutation durable by appending it
+// to a write-ahead log before it is applied. Each record is fsynced before the handler gets
+// its answer, so anything we acknowledged survives a kill -9. Every compactEvery records the
+// inventory is written out as a snapshot and the log starts over.
+//
+// On disk the data directory holds:
+//
+//	snapshot.json -- the inventory, its history and the transactions as of record number lastSeq
+//	wal.log       -- every record written since, one after another
+//
+// Each log record is framed as [4 byte payload length][4 byte crc32 of payload][JSON payload].
+// A record that is cut short or fails its checksum can only be the last one (we fsync before
+// writing the next), so on startup the log is replayed up to the first bad record and
+// truncated there. A half-written addItems batch is one record and so is never half applied.
+//
+// Readers go straight to the memoryStore, writers hold mu from validation until the record
+// is applied so two of them can't both pass validation and then conflict in the log
+type walStore struct {
+	mu  sync.Mutex
+	mem *memoryStore
+	dir string
+	log *os.File
+
+	// seq is the number of the last record written, snapshots remember it so records
+	// that were already folded into a snapshot are skipped if the truncate didn't happen
+	seq          uint64
+	sinceCompact int
+	compactEvery int
+
+	// transactions are the ones saved with RecordTransaction, only changed by the writer holding mu
+	transactions []Transaction
+}
+
+const (
+	walLogFile      = "wal.log"
+	walSnapshotFile = "snapshot.json"
+	walHeaderSize   = 8
+	// records bigger than this are treated as corruption instead of being allocated
+	walMaxRecordSize = 64 << 20
+
+	walOpAdd    = "add"
+	walOpUpdate = "update"
+	walOpDelete = "delete"
+)
+
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one mutation, add and update carry the whole batch so it replays all or nothing.
+// The items already have their versions and Time is when the change was made, so a replayed
+// record adds exactly the revisions it did the first time. An update that moved stock for a
+// transaction carries the transaction too, so the two are replayed together
+type walRecord struct {
+	Seq         uint64       `json:"seq"`
+	Op          string       `json:"op"`
+	Time        time.Time    `json:"time"`
+	Items       []Item       `json:"items,omitempty"`
+	PID         string       `json:"pid,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
+type walSnapshot struct {
+	LastSeq      uint64        `json:"lastSeq"`
+	Items        []Item        `json:"items"`
+	History      []Revision    `json:"history,omitempty"`
+	Transactions []Transaction `json:"transactions,omitempty"`
+}
+
+// openWALStore recovers the inventory kept in dir, a brand new directory starts out with the seed items
+func openWALStore(dir string, seed []Item, compactEvery int) (*walStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &walStore{dir: dir, compactEvery: compactEvery}
+
+	snapshot, err := s.readSnapshot()
+	if errors.Is(err, os.ErrNotExist) {
+		s.mem = newMemoryStore(seed)
+		if err = s.writeSnapshot(walSnapshot{Items: s.mem.List(), History: s.mem.revisions()}); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		s.mem = newMemoryStore(snapshot.Items)
+		// a snapshot from before the history was kept starts it over from now
+		if snapshot.History != nil {
+			s.mem.loadHistory(snapshot.History)
+		}
+		s.transactions = snapshot.Transactions
+		s.seq = snapshot.LastSeq
+	}
+
+	s.log, err = os.OpenFile(filepath.Join(dir, walLogFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	return s, syncDir(dir)
+}
+
+// Close releases the log file, the store can't be used afterwards
+func (s *walStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.Close()
+}
+
+func (s *walStore) Get(pid string) (Item, error) {
+	return s.mem.Get(pid)
+}
+
+func (s *walStore) List() []Item {
+	return s.mem.List()
+}
+
+func (s *walStore) ListByTags(tags []string, matchAll bool) []Item {
+	return s.mem.ListByTags(tags, matchAll)
+}
+
+func (s *walStore) FindByName(name string) (Item, error) {
+	return s.mem.FindByName(name)
+}
+
+func (s *walStore) SearchText(query string, limit int) []textResult {
+	return s.mem.SearchText(query, limit)
+}
+
+func (s *walStore) History(pid string) ([]Revision, error) {
+	return s.mem.History(pid)
+}
+
+func (s *walStore) ListAsOf(at time.Time) []Item {
+	return s.mem.ListAsOf(at)
+}
+
+func (s *walStore) Add(item Item) error {
+	return s.AddBatch([]Item{item})
+}
+
+func (s *walStore) AddBatch(items []Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.checkAdd(items); err != nil {
+		return err
+	}
+	// we are the only writer, so reading memory without its write lock is safe here
+	s.mem.stampAdd(items)
+	return s.commit(walRecord{Op: walOpAdd, Items: items})
+}
+
+func (s *walStore) Update(item Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.mem.Get(item.PID); err != nil {
+		return err
+	}
+	item.Version = s.mem.nextVersion(item.PID)
+	return s.commit(walRecord{Op: walOpUpdate, Items: []Item{item}})
+}
+
+func (s *walStore) Delete(pid string) (Item, error) {
+	return s.DeleteIf(pid, nil)
+}
+
+func (s *walStore) DeleteIf(pid string, check func(item Item) error) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted, err := s.mem.Get(pid)
+	if err != nil {
+		return Item{}, err
+	}
+	if check != nil {
+		if err := check(deleted); err != nil {
+			return Item{}, err
+		}
+	}
+	return deleted, s.commit(walRecord{Op: walOpDelete, PID: pid})
+}
+
+func (s *walStore) Modify(pid string, change func(item *Item) error) (Item, error) {
+	return modifyOne(s, pid, change)
+}
+
+func (s *walStore) ModifyBatch(pids []string, change func(items []*Item) error) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// we are the only writer, so reading memory without its write lock is safe here
+	modified, err := s.mem.prepareModify(pids, change)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.commit(walRecord{Op: walOpUpdate, Items: modified}); err != nil {
+		return nil, err
+	}
+	return modified, nil
+}
+
+func (s *walStore) RecordTransaction(pids []string, tx *Transaction, change func(items []*Item) error) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	modified, err := s.mem.prepareModify(pids, change)
+	if err != nil {
+		return nil, err
+	}
+	saved := *tx
+	if err := s.commit(walRecord{Op: walOpUpdate, Items: modified, Transaction: &saved}); err != nil {
+		return nil, err
+	}
+	return modified, nil
+}
+
+func (s *walStore) Transactions() []Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transaction(nil), s.transactions...)
+}
+
+// commit makes the record durable and only then applies it in memory, the caller holds mu
+func (s *walStore) commit(record walRecord) error {
+	record.Seq = s.seq + 1
+	record.Time = time.Now().UTC()
+	if err := s.append(record); err != nil {
+		return err
+	}
+	s.seq = record.Seq
+	if err := s.apply(record); err != nil {
+		// the record was validated against memory before it was written, so this is a bug
+		return fmt.Errorf("wal record %v was logged but could not be applied: %w", record.Seq, err)
+	}
+
+	s.sinceCompact++
+	if s.compactEvery > 0 && s.sinceCompact >= s.compactEvery {
+		// the mutation is already safe in the log, a failed compaction just means a longer replay
+		if err := s.compact(); err != nil {
+			log.Printf("wal compaction failed, will retry on the next write -- %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *walStore) apply(record walRecord) error {
+	at := record.Time
+	if at.IsZero() {
+		// logged before records had a time, now is the closest we can get
+		at = time.Now().UTC()
+	}
+	switch record.Op {
+	case walOpAdd:
+		return s.mem.addAt(record.Items, at)
+	case walOpUpdate:
+		if err := s.mem.updateAt(record.Items, at); err != nil {
+			return err
+		}
+		if record.Transaction != nil {
+			s.transactions = append(s.transactions, *record.Transaction)
+		}
+		return nil
+	case walOpDelete:
+		_, err := s.mem.deleteAt(record.PID, at)
+		return err
+	}
+	return fmt.Errorf("unknown wal op %q", record.Op)
+}
+
+// append writes one framed record at the end of the log and fsyncs it
+func (s *walStore) append(record walRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, walChecksumTable))
+	copy(frame[walHeaderSize:], payload)
+
+	end, err := s.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = s.log.Write(frame); err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		// chop off whatever part of the record made it out so the next append starts clean
+		s.log.Truncate(end)
+		return err
+	}
+	return nil
+}
+
+// replay applies every intact record in the log to memory, then cuts off a torn tail if there is one
+func (s *walStore) replay() error {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(s.log)
+	var good int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				log.Printf("wal: torn record header at offset %v, truncating", good)
+			}
+			break
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > walMaxRecordSize {
+			log.Printf("wal: record at offset %v claims %v bytes, truncating", good, size)
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			log.Printf("wal: torn record at offset %v, truncating", good)
+			break
+		}
+		if crc32.Checksum(payload, walChecksumTable) != binary.BigEndian.Uint32(header[4:8]) {
+			log.Printf("wal: checksum mismatch at offset %v, truncating", good)
+			break
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return fmt.Errorf("wal record at offset %v passed its checksum but can't be decoded: %w", good, err)
+		}
+		// anything at or below seq is already in the snapshot, we crashed after snapshotting but before truncating
+		if record.Seq > s.seq {
+			if err := s.apply(record); err != nil {
+				return fmt.Errorf("wal record %v can't be replayed: %w", record.Seq, err)
+			}
+			s.seq = record.Seq
+			s.sinceCompact++
+		}
+		good += int64(walHeaderSize) + int64(size)
+	}
+
+	if err := s.log.Truncate(good); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// compact folds the log into a new snapshot and empties the log
+func (s *walStore) compact() error {
+	snapshot := walSnapshot{LastSeq: s.seq, Items: s.mem.List(), History: s.mem.revisions(), Transactions: s.transactions}
+	if err := s.writeSnapshot(snapshot); err != nil {
+		return err
+	}
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	s.sinceCompact = 0
+	return nil
+}
+
+func (s *walStore) readSnapshot() (walSnapshot, error) {
+	var snapshot walSnapshot
+	data, err := os.ReadFile(filepath.Join(s.dir, walSnapshotFile))
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+func (s *walStore) writeSnapshot(snapshot walSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, walSnapshotFile), data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// reopenWALStore closes s and opens its directory again, which is exactly what a restart does
+func reopenWALStore(s *walStore, compactEvery int, t *testing.T) *walStore {
+	checkError(s.Close(), t)
+	reopened, err := openWALStore(s.dir, nil, compactEvery)
+	checkError(err, t)
+	return reopened
+}
+
+func TestWALStore(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), nil, 0)
+	checkError(err, t)
+	exerciseStore(s, t)
+
+	reopened := reopenWALStore(s, 0, t)
+	defer reopened.Close()
+	compareActualWithExpected(reopened.List(), s.List(), t, "replayed wal store")
+}
+
+func TestWALStoreSeedsNewDirectory(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 0)
+	checkError(err, t)
+
+	reopened := reopenWALStore(s, 0, t)
+	defer reopened.Close()
+	compareActualWithExpected(reopened.List(), defaultInventory(), t, "seeded wal store")
+}
+
+func TestWALStoreCompaction(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 2)
+	checkError(err, t)
+
+	// the second mutation triggers a compaction, the third is only in the log
+	_, err = s.Delete("A12T-4GH7-QPL9-3N4M")
+	checkError(err, t)
+	checkError(s.Add(Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}), t)
+	_, err = s.Delete("E5T6-9UI3-TH15-QR88")
+	checkError(err, t)
+
+	info, err := os.Stat(filepath.Join(s.dir, walLogFile))
+	checkError(err, t)
+	if info.Size() == 0 {
+		t.Errorf("the record written after compaction should still be in the log")
+	}
+
+	reopened := reopenWALStore(s, 2, t)
+	defer reopened.Close()
+	compareActualWithExpected(reopened.List(), s.List(), t, "compacted wal store")
+}
+
+func TestWALStoreKeepsTransactions(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 0)
+	checkError(err, t)
+	l := recordForRestart(s, t)
+
+	// once from the log and once more after it has been folded into a snapshot
+	replayed := reopenWALStore(s, 0, t)
+	checkReloadedLedger(replayed, l, t)
+	checkError(replayed.compact(), t)
+	expected := replayed.Transactions()
+	compacted := reopenWALStore(replayed, 0, t)
+	defer compacted.Close()
+	if actual := compacted.Transactions(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("compacted wal store -- transactions:\nactual - %+v\nexpected - %+v", actual, expected)
+	}
+}
+
+func TestWALStoreTornBatch(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 0)
+	checkError(err, t)
+	_, err = s.Delete("A12T-4GH7-QPL9-3N4M")
+	checkError(err, t)
+	expected := s.List()
+
+	logPath := filepath.Join(s.dir, walLogFile)
+	info, err := os.Stat(logPath)
+	checkError(err, t)
+	goodSize := info.Size()
+
+	// pretend we were killed halfway through writing an addItems batch
+	checkError(s.AddBatch([]Item{
+		{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133},
+		{PID: "0RNG-0000-0000-0002", Name: "Orange", Price: 89},
+	}), t)
+	checkError(s.Close(), t)
+	info, err = os.Stat(logPath)
+	checkError(err, t)
+	checkError(os.Truncate(logPath, goodSize+(info.Size()-goodSize)/2), t)
+
+	reopened, err := openWALStore(s.dir, nil, 0)
+	checkError(err, t)
+	// none of the batch should come back, and the torn bytes should be gone from the log
+	compareActualWithExpected(reopened.List(), expected, t, "torn batch")
+	info, err = os.Stat(logPath)
+	checkError(err, t)
+	if info.Size() != goodSize {
+		t.Errorf("torn record wasn't truncated: actual size - %v | expected size - %v", info.Size(), goodSize)
+	}
+
+	// and the log keeps working after the truncate
+	checkError(reopened.Add(Item{PID: "P3AR-0000-0000-0001", Name: "Pear", Price: 133}), t)
+	expected = reopened.List()
+	again := reopenWALStore(reopened, 0, t)
+	defer again.Close()
+	compareActualWithExpected(again.List(), expected, t, "after torn batch")
+}
+
+func TestWALStoreCorruptRecord(t *testing.T) {
+	s, err := openWALStore(t.TempDir(), defaultInventory(), 0)
+	checkError(err, t)
+	_, err = s.Delete("A12T-4GH7-QPL9-3N4M")
+	checkError(err, t)
+	expected := s.List()
+	_, err = s.Delete("E5T6-9UI3-TH15-QR88")
+	checkError(err, t)
+	checkError(s.Close(), t)
+
+	// flip the last byte of the log so the final record fails its checksum
+	logPath := filepath.Join(s.dir, walLogFile)
+	data, err := os.ReadFile(logPath)
+	checkError(err, t)
+	data[len(data)-1] ^= 0xff
+	checkError(os.WriteFile(logPath, data, 0o644), t)
+
+	reopened, err := openWALStore(s.dir, nil, 0)
+	checkError(err, t)
+	defer reopened.Close()
+	compareActualWithExpected(reopened.List(), expected, t, "corrupt record")
+}
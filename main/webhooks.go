@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The e-commerce site keeps its own copy of the catalog, so it subscribes to hear about items being added
+// and deleted. Every event is POSTed to the subscription's URL as JSON, signed with the subscription's secret:
+//
+//	X-Webhook-ID: DL-000042 (the delivery, it stays the same when a delivery is retried)
+//	X-Webhook-Timestamp: 1760605964
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret>
+//
+// A delivery that doesn't get a 2xx back is tried again after backoff, twice that, four times that and so on,
+// until maxAttempts have failed, then it is dead-lettered where an admin can look at it and redeliver it.
+// The delivery log only keeps the latest logLimit deliveries, the dead letters stay until they are redelivered.
+// Nothing here is saved, a receiver has to subscribe again after the API restarts
+
+// WebhookEventType is what happened to the item
+type WebhookEventType string
+
+const (
+	// the item was added with addItem or addItems, or a deleted item was restored
+	EventItemAdded WebhookEventType = "item.added"
+	// the item was deleted with deleteItem (or purged while it was still there)
+	EventItemDeleted WebhookEventType = "item.deleted"
+)
+
+const (
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+	// minWebhookSecretLength keeps secrets long enough that the signatures mean something
+	minWebhookSecretLength = 16
+)
+
+// WebhookSubscription is where to send which events. The secret is only ever sent to us, never shown again
+type WebhookSubscription struct {
+	ID        string             `json:"id"`
+	URL       string             `json:"url"`
+	Events    []WebhookEventType `json:"events"`
+	Secret    string             `json:"secret,omitempty"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+// WebhookEvent is the body of every delivery, Item is the item as it is after the change
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Item      Item             `json:"item"`
+	RequestID string           `json:"requestId"`
+}
+
+// DeliveryState is where a delivery is: pending while it is being tried, then delivered or dead.
+// A delivery to a subscription that was deleted before it got through is cancelled
+type DeliveryState string
+
+const (
+	DeliveryPending   DeliveryState = "pending"
+	DeliveryDelivered DeliveryState = "delivered"
+	DeliveryDead      DeliveryState = "dead"
+	DeliveryCancelled DeliveryState = "cancelled"
+)
+
+// DeliveryAttempt is one POST of an event, StatusCode is left out when the receiver couldn't be reached at all
+type DeliveryAttempt struct {
+	Timestamp  time.Time `json:"timestamp"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WebhookDelivery is one event on its way to one subscription, with every attempt made so far
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID string            `json:"subscriptionId"`
+	Event          WebhookEvent      `json:"event"`
+	State          DeliveryState     `json:"state"`
+	Attempts       []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt  *time.Time        `json:"nextAttemptAt,omitempty"`
+}
+
+var (
+	errDeliveryNotFound = errors.New("webhook delivery not found")
+	errDeliveryNotDead  = errors.New("only a dead delivery can be redelivered")
+)
+
+// webhookDispatcher holds the subscriptions and sends every event to those that want it
+type webhookDispatcher struct {
+	mu             sync.Mutex
+	subscriptions  []WebhookSubscription
+	deliveries     []*WebhookDelivery
+	lastSubID      int
+	lastEventID    int
+	lastDeliveryID int
+	client         *http.Client
+	// maxAttempts is how many times a delivery is tried before it is dead-lettered,
+	// backoff how long to wait after the first failure (it doubles after every one after that)
+	maxAttempts int
+	backoff     time.Duration
+	// logLimit is how many deliveries the log keeps, see trimLog
+	logLimit int
+	// running counts the deliveries still being tried, see wait
+	running sync.WaitGroup
+}
+
+// webhooks has the subscriptions made at POST /webhooks, and the deliveries and dead letters of
+// every change auditedStore hands it. The -webhook-attempts, -webhook-backoff and -webhook-log flags tune it
+var webhooks = newWebhookDispatcher(5, time.Second, 1000)
+
+func newWebhookDispatcher(maxAttempts int, backoff time.Duration, logLimit int) *webhookDispatcher {
+	return &webhookDispatcher{client: &http.Client{Timeout: 10 * time.Second}, maxAttempts: maxAttempts, backoff: backoff, logLimit: logLimit}
+}
+
+// signWebhook is the X-Webhook-Signature of body sent at timestamp, a receiver computes the same to check it
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// copy returns delivery with its own attempts, safe to hand out while the dispatcher keeps adding to the original
+func (delivery WebhookDelivery) copy() WebhookDelivery {
+	delivery.Attempts = append([]DeliveryAttempt{}, delivery.Attempts...)
+	return delivery
+}
+
+// subscribe gives sub an ID and starts sending it events, it has already been checked with _checkSubscription
+func (d *webhookDispatcher) subscribe(sub WebhookSubscription) WebhookSubscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSubID++
+	sub.ID = fmt.Sprintf("WH-%04d", d.lastSubID)
+	sub.CreatedAt = time.Now().UTC()
+	d.subscriptions = append(d.subscriptions, sub)
+	sub.Secret = ""
+	return sub
+}
+
+// unsubscribe stops sending events to the subscription, deliveries still being retried for it are cancelled
+func (d *webhookDispatcher) unsubscribe(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, sub := range d.subscriptions {
+		if strings.EqualFold(sub.ID, id) {
+			d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the subscription with the given ID (case-insensitive), the caller holds the lock
+func (d *webhookDispatcher) find(id string) (WebhookSubscription, bool) {
+	for _, sub := range d.subscriptions {
+		if strings.EqualFold(sub.ID, id) {
+			return sub, true
+		}
+	}
+	return WebhookSubscription{}, false
+}
+
+// list returns every subscription without its secret
+func (d *webhookDispatcher) list() []WebhookSubscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	subscriptions := []WebhookSubscription{}
+	for _, sub := range d.subscriptions {
+		sub.Secret = ""
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions
+}
+
+// observe turns what one request changed into events, before and after line up by index and either can be nil.
+// An item that wasn't there (or was deleted) and is now is added, one that was there and now isn't is deleted
+func (d *webhookDispatcher) observe(before []Item, after []Item, requestID string) {
+	count := len(before)
+	if len(after) > count {
+		count = len(after)
+	}
+	for i := 0; i < count; i++ {
+		wasLive := before != nil && before[i].DeletedAt == nil
+		isLive := after != nil && after[i].DeletedAt == nil
+		switch {
+		case !wasLive && isLive:
+			d.publish(EventItemAdded, after[i], requestID)
+		case wasLive && !isLive:
+			// a deleted item is sent as it is now, with its deletedAt, a purged one as it was
+			item := before[i]
+			if after != nil {
+				item = after[i]
+			}
+			d.publish(EventItemDeleted, item, requestID)
+		}
+	}
+}
+
+// publish sends an event to every subscription that wants it, each delivery on its own goroutine
+// so neither the request nor the other subscriptions wait for a slow receiver
+func (d *webhookDispatcher) publish(eventType WebhookEventType, item Item, requestID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var event *WebhookEvent
+	for _, sub := range d.subscriptions {
+		if !_wantsEvent(sub, eventType) {
+			continue
+		}
+		if event == nil {
+			d.lastEventID++
+			event = &WebhookEvent{
+				ID: fmt.Sprintf("EV-%06d", d.lastEventID), Type: eventType, Timestamp: time.Now().UTC(),
+				Item: _snapshot(item), RequestID: requestID,
+			}
+		}
+		d.lastDeliveryID++
+		delivery := &WebhookDelivery{ID: fmt.Sprintf("DL-%06d", d.lastDeliveryID), SubscriptionID: sub.ID, Event: *event,
+			State: DeliveryPending, Attempts: []DeliveryAttempt{}}
+		d.deliveries = append(d.deliveries, delivery)
+		d.running.Add(1)
+		go d.deliver(delivery)
+	}
+	d.trimLog()
+}
+
+// trimLog forgets the oldest delivered and cancelled deliveries once the log has more than logLimit.
+// Pending deliveries are still being tried and dead ones wait to be redelivered, so those are kept
+// even when that leaves the log over its limit. The caller holds the lock
+func (d *webhookDispatcher) trimLog() {
+	excess := len(d.deliveries) - d.logLimit
+	if excess <= 0 {
+		return
+	}
+	kept := d.deliveries[:0]
+	for _, delivery := range d.deliveries {
+		if excess > 0 && (delivery.State == DeliveryDelivered || delivery.State == DeliveryCancelled) {
+			excess--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	// the tail still points at the forgotten deliveries, let them go
+	for i := len(kept); i < len(d.deliveries); i++ {
+		d.deliveries[i] = nil
+	}
+	d.deliveries = kept
+}
+
+func _wantsEvent(sub WebhookSubscription, eventType WebhookEventType) bool {
+	for _, wanted := range sub.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver tries delivery until it gets through, runs out of attempts or its subscription is deleted
+func (d *webhookDispatcher) deliver(delivery *WebhookDelivery) {
+	defer d.running.Done()
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		log.Printf("webhook - could not encode event %v: %v", delivery.Event.ID, err)
+		return
+	}
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		d.mu.Lock()
+		sub, found := d.find(delivery.SubscriptionID)
+		if !found {
+			delivery.State, delivery.NextAttemptAt = DeliveryCancelled, nil
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		result := d.post(sub, delivery.ID, body)
+
+		d.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.NextAttemptAt = nil
+		switch {
+		case result.Error == "":
+			delivery.State = DeliveryDelivered
+		case attempt >= d.maxAttempts:
+			delivery.State = DeliveryDead
+			log.Printf("webhook - gave up on delivery %v of %v to %v after %v attempts: %v",
+				delivery.ID, delivery.Event.ID, sub.URL, attempt, result.Error)
+		default:
+			next := time.Now().UTC().Add(wait)
+			delivery.NextAttemptAt = &next
+		}
+		done := delivery.State != DeliveryPending
+		d.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post sends one signed attempt, anything but a 2xx is a failure
+func (d *webhookDispatcher) post(sub WebhookSubscription, deliveryID string, body []byte) DeliveryAttempt {
+	attempt := DeliveryAttempt{Timestamp: time.Now().UTC()}
+	timestamp := strconv.FormatInt(attempt.Timestamp.Unix(), 10)
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", deliveryID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(sub.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	resp.Body.Close()
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = "the receiver answered " + resp.Status
+	}
+	return attempt
+}
+
+// deliveriesOf returns every delivery to the subscription, oldest first, and whether the subscription exists
+func (d *webhookDispatcher) deliveriesOf(subID string) ([]WebhookDelivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, found := d.find(subID); !found {
+		return nil, false
+	}
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range d.deliveries {
+		if strings.EqualFold(delivery.SubscriptionID, subID) {
+			deliveries = append(deliveries, delivery.copy())
+		}
+	}
+	return deliveries, true
+}
+
+// deadLetters returns every delivery that was given up on, oldest first
+func (d *webhookDispatcher) deadLetters() []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dead := []WebhookDelivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.State == DeliveryDead {
+			dead = append(dead, delivery.copy())
+		}
+	}
+	return dead
+}
+
+// redeliver tries a dead delivery again with a fresh set of attempts, the earlier ones stay in its log
+func (d *webhookDispatcher) redeliver(id string) (WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, delivery := range d.deliveries {
+		if !strings.EqualFold(delivery.ID, id) {
+			continue
+		}
+		if delivery.State != DeliveryDead {
+			return WebhookDelivery{}, errDeliveryNotDead
+		}
+		if _, found := d.find(delivery.SubscriptionID); !found {
+			// the subscription is gone, there's nowhere to deliver it to
+			return WebhookDelivery{}, errDeliveryNotDead
+		}
+		delivery.State = DeliveryPending
+		d.running.Add(1)
+		go d.deliver(delivery)
+		return delivery.copy(), nil
+	}
+	return WebhookDelivery{}, errDeliveryNotFound
+}
+
+// wait blocks until every delivery has been delivered, dead-lettered or cancelled, the tests use it
+func (d *webhookDispatcher) wait() {
+	d.running.Wait()
+}
+
+// _checkSubscription validates a new subscription: an http(s) URL, at least one known event type and a long enough secret
+func _checkSubscription(sub *WebhookSubscription) []fieldError {
+	var problems []fieldError
+	if target, err := url.Parse(sub.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		problems = append(problems, fieldError{Field: "url", Message: "has to be an absolute http or https URL"})
+	}
+	if len(sub.Events) == 0 {
+		problems = append(problems, fieldError{Field: "events", Message: "has to list at least one of item.added and item.deleted"})
+	}
+	events := []WebhookEventType{}
+	for _, event := range sub.Events {
+		switch event {
+		case EventItemAdded, EventItemDeleted:
+			if !_wantsEvent(WebhookSubscription{Events: events}, event) {
+				events = append(events, event)
+			}
+		default:
+			problems = append(problems, fieldError{Field: "events", Message: fmt.Sprintf("%q isn't item.added or item.deleted", event)})
+		}
+	}
+	sub.Events = events
+	if len(sub.Secret) < minWebhookSecretLength {
+		problems = append(problems, fieldError{Field: "secret", Message: fmt.Sprintf("has to be at least %v characters", minWebhookSecretLength)})
+	}
+	return problems
+}
+
+// admins subscribe a receiver to item events, the secret signs every delivery and isn't shown again
+func createWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: createWebhook()")
+
+	var sub WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		_writeError(w, "createWebhook", http.StatusBadRequest, codeInvalidJSON,
+			"Could not parse the subscription received. Please provide a JSON object with a 'url', 'events' and a 'secret'.", _decodeProblem(err))
+		return
+	}
+	if problems := _checkSubscription(&sub); len(problems) > 0 {
+		_writeError(w, "createWebhook", http.StatusBadRequest, codeValidationFailed, "The subscription has invalid fields.", problems...)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(webhooks.subscribe(sub))
+}
+
+func getWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getWebhooks()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(webhooks.list())
+}
+
+// deleting a subscription stops its deliveries, even those still being retried
+func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: deleteWebhook()")
+
+	id := mux.Vars(r)["id"]
+	if !webhooks.unsubscribe(id) {
+		_writeError(w, "deleteWebhook", http.StatusNotFound, codeNotFound, "Could not find webhook subscription: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(webhooks.list())
+}
+
+// the delivery log of one subscription, every event sent to it with every attempt, oldest first
+func getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getWebhookDeliveries()")
+
+	id := mux.Vars(r)["id"]
+	deliveries, found := webhooks.deliveriesOf(id)
+	if !found {
+		_writeError(w, "getWebhookDeliveries", http.StatusNotFound, codeNotFound, "Could not find webhook subscription: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: getDeadLetters()")
+
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(webhooks.deadLetters())
+}
+
+// once the receiver is fixed a dead delivery can be sent again, it gets the full number of attempts
+func redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Println("Function Called: redeliverWebhook()")
+
+	id := mux.Vars(r)["id"]
+	delivery, err := webhooks.redeliver(id)
+	switch {
+	case errors.Is(err, errDeliveryNotDead):
+		_writeError(w, "redeliverWebhook", http.StatusConflict, codeInvalidStatus,
+			"Only a dead delivery whose subscription still exists can be redelivered: "+id)
+		return
+	case errors.Is(err, errDeliveryNotFound):
+		_writeError(w, "redeliverWebhook", http.StatusNotFound, codeNotFound, "Could not find webhook delivery: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusOK) //return 200 OK
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "correct-horse-battery-staple"
+
+// webhookReceiver stands in for the e-commerce site: it checks every signature and keeps the events it accepted.
+// It answers 500 to the next failures requests before it starts accepting them
+type webhookReceiver struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	failures int
+	events   []WebhookEvent
+	t        *testing.T
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{t: t}
+	receiver.server = httptest.NewServer(http.HandlerFunc(receiver.serveHTTP))
+	t.Cleanup(receiver.server.Close)
+	return receiver
+}
+
+func (rc *webhookReceiver) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("receiver -- could not read the delivery: %v", err)
+	}
+	timestamp := r.Header.Get(webhookTimestampHeader)
+	if signature := r.Header.Get(webhookSignatureHeader); signature != signWebhook(testWebhookSecret, timestamp, body) {
+		rc.t.Errorf("receiver -- the signature %q doesn't match the body sent at %q", signature, timestamp)
+	}
+	if r.Header.Get("X-Webhook-ID") == "" {
+		rc.t.Errorf("receiver -- the delivery has no X-Webhook-ID")
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		rc.t.Errorf("receiver -- could not decode the event: %v", err)
+	}
+	rc.events = append(rc.events, event)
+}
+
+// failNext makes the receiver answer 500 to the next n deliveries
+func (rc *webhookReceiver) failNext(n int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.failures = n
+}
+
+// received returns the type and PID of every event accepted so far, e.g. "item.added Lettuce"
+func (rc *webhookReceiver) received() []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	received := []string{}
+	for _, event := range rc.events {
+		received = append(received, string(event.Type)+" "+event.Item.Name)
+	}
+	return received
+}
+
+// useWebhooks starts the test over with no subscriptions and a dispatcher that tries every delivery 3 times,
+// a millisecond apart. Deliveries still being tried are waited for before the old dispatcher is put back
+func useWebhooks(t *testing.T) {
+	old := webhooks
+	webhooks = newWebhookDispatcher(3, time.Millisecond, 1000)
+	t.Cleanup(func() {
+		webhooks.wait()
+		webhooks = old
+	})
+}
+
+// subscribeReq sends POST /webhooks, checks the status and returns the subscription when it succeeded
+func subscribeReq(router http.Handler, sub WebhookSubscription, expStatus int, t *testing.T) WebhookSubscription {
+	respRecorder := serveRoute(router, "POST", "/webhooks", sub)
+	checkStatus(respRecorder.Code, expStatus, t, "subscribeReq "+sub.URL)
+
+	var created WebhookSubscription
+	if expStatus == http.StatusOK {
+		err := json.NewDecoder(respRecorder.Body).Decode(&created)
+		checkResponseError(err, respRecorder, "WebhookSubscription", t)
+	}
+	return created
+}
+
+// deliveriesReq sends GET to a delivery list (a subscription's log or the dead letters) and returns it
+func deliveriesReq(router http.Handler, path string, t *testing.T) []WebhookDelivery {
+	respRecorder := serveRoute(router, "GET", path, nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "deliveriesReq "+path)
+	var deliveries []WebhookDelivery
+	checkResponseError(json.NewDecoder(respRecorder.Body).Decode(&deliveries), respRecorder, "[]WebhookDelivery", t)
+	return deliveries
+}
+
+func TestWebhooks(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useWebhooks(t)
+	router := newRouter()
+	receiver := newWebhookReceiver(t)
+	lettuce := "A12T-4GH7-QPL9-3N4M"
+
+	// 1. subscriptions that aren't valid ============================================================================================
+	t.Log("1. subscribe with a bad URL, an unknown event and a short secret")
+	respRecorder := serveRoute(router, "POST", "/webhooks", WebhookSubscription{URL: "ftp://shop.example", Events: []WebhookEventType{"item.sold"}, Secret: "short"})
+	if details := decodeAPIError(respRecorder, codeValidationFailed, t, "1").Details; len(details) != 3 {
+		t.Errorf("1 -- actual details - %+v | expected the url, the events and the secret", details)
+	}
+	subscribeReq(router, WebhookSubscription{URL: receiver.server.URL, Secret: testWebhookSecret}, http.StatusBadRequest, t)
+
+	// 2. subscribe to both events, the secret isn't shown again ======================================================================
+	t.Log("2. subscribe the receiver")
+	sub := subscribeReq(router, WebhookSubscription{URL: receiver.server.URL, Secret: testWebhookSecret,
+		Events: []WebhookEventType{EventItemAdded, EventItemDeleted, EventItemAdded}}, http.StatusOK, t)
+	if sub.ID != "WH-0001" || sub.Secret != "" || len(sub.Events) != 2 || sub.CreatedAt.IsZero() {
+		t.Errorf("2 -- unexpected subscription: %+v", sub)
+	}
+	if listed := webhooks.list(); len(listed) != 1 || listed[0].Secret != "" {
+		t.Errorf("2 -- actual subscriptions - %+v | expected one without its secret", listed)
+	}
+
+	// 3. adding and deleting items sends signed events, other changes don't ==========================================================
+	t.Log("3. add an item, add two more at once, sell some lettuce and delete the peach")
+	addItemReq(Item{PID: "KIWI-0000-0000-0001", Name: "Kiwi", Price: 50}, t)
+	webhooks.wait()
+	addItemsReq([]Item{{PID: "PLUM-0000-0000-0001", Name: "Plum", Price: 80}, {PID: "LIME-0000-0000-0001", Name: "Lime", Price: 40}}, t)
+	webhooks.wait()
+	stockReq("receive", lettuce, stockRequest{Quantity: 5}, http.StatusOK, t)
+	deleteItemReq("E5T6-9UI3-TH15-QR88", t)
+	webhooks.wait()
+	received := receiver.received()
+	if len(received) != 4 {
+		t.Fatalf("3 -- actual events - %v | expected 4", received)
+	}
+	checkNames(received[:1], []string{"item.added Kiwi"}, t, "3 addItem")
+	if !(received[1] == "item.added Plum" && received[2] == "item.added Lime") && !(received[1] == "item.added Lime" && received[2] == "item.added Plum") {
+		t.Errorf("3 -- actual events - %v | expected the plum and the lime to be added", received[1:3])
+	}
+	checkNames(received[3:], []string{"item.deleted Peach"}, t, "3 deleteItem")
+
+	// 4. the delivery log has every event with its attempt ============================================================================
+	t.Log("4. read the subscription's delivery log")
+	log := deliveriesReq(router, "/webhooks/wh-0001/deliveries", t)
+	if len(log) != 4 {
+		t.Fatalf("4 -- actual deliveries - %+v | expected 4", log)
+	}
+	for _, delivery := range log {
+		if delivery.State != DeliveryDelivered || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusOK || delivery.Event.RequestID == "" {
+			t.Errorf("4 -- unexpected delivery: %+v", delivery)
+		}
+	}
+	decodeAPIError(serveRoute(router, "GET", "/webhooks/WH-0404/deliveries", nil), codeNotFound, t, "4 unknown")
+
+	// 5. a receiver that keeps failing gets retried, then the delivery is dead-lettered =============================================
+	t.Log("5. the receiver fails every attempt at the next event")
+	receiver.failNext(3)
+	addItemReq(Item{PID: "PEAR-0000-0000-0001", Name: "Pear", Price: 90}, t)
+	webhooks.wait()
+	dead := deliveriesReq(router, "/webhooks/dead-letters", t)
+	if len(dead) != 1 || dead[0].State != DeliveryDead || len(dead[0].Attempts) != 3 || dead[0].NextAttemptAt != nil {
+		t.Fatalf("5 -- actual dead letters - %+v | expected one delivery with 3 attempts", dead)
+	}
+	for _, attempt := range dead[0].Attempts {
+		if attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Errorf("5 -- unexpected attempt: %+v", attempt)
+		}
+	}
+	if len(receiver.received()) != 4 {
+		t.Errorf("5 -- actual events - %v | expected the pear not to have arrived", receiver.received())
+	}
+
+	// 6. failing twice still gets through on the third attempt ======================================================================
+	t.Log("6. the receiver fails twice, then accepts")
+	receiver.failNext(2)
+	deleteItemReq("PLUM-0000-0000-0001", t)
+	webhooks.wait()
+	checkNames(receiver.received()[4:], []string{"item.deleted Plum"}, t, "6")
+	if dead := deliveriesReq(router, "/webhooks/dead-letters", t); len(dead) != 1 {
+		t.Errorf("6 -- actual dead letters - %+v | expected only the pear", dead)
+	}
+
+	// 7. once the receiver is fixed the dead letter can be redelivered, but only once ==============================================
+	t.Log("7. redeliver the pear")
+	respRecorder = serveRoute(router, "POST", "/webhooks/dead-letters/"+dead[0].ID+"/redeliver", nil)
+	checkStatus(respRecorder.Code, http.StatusOK, t, "7 redeliver")
+	webhooks.wait()
+	checkNames(receiver.received()[5:], []string{"item.added Pear"}, t, "7")
+	if dead := deliveriesReq(router, "/webhooks/dead-letters", t); len(dead) != 0 {
+		t.Errorf("7 -- actual dead letters - %+v | expected none", dead)
+	}
+	decodeAPIError(serveRoute(router, "POST", "/webhooks/dead-letters/"+dead[0].ID+"/redeliver", nil), codeInvalidStatus, t, "7 twice")
+	decodeAPIError(serveRoute(router, "POST", "/webhooks/dead-letters/DL-999999/redeliver", nil), codeNotFound, t, "7 unknown")
+
+	// 8. a subscription only hears the events it asked for ===========================================================================
+	t.Log("8. subscribe a second receiver to deletions only")
+	deletions := newWebhookReceiver(t)
+	subscribeReq(router, WebhookSubscription{URL: deletions.server.URL, Secret: testWebhookSecret, Events: []WebhookEventType{EventItemDeleted}}, http.StatusOK, t)
+	addItemReq(Item{PID: "FIG0-0000-0000-0001", Name: "Fig", Price: 120}, t)
+	deleteItemReq("LIME-0000-0000-0001", t)
+	webhooks.wait()
+	checkNames(deletions.received(), []string{"item.deleted Lime"}, t, "8")
+
+	// 9. deleting the subscription stops its deliveries =================================================================================
+	t.Log("9. unsubscribe the first receiver")
+	checkStatus(serveRoute(router, "DELETE", "/webhooks/WH-0001", nil).Code, http.StatusOK, t, "9 unsubscribe")
+	decodeAPIError(serveRoute(router, "DELETE", "/webhooks/WH-0001", nil), codeNotFound, t, "9 twice")
+	decodeAPIError(serveRoute(router, "GET", "/webhooks/WH-0001/deliveries", nil), codeNotFound, t, "9 deliveries")
+	before := len(receiver.received())
+	deleteItemReq("FIG0-0000-0000-0001", t)
+	webhooks.wait()
+	if after := len(receiver.received()); after != before {
+		t.Errorf("9 -- the receiver got %v more events after it was unsubscribed", after-before)
+	}
+}
+
+// checkDeliveries logs an error when the log doesn't have exactly the expected deliveries, as "<state> <item name>" oldest first
+func checkDeliveries(deliveries []WebhookDelivery, expected []string, t *testing.T, checkpoint string) {
+	actual := []string{}
+	for _, delivery := range deliveries {
+		actual = append(actual, string(delivery.State)+" "+delivery.Event.Item.Name)
+	}
+	checkNames(actual, expected, t, checkpoint)
+}
+
+// the log keeps the latest deliveries and forgets older ones, but never a dead letter that wasn't redelivered
+func TestWebhookDeliveryLogLimit(t *testing.T) {
+	useWebhooks(t)
+	webhooks.logLimit = 3
+	receiver := newWebhookReceiver(t)
+	sub := webhooks.subscribe(WebhookSubscription{URL: receiver.server.URL, Events: []WebhookEventType{EventItemAdded}, Secret: testWebhookSecret})
+	add := func(name string) {
+		webhooks.observe(nil, []Item{{PID: "LOG0-0000-0000-000" + name[len(name)-1:], Name: name, Price: 100}}, "test")
+		webhooks.wait()
+	}
+
+	// 1. the first delivery is dead-lettered, the next five push the log past its limit =========================================
+	t.Log("1. one dead letter and five deliveries")
+	receiver.failNext(3)
+	add("Item 0")
+	for _, name := range []string{"Item 1", "Item 2", "Item 3", "Item 4", "Item 5"} {
+		add(name)
+	}
+	deliveries, found := webhooks.deliveriesOf(sub.ID)
+	if !found {
+		t.Fatalf("1 -- the subscription %v is gone", sub.ID)
+	}
+	checkDeliveries(deliveries, []string{"dead Item 0", "delivered Item 4", "delivered Item 5"}, t, "1")
+
+	// 2. once it is redelivered the old dead letter is forgotten like any other delivery ==========================================
+	t.Log("2. redeliver the dead letter and add one more")
+	_, err := webhooks.redeliver(deliveries[0].ID)
+	checkError(err, t)
+	webhooks.wait()
+	add("Item 6")
+	deliveries, _ = webhooks.deliveriesOf(sub.ID)
+	checkDeliveries(deliveries, []string{"delivered Item 4", "delivered Item 5", "delivered Item 6"}, t, "2")
+	if dead := webhooks.deadLetters(); len(dead) != 0 {
+		t.Errorf("2 -- actual dead letters - %+v | expected none", dead)
+	}
+}
+
+// the signature is an HMAC of the timestamp and the body, changing either (or the secret) changes it
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"EV-000001"}`)
+	signature := signWebhook(testWebhookSecret, "1760605964", body)
+	if len(signature) != len("sha256=")+64 || signature[:7] != "sha256=" {
+		t.Errorf("signWebhook -- unexpected signature: %v", signature)
+	}
+	if signature != signWebhook(testWebhookSecret, "1760605964", body) {
+		t.Errorf("signWebhook -- the same delivery signed twice doesn't match")
+	}
+	for checkpoint, other := range map[string]string{
+		"timestamp": signWebhook(testWebhookSecret, "1760605965", body),
+		"body":      signWebhook(testWebhookSecret, "1760605964", []byte(`{"id":"EV-000002"}`)),
+		"secret":    signWebhook("another-secret-entirely", "1760605964", body),
+	} {
+		if other == signature {
+			t.Errorf("signWebhook -- a different %v gives the same signature", checkpoint)
+		}
+	}
+}
+
+func TestWebhookPermissions(t *testing.T) {
+	useStore(newMemoryStore(defaultInventory()), t)
+	useWebhooks(t)
+	router, keyOf := authRouter(t)
+	authReq(router, keyOf[RoleEmployee], "GET", "/webhooks", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleExec], "GET", "/webhooks/dead-letters", http.StatusForbidden, t)
+	authReq(router, keyOf[RoleAdmin], "GET", "/webhooks", http.StatusOK, t)
+	authReq(router, keyOf[RoleAdmin], "GET", "/webhooks/dead-letters", http.StatusOK, t)
+	authReq(router, keyOf[RoleAdmin], "DELETE", "/webhooks/WH-0001", http.StatusNotFound, t)
+}